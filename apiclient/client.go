@@ -0,0 +1,409 @@
+// Package apiclient 是授权服务 HTTP 接口的 Go 客户端，类型与 /openapi.json 中的 schema 对应。
+// 离线校验激活码请使用 license-server/client。
+//
+//	c := apiclient.New("https://license.example.com", "lk_xxx_yyy")
+//	lic, err := c.CreateLicense(ctx, apiclient.GenerateRequest{MachineID: "...", Expiry: "2026-12-31"})
+//	lic, err = c.CreateLicense(apiclient.WithIdempotencyKey(ctx, orderID), req) // 重试不会重复签发
+//	var apiErr *apiclient.Error
+//	if errors.As(err, &apiErr) && apiErr.Code == apiclient.CodePolicyViolation { ... }
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================= 客户端 =================
+
+// Client 使用 API Key 调用 /api/v2 接口，可并发使用
+type Client struct {
+	BaseURL    string       // 如 https://license.example.com，不带 /api
+	APIKey     string       // lk_<id>_<secret>
+	HTTPClient *http.Client // 为空时使用 30 秒超时的默认客户端
+	UserAgent  string
+}
+
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), APIKey: apiKey}
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey 为修改类请求附带 Idempotency-Key。超时重试时使用同一个键，
+// 服务端会返回首次请求的结果而不是重复签发
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// 错误码，与服务端 /api/v2 的 error.code 一致
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthenticated  = "unauthenticated"
+	CodeForbidden        = "forbidden"
+	CodePolicyViolation  = "policy_violation"
+	CodeStepUpRequired   = "step_up_required"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal"
+)
+
+// Error 是接口返回的错误
+type Error struct {
+	Status  int    // HTTP 状态码
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s (%d): %s", e.Code, e.Status, e.Message) }
+
+type envelope struct {
+	Data       json.RawMessage `json:"data"`
+	NextCursor string          `json:"next_cursor"`
+	Error      *Error          `json:"error"`
+}
+
+// do 发送请求并把 data 解码到 out，返回 next_cursor
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (string, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil { return "", err }
+		rd = bytes.NewReader(b)
+	}
+	u := c.BaseURL + path
+	if len(query) > 0 { u += "?" + query.Encode() }
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil { return "", err }
+	req.Header.Set("Accept", "application/json")
+	if body != nil { req.Header.Set("Content-Type", "application/json") }
+	if c.APIKey != "" { req.Header.Set("X-API-Key", c.APIKey) }
+	if c.UserAgent != "" { req.Header.Set("User-Agent", c.UserAgent) }
+	if key, _ := ctx.Value(idempotencyKeyCtx{}).(string); key != "" && method != "GET" { req.Header.Set("Idempotency-Key", key) }
+
+	hc := c.HTTPClient
+	if hc == nil { hc = defaultHTTPClient }
+	resp, err := hc.Do(req)
+	if err != nil { return "", err }
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil { return "", err }
+
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		if resp.StatusCode >= 400 { return "", &Error{Status: resp.StatusCode, Code: CodeInternal, Message: strings.TrimSpace(string(raw))} }
+		return "", fmt.Errorf("无法解析响应: %w", err)
+	}
+	if env.Error != nil { env.Error.Status = resp.StatusCode; return "", env.Error }
+	if resp.StatusCode >= 400 { return "", &Error{Status: resp.StatusCode, Code: CodeInternal, Message: resp.Status} }
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil { return "", fmt.Errorf("无法解析响应: %w", err) }
+	}
+	return env.NextCursor, nil
+}
+
+// ListOptions 是列表接口的公共参数
+type ListOptions struct {
+	Limit  int    // 1-200，0 为服务端默认 (50)
+	Sort   string // 字段名，加 - 前缀为降序
+	Cursor string // 上一页返回的游标
+}
+
+func (o ListOptions) values() url.Values {
+	q := url.Values{}
+	if o.Limit > 0 { q.Set("limit", strconv.Itoa(o.Limit)) }
+	if o.Sort != "" { q.Set("sort", o.Sort) }
+	if o.Cursor != "" { q.Set("cursor", o.Cursor) }
+	return q
+}
+
+func setIf(q url.Values, key, value string) {
+	if value != "" { q.Set(key, value) }
+}
+
+// ================= 激活码 =================
+
+// GenerateRequest 签发参数，Expiry 为北京时间的 yyyy-mm-dd
+type GenerateRequest struct {
+	MachineID string `json:"machine_id"`
+	Expiry    string `json:"expiry"`
+	Format    string `json:"format,omitempty"` // classic / short / jwt / jwt_eddsa / paseto，为空时按产品配置
+	Features  uint16 `json:"features,omitempty"`
+	Customer  string `json:"customer,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
+
+// License 是一条签发记录
+type License struct {
+	ID           string `json:"id"`
+	GenerateTime string `json:"generate_time"`
+	MachineID    string `json:"machine_id"`
+	ExpiryDate   string `json:"expiry_date"`
+	LicenseCode  string `json:"license_code"`
+	Customer     string `json:"customer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Format       string `json:"format,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	KeyID        string `json:"key_id,omitempty"`
+	Issuer       string `json:"issuer,omitempty"`
+	Status       string `json:"status,omitempty"` // active / revoked
+	Source       string `json:"source,omitempty"`
+	IssuedBy     string `json:"issued_by,omitempty"`
+	DeletedAt    string `json:"deleted_at,omitempty"`
+	ArchivedAt   string `json:"archived_at,omitempty"`
+}
+
+// LicenseFilter 是 ListLicenses 的过滤条件，空字段不限制
+type LicenseFilter struct {
+	ListOptions
+	View          string // active (默认) / archive / trash / all
+	Status        string
+	MachineID     string
+	Customer      string
+	Product       string
+	IssuedBy      string
+	Source        string
+	Format        string
+	Query         string // 模糊匹配
+	ExpiresAfter  string // yyyy-mm-dd (含)
+	ExpiresBefore string // yyyy-mm-dd (含)
+}
+
+// ListLicenses 返回一页激活码和下一页的游标 (没有更多时为空)
+func (c *Client) ListLicenses(ctx context.Context, f LicenseFilter) ([]License, string, error) {
+	q := f.values()
+	setIf(q, "view", f.View)
+	setIf(q, "status", f.Status)
+	setIf(q, "machine_id", f.MachineID)
+	setIf(q, "customer", f.Customer)
+	setIf(q, "product", f.Product)
+	setIf(q, "issued_by", f.IssuedBy)
+	setIf(q, "source", f.Source)
+	setIf(q, "format", f.Format)
+	setIf(q, "q", f.Query)
+	setIf(q, "expires_after", f.ExpiresAfter)
+	setIf(q, "expires_before", f.ExpiresBefore)
+	var out []License
+	next, err := c.do(ctx, "GET", "/api/v2/licenses", q, nil, &out)
+	return out, next, err
+}
+
+// CreateLicense 签发激活码。超出签发策略时返回 CodePolicyViolation，超出配额时返回 CodeQuotaExceeded
+func (c *Client) CreateLicense(ctx context.Context, req GenerateRequest) (*License, error) {
+	var out License
+	if _, err := c.do(ctx, "POST", "/api/v2/licenses", nil, req, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+func (c *Client) GetLicense(ctx context.Context, id string) (*License, error) {
+	var out License
+	if _, err := c.do(ctx, "GET", "/api/v2/licenses/"+url.PathEscape(id), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+func (c *Client) RevokeLicense(ctx context.Context, id string) (*License, error) {
+	var out License
+	if _, err := c.do(ctx, "POST", "/api/v2/licenses/"+url.PathEscape(id)+"/revoke", nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+// DeleteLicense 把记录移入回收站
+func (c *Client) DeleteLicense(ctx context.Context, id string) (*License, error) {
+	var out License
+	if _, err := c.do(ctx, "DELETE", "/api/v2/licenses/"+url.PathEscape(id), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+// ================= 机器码 =================
+
+type Machine struct {
+	MachineID string    `json:"machine_id"`
+	LastSeen  string    `json:"last_seen"`
+	DeletedAt string    `json:"deleted_at,omitempty"`
+	Licenses  []License `json:"licenses,omitempty"` // 仅 GetMachine 且有 history.read 权限时返回
+}
+
+type MachineFilter struct {
+	ListOptions
+	View  string // active (默认) / trash / all
+	Query string
+}
+
+func (c *Client) ListMachines(ctx context.Context, f MachineFilter) ([]Machine, string, error) {
+	q := f.values()
+	setIf(q, "view", f.View)
+	setIf(q, "q", f.Query)
+	var out []Machine
+	next, err := c.do(ctx, "GET", "/api/v2/machines", q, nil, &out)
+	return out, next, err
+}
+
+func (c *Client) GetMachine(ctx context.Context, id string) (*Machine, error) {
+	var out Machine
+	if _, err := c.do(ctx, "GET", "/api/v2/machines/"+url.PathEscape(id), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+// DeleteMachine 把机器码移入回收站
+func (c *Client) DeleteMachine(ctx context.Context, id string) (*Machine, error) {
+	var out Machine
+	if _, err := c.do(ctx, "DELETE", "/api/v2/machines/"+url.PathEscape(id), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+// ================= 客户 =================
+
+// Customer 由激活码记录按客户名称汇总
+type Customer struct {
+	Name           string    `json:"name"`
+	Licenses       int       `json:"licenses"`
+	ActiveLicenses int       `json:"active_licenses"`
+	Machines       int       `json:"machines"`
+	Products       []string  `json:"products"`
+	FirstIssued    string    `json:"first_issued"`
+	LastIssued     string    `json:"last_issued"`
+	LatestExpiry   string    `json:"latest_expiry"`
+	Records        []License `json:"records,omitempty"` // 仅 GetCustomer 返回
+}
+
+type CustomerFilter struct {
+	ListOptions
+	Query   string
+	Product string
+}
+
+func (c *Client) ListCustomers(ctx context.Context, f CustomerFilter) ([]Customer, string, error) {
+	q := f.values()
+	setIf(q, "q", f.Query)
+	setIf(q, "product", f.Product)
+	var out []Customer
+	next, err := c.do(ctx, "GET", "/api/v2/customers", q, nil, &out)
+	return out, next, err
+}
+
+func (c *Client) GetCustomer(ctx context.Context, name string) (*Customer, error) {
+	var out Customer
+	if _, err := c.do(ctx, "GET", "/api/v2/customers/"+url.PathEscape(name), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+// ================= API Key =================
+
+type APIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"` // generate / verify / history.read
+	Products  []string `json:"products,omitempty"`
+	MaxDays   int      `json:"max_days,omitempty"`
+	ExpiresAt string   `json:"expires_at,omitempty"` // yyyy-mm-dd 或 RFC 3339
+}
+
+type APIKey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	Products   []string `json:"products,omitempty"`
+	MaxDays    int      `json:"max_days,omitempty"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	Key        string   `json:"key,omitempty"` // 完整 Key，仅创建时返回
+}
+
+type KeyFilter struct {
+	ListOptions
+	Status string // active / revoked / expired
+}
+
+func (c *Client) ListKeys(ctx context.Context, f KeyFilter) ([]APIKey, string, error) {
+	q := f.values()
+	setIf(q, "status", f.Status)
+	var out []APIKey
+	next, err := c.do(ctx, "GET", "/api/v2/keys", q, nil, &out)
+	return out, next, err
+}
+
+func (c *Client) CreateKey(ctx context.Context, req APIKeyRequest) (*APIKey, error) {
+	var out APIKey
+	if _, err := c.do(ctx, "POST", "/api/v2/keys", nil, req, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+func (c *Client) GetKey(ctx context.Context, id string) (*APIKey, error) {
+	var out APIKey
+	if _, err := c.do(ctx, "GET", "/api/v2/keys/"+url.PathEscape(id), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+func (c *Client) RevokeKey(ctx context.Context, id string) (*APIKey, error) {
+	var out APIKey
+	if _, err := c.do(ctx, "DELETE", "/api/v2/keys/"+url.PathEscape(id), nil, nil, &out); err != nil { return nil, err }
+	return &out, nil
+}
+
+// ================= 在线校验 =================
+
+type VerifyResult struct {
+	Valid      bool   `json:"valid"`
+	LicenseID  string `json:"license_id,omitempty"`
+	Status     string `json:"status,omitempty"`
+	Format     string `json:"format,omitempty"`
+	MachineID  string `json:"machine_id,omitempty"`
+	ExpiryDate string `json:"expiry_date,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	Expired    bool   `json:"expired"`
+	Features   uint16 `json:"features"`
+	Product    string `json:"product,omitempty"`
+	KeyID      string `json:"key_id,omitempty"`
+	Error      string `json:"error,omitempty"` // 校验失败的原因
+}
+
+// Verify 调用 /api/verify 在线校验激活码；machineID 为空时不比对机器码。
+// 该接口不是 v2 格式，校验不通过时 err 为空、Valid 为 false
+func (c *Client) Verify(ctx context.Context, code, machineID string) (*VerifyResult, error) {
+	b, err := json.Marshal(map[string]string{"code": code, "machine_id": machineID})
+	if err != nil { return nil, err }
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/verify", bytes.NewReader(b))
+	if err != nil { return nil, err }
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" { req.Header.Set("X-API-Key", c.APIKey) }
+	if c.UserAgent != "" { req.Header.Set("User-Agent", c.UserAgent) }
+	hc := c.HTTPClient
+	if hc == nil { hc = defaultHTTPClient }
+	resp, err := hc.Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil { return nil, err }
+	if resp.StatusCode != 200 { return nil, &Error{Status: resp.StatusCode, Code: legacyCode(resp.StatusCode), Message: strings.TrimSpace(string(raw))} }
+	var out VerifyResult
+	if err := json.Unmarshal(raw, &out); err != nil { return nil, fmt.Errorf("无法解析响应: %w", err) }
+	return &out, nil
+}
+
+// legacyCode 把旧接口的状态码映射为错误码
+func legacyCode(status int) string {
+	switch status {
+	case 400, 413: return CodeInvalidRequest
+	case 401: return CodeUnauthenticated
+	case 403: return CodeForbidden
+	case 404: return CodeNotFound
+	case 405: return CodeMethodNotAllowed
+	case 429: return CodeRateLimited
+	}
+	return CodeInternal
+}
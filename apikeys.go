@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================= API Key =================
+//
+// 供计费系统等机器调用方使用，替代共享 Token。完整的 Key 形如 lk_<id>_<secret>，只在创建时显示一次；
+// 服务端保存为 api_keys 文档 (id 为 <id>)，只存 secret 的 SHA-256。secret 为 32 字节随机数，无需慢哈希。
+// 请求通过 Authorization: Bearer <key> 或 X-API-Key: <key> 携带。
+// 每个 Key 只拥有创建时勾选的操作范围，并可限制产品和最长有效期。
+
+const apiKeyDocKind = "api_keys"
+
+// Key 可授予的操作范围，对应的权限见 apiKeyScopes
+const (
+	ScopeGenerate = "generate"
+	ScopeVerify   = "verify"
+	ScopeHistory  = "history.read"
+)
+
+var apiKeyScopes = map[string]string{ScopeGenerate: PermGenerate, ScopeVerify: PermVerify, ScopeHistory: PermHistoryRead}
+
+// VerifyRequireAuth 为 true 时在线校验接口需要带 verify 范围的 Key (或有该权限的用户)，默认公开
+var VerifyRequireAuth = os.Getenv("VERIFY_REQUIRE_AUTH") == "true"
+
+// apiKeyTouchInterval 最后使用时间的最小刷新间隔，避免每个请求都写一次存储
+const apiKeyTouchInterval = time.Minute
+
+type APIKey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	SecretHash string   `json:"secret_hash"` // hex(SHA-256(secret))
+	Scopes     []string `json:"scopes"`
+	Products   []string `json:"products,omitempty"` // 为空表示不限产品
+	MaxDays    int      `json:"max_days,omitempty"` // 签发的最长有效期 (天)，0 表示只受全局限制
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"` // RFC 3339，为空表示永不过期
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope { return true }
+	}
+	return false
+}
+
+// usable 判断 Key 当前是否可用 (未吊销、未过期)
+func (k *APIKey) usable(now time.Time) bool {
+	if k.RevokedAt != "" { return false }
+	if k.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, k.ExpiresAt)
+		if err != nil || !now.Before(t) { return false }
+	}
+	return true
+}
+
+// checkIssue 检查 Key 的产品与最长有效期限制，用户账号不受此限制
+func (p *Principal) checkIssue(product string, expiryUTC int64) error {
+	if p.Key == nil { return nil }
+	if len(p.Key.Products) > 0 {
+		allowed := false
+		for _, prod := range p.Key.Products {
+			if prod == product { allowed = true; break }
+		}
+		if !allowed { return fmt.Errorf("该 API Key 不允许签发产品 %q", product) }
+	}
+	if p.Key.MaxDays > 0 {
+		// 按日期比较：到期日期最晚为今天 (北京时间) 之后第 MaxDays 天
+		loc := expiryLocation()
+		expiry := time.Unix(expiryUTC, 0).In(loc).Format("2006-01-02")
+		if latest := time.Now().In(loc).AddDate(0, 0, p.Key.MaxDays).Format("2006-01-02"); expiry > latest {
+			return fmt.Errorf("超出该 API Key 允许的最长有效期 (%d 天)", p.Key.MaxDays)
+		}
+	}
+	return nil
+}
+
+func hashAPISecret(secret string) string { h := sha256.Sum256([]byte(secret)); return hex.EncodeToString(h[:]) }
+
+// newAPIKey 生成 ID 与完整 Key
+func newAPIKey() (id, full, secretHash string) {
+	idBytes := make([]byte, 5)
+	secret := make([]byte, 32)
+	rand.Read(idBytes)
+	rand.Read(secret)
+	id = strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(idBytes))
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	return id, "lk_" + id + "_" + s, hashAPISecret(s)
+}
+
+// apiKeyFromRequest 取出请求携带的 Key，没有时返回空
+func apiKeyFromRequest(r *http.Request) string {
+	if v := r.Header.Get("X-API-Key"); v != "" { return strings.TrimSpace(v) }
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(v, "lk_") { return strings.TrimSpace(v) }
+	return ""
+}
+
+// authenticateAPIKey 校验 Key 并刷新最后使用时间，无效时返回 nil
+func authenticateAPIKey(full string) *Principal {
+	rest, ok := strings.CutPrefix(full, "lk_")
+	if !ok { return nil }
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok { return nil }
+	var k APIKey
+	found, err := store.GetDoc(apiKeyDocKind, id, &k)
+	if err != nil { log.Printf("❌ 读取 API Key 失败: %v", err); return nil }
+	if !found || subtle.ConstantTimeCompare([]byte(hashAPISecret(secret)), []byte(k.SecretHash)) != 1 { return nil }
+	now := time.Now().UTC()
+	if !k.usable(now) { return nil }
+	if last, err := time.Parse(time.RFC3339, k.LastUsedAt); err != nil || now.Sub(last) >= apiKeyTouchInterval {
+		k.LastUsedAt = now.Format(time.RFC3339)
+		if err := store.PutDoc(apiKeyDocKind, k.ID, &k); err != nil { log.Printf("❌ 更新 API Key 使用时间失败: %v", err) }
+	}
+	return &Principal{Name: "key:" + k.Name, Role: "apikey", Key: &k}
+}
+
+func listAPIKeys() ([]APIKey, error) {
+	docs, err := store.ListDocs(apiKeyDocKind)
+	if err != nil { return nil, err }
+	keys := make([]APIKey, 0, len(docs))
+	for _, d := range docs {
+		var k APIKey
+		if err := json.Unmarshal(d.Body, &k); err != nil { return nil, fmt.Errorf("API Key %s 数据损坏: %v", d.ID, err) }
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt > keys[j].CreatedAt })
+	return keys, nil
+}
+
+// ================= 管理接口 =================
+
+// APIKeyRequest 是创建 Key 的请求体，ExpiresAt 可以是 yyyy-mm-dd 或 RFC 3339
+type APIKeyRequest struct {
+	Token     string   `json:"token,omitempty"`
+	ID        string   `json:"id,omitempty"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Products  []string `json:"products,omitempty"`
+	MaxDays   int      `json:"max_days,omitempty"`
+	ExpiresAt string   `json:"expires_at,omitempty"`
+}
+
+// APIKeyView 是返回给管理端的 Key 信息，不含哈希
+type APIKeyView struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	Products   []string `json:"products,omitempty"`
+	MaxDays    int      `json:"max_days,omitempty"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	Key        string   `json:"key,omitempty"` // 仅创建时返回
+}
+
+func (k *APIKey) view() APIKeyView {
+	return APIKeyView{ID: k.ID, Name: k.Name, Scopes: k.Scopes, Products: k.Products, MaxDays: k.MaxDays, CreatedBy: k.CreatedBy, CreatedAt: k.CreatedAt, ExpiresAt: k.ExpiresAt, LastUsedAt: k.LastUsedAt, RevokedAt: k.RevokedAt}
+}
+
+// createAPIKey 校验请求并保存新 Key，返回含完整 Key 的视图
+func createAPIKey(req APIKeyRequest, createdBy string) (*APIKeyView, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" { return nil, fmt.Errorf("名称不能为空") }
+	keys, err := listAPIKeys()
+	if err != nil { return nil, err }
+	for _, k := range keys {
+		if k.Name == name && k.RevokedAt == "" { return nil, fmt.Errorf("名称 %s 已被使用", name) }
+	}
+	if len(req.Scopes) == 0 { return nil, fmt.Errorf("至少选择一个操作范围") }
+	for _, s := range req.Scopes {
+		if _, ok := apiKeyScopes[s]; !ok { return nil, fmt.Errorf("未知的操作范围: %s", s) }
+	}
+	var products []string
+	for _, p := range req.Products {
+		if p = strings.TrimSpace(p); p != "" { products = append(products, p) }
+	}
+	if req.MaxDays < 0 { return nil, fmt.Errorf("最长有效期不能为负数") }
+	expiresAt := ""
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			if t, err = time.ParseInLocation("2006-01-02", req.ExpiresAt, time.Local); err != nil { return nil, fmt.Errorf("过期时间格式错误: %s", req.ExpiresAt) }
+			t = t.AddDate(0, 0, 1)
+		}
+		if !t.After(time.Now()) { return nil, fmt.Errorf("过期时间必须晚于当前时间") }
+		expiresAt = t.UTC().Format(time.RFC3339)
+	}
+
+	id, full, hash := newAPIKey()
+	k := APIKey{ID: id, Name: name, SecretHash: hash, Scopes: req.Scopes, Products: products, MaxDays: req.MaxDays, CreatedBy: createdBy, CreatedAt: time.Now().UTC().Format(time.RFC3339), ExpiresAt: expiresAt}
+	if err := store.PutDoc(apiKeyDocKind, id, &k); err != nil { return nil, err }
+	v := k.view()
+	v.Key = full
+	return &v, nil
+}
+
+// revokeAPIKey 吊销 Key (已吊销的保持原吊销时间)，不存在时返回 nil
+func revokeAPIKey(id string) (*APIKey, error) {
+	var k APIKey
+	found, err := store.GetDoc(apiKeyDocKind, id, &k)
+	if err != nil || !found { return nil, err }
+	if k.RevokedAt == "" {
+		k.RevokedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := store.PutDoc(apiKeyDocKind, k.ID, &k); err != nil { return nil, err }
+	}
+	return &k, nil
+}
+
+// handleAPIKeys GET 返回 Key 列表；POST /api/keys/create 创建；POST /api/keys/revoke 吊销
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		if authorize(w, r, PermKeys, "") == nil { return }
+		keys, err := listAPIKeys()
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		views := make([]APIKeyView, len(keys))
+		for i := range keys { views[i] = keys[i].view() }
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(views)
+		return
+	}
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p := authorize(w, r, PermKeys, req.Token)
+	if p == nil { return }
+
+	switch r.URL.Path {
+	case "/api/keys/create":
+		v, err := createAPIKey(req, p.Name)
+		if err != nil { http.Error(w, err.Error(), 400); return }
+		audit(r, p, "key.create", v.ID, fmt.Sprintf("name=%s scopes=%v products=%v max_days=%d", v.Name, v.Scopes, v.Products, v.MaxDays))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(v)
+	case "/api/keys/revoke":
+		k, err := revokeAPIKey(req.ID)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if k == nil { http.Error(w, "API Key 不存在", 404); return }
+		audit(r, p, "key.revoke", k.ID, k.Name)
+		w.Write([]byte("✅ API Key 已吊销"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleKeysPage API Key 管理页面
+func handleKeysPage(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermKeys, "") == nil { return }
+	keys, err := listAPIKeys()
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	now := time.Now()
+	rowsHtml := ""
+	for _, k := range keys {
+		status := `<span style="color:green">有效</span>`
+		action := fmt.Sprintf(`<button onclick="revokeKey('%s')" class="del-btn">吊销</button>`, k.ID)
+		if k.RevokedAt != "" {
+			status, action = `<span style="color:#c00">已吊销</span>`, ""
+		} else if !k.usable(now) {
+			status = `<span style="color:#c77700">已过期</span>`
+		}
+		products, maxDays, expires, lastUsed := "全部", "-", "永不", "从未"
+		if len(k.Products) > 0 { products = html.EscapeString(strings.Join(k.Products, ", ")) }
+		if k.MaxDays > 0 { maxDays = fmt.Sprintf("%d 天", k.MaxDays) }
+		if k.ExpiresAt != "" { expires = k.ExpiresAt }
+		if k.LastUsedAt != "" { lastUsed = k.LastUsedAt }
+		rowsHtml += fmt.Sprintf(`<tr><td>%s<div style="font-family:monospace;font-size:12px;color:#888">lk_%s_…</div></td><td>%s</td><td>%s</td><td>%s</td><td style="font-size:12px">%s</td><td style="font-size:12px">%s</td><td>%s</td><td style="font-size:12px">%s</td><td style="text-align:center">%s</td></tr>`,
+			html.EscapeString(k.Name), k.ID, strings.Join(k.Scopes, ", "), products, maxDays, expires, lastUsed, status, html.EscapeString(k.CreatedBy), action)
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>API Key</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1000px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}input{padding:6px 8px;border:1px solid #ccc;border-radius:4px}label{margin-right:12px;font-size:14px}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .del-btn:hover{background:#ff3b30;color:white}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .ok-btn:hover{background:#0071e3;color:white}#newkey{display:none;margin-top:15px;padding:10px;background:#eef6ff;border-radius:6px;font-family:monospace;word-break:break-all}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🔑 API Key <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2><table><thead><tr><th>名称</th><th>范围</th><th>产品</th><th>最长有效期</th><th>过期时间</th><th>最后使用</th><th>状态</th><th>创建人</th><th></th></tr></thead><tbody>%s</tbody></table></div>
+	<div class="card"><h3>新建 API Key</h3>
+	<p><input id="kname" placeholder="名称，如 billing"></p>
+	<p><label><input type="checkbox" name="scope" value="generate" checked> 签发</label><label><input type="checkbox" name="scope" value="verify"> 校验</label><label><input type="checkbox" name="scope" value="history.read"> 读取历史</label></p>
+	<p><input id="kprod" placeholder="限定产品 (逗号分隔，留空不限)" style="width:260px"> <input id="kdays" type="number" min="0" placeholder="最长有效期 (天)"> 过期日期 <input id="kexp" type="date"></p>
+	<button onclick="createKey()" class="ok-btn">创建</button><div id="newkey"></div></div>
+	<script>async function post(url,body){let res = await fetch(url, {method: 'POST', headers: {'Content-Type': 'application/json'},body: JSON.stringify(body)});if(!res.ok) throw new Error(await res.text());return res}
+	async function revokeKey(id){if(!confirm('吊销后使用该 Key 的调用方将立即失效，确定吗？'))return;try{await post('/api/keys/revoke',{id:id});location.reload()}catch(e){alert(e.message)}}
+	async function createKey(){var scopes=[...document.querySelectorAll('input[name=scope]:checked')].map(e=>e.value);
+	var body={name:document.getElementById('kname').value,scopes:scopes,products:document.getElementById('kprod').value.split(','),max_days:+document.getElementById('kdays').value||0,expires_at:document.getElementById('kexp').value};
+	try{var k=await (await post('/api/keys/create',body)).json();var box=document.getElementById('newkey');box.style.display='block';box.innerText='请立即复制保存，此 Key 只显示这一次：\n'+k.key}catch(e){alert(e.message)}}</script></body></html>`, rowsHtml)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
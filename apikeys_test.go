@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// max_days=30 时可以签发到 30 天后 (含当天)，31 天后则拒绝
+func TestCheckIssueMaxDaysByCalendarDate(t *testing.T) {
+	p := &Principal{Key: &APIKey{MaxDays: 30}}
+	today := time.Now().In(expiryLocation())
+	for days, ok := range map[int]bool{0: true, 30: true, 31: false} {
+		exp, err := expiryTime(today.AddDate(0, 0, days).Format("2006-01-02"))
+		if err != nil { t.Fatal(err) }
+		if err := p.checkIssue("", exp); (err == nil) != ok { t.Errorf("%d 天后到期: err = %v", days, err) }
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================= REST API v2 =================
+//
+// /api/v2 下是面向资源的 JSON 接口 (licenses、machines、customers、keys)，旧接口保持不变，继续供现有页面使用。
+// 成功时返回 {"data": ...}，列表在还有下一页时另带 next_cursor；失败时统一返回 {"error": {"code": ..., "message": ...}}。
+// 列表支持 limit (默认 50，最多 200)、sort (字段名，加 - 前缀为降序)、cursor (上一页的 next_cursor) 以及各资源的筛选参数。
+// 游标记录上一页最后一条的排序值和 ID，翻页期间有增删也不会重复或遗漏。
+// 认证、权限、签发策略、二次验证和配额与旧接口共用同一套逻辑，它们写出的纯文本错误由 v2Errors 转换为错误对象。
+
+// 错误码
+const (
+	ErrInvalidRequest   = "invalid_request"
+	ErrUnauthenticated  = "unauthenticated"
+	ErrForbidden        = "forbidden"
+	ErrPolicyViolation  = "policy_violation" // 超出签发策略，可提交审批
+	ErrStepUpRequired   = "step_up_required" // 需要二次验证，见 X-Step-Up-Required
+	ErrNotFound         = "not_found"
+	ErrMethodNotAllowed = "method_not_allowed"
+	ErrConflict         = "conflict"
+	ErrQuotaExceeded    = "quota_exceeded"
+	ErrRateLimited      = "rate_limited"
+	ErrInternal         = "internal"
+)
+
+const (
+	v2DefaultLimit = 50
+	v2MaxLimit     = 200
+	v2MaxBody      = 1 << 20
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type v2Response struct {
+	Data       interface{} `json:"data,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Error      *APIError   `json:"error,omitempty"`
+}
+
+func writeV2(w http.ResponseWriter, status int, resp v2Response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeV2Data(w http.ResponseWriter, status int, data interface{}) { writeV2(w, status, v2Response{Data: data}) }
+
+func writeV2Error(w http.ResponseWriter, status int, code, message string) {
+	writeV2(w, status, v2Response{Error: &APIError{Code: code, Message: message}})
+}
+
+// v2ErrorCode 根据状态码和响应头推断共用逻辑所写错误的错误码
+func v2ErrorCode(status int, h http.Header) string {
+	switch status {
+	case 400:
+		return ErrInvalidRequest
+	case 401:
+		if h.Get("X-Step-Up-Required") != "" { return ErrStepUpRequired }
+		return ErrUnauthenticated
+	case 403:
+		if h.Get("X-Approval-Required") != "" { return ErrPolicyViolation }
+		return ErrForbidden
+	case 404, 410:
+		return ErrNotFound
+	case 405:
+		return ErrMethodNotAllowed
+	case 409:
+		return ErrConflict
+	case 429:
+		if h.Get("Retry-After") != "" { return ErrRateLimited }
+		return ErrQuotaExceeded
+	}
+	if status >= 500 { return ErrInternal }
+	return ErrInvalidRequest
+}
+
+// v2ErrorWriter 截获非 JSON 的错误响应
+type v2ErrorWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *v2ErrorWriter) WriteHeader(status int) {
+	if status >= 400 && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") { w.status = status; return }
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *v2ErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 { return w.body.Write(b) }
+	return w.ResponseWriter.Write(b)
+}
+
+// v2Errors 把 authorize、stepUp 等共用逻辑写出的纯文本错误转换为统一的错误对象
+func v2Errors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &v2ErrorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.status == 0 { return }
+		w.Header().Del("X-Content-Type-Options")
+		writeV2Error(w, ew.status, v2ErrorCode(ew.status, w.Header()), strings.TrimSpace(ew.body.String()))
+	})
+}
+
+// decodeV2 严格解析请求体，未知字段视为错误
+func decodeV2(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, v2MaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil { writeV2Error(w, 400, ErrInvalidRequest, "请求体格式错误: "+err.Error()); return false }
+	return true
+}
+
+// registerV2Routes 在 t 上挂载 /api/v2/，其下的路由使用独立的 mux (支持按方法和路径参数匹配)
+func registerV2Routes(t *routeTable) {
+	v2 := &routeTable{mux: http.NewServeMux()}
+	v2.handleFunc("GET /api/v2/licenses", v2ListLicenses)
+	v2.handleFunc("POST /api/v2/licenses", v2CreateLicense)
+	v2.handleFunc("GET /api/v2/licenses/{id}", v2GetLicense)
+	v2.handleFunc("DELETE /api/v2/licenses/{id}", v2DeleteLicense)
+	v2.handleFunc("POST /api/v2/licenses/{id}/revoke", v2RevokeLicense)
+	v2.handleFunc("GET /api/v2/machines", v2ListMachines)
+	v2.handleFunc("GET /api/v2/machines/{id}", v2GetMachine)
+	v2.handleFunc("DELETE /api/v2/machines/{id}", v2DeleteMachine)
+	v2.handleFunc("GET /api/v2/customers", v2ListCustomers)
+	v2.handleFunc("GET /api/v2/customers/{name}", v2GetCustomer)
+	v2.handleFunc("GET /api/v2/keys", v2ListKeys)
+	v2.handleFunc("POST /api/v2/keys", v2CreateKey)
+	v2.handleFunc("GET /api/v2/keys/{id}", v2GetKey)
+	v2.handleFunc("DELETE /api/v2/keys/{id}", v2RevokeKey)
+	t.handle("/api/v2/", v2Errors(v2.mux))
+	t.patterns = append(t.patterns, v2.patterns...)
+}
+
+// ================= 分页与排序 =================
+
+type listQuery struct {
+	Limit  int
+	Sort   string
+	Desc   bool
+	Cursor *v2Cursor
+}
+
+// v2Cursor 是翻页游标的内容：排序方式、上一页最后一条的排序值与 ID
+type v2Cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+func (q listQuery) sortSpec() string {
+	if q.Desc { return "-" + q.Sort }
+	return q.Sort
+}
+
+// parseListQuery 解析 limit、sort、cursor，sort 只能是 fields 中的字段
+func parseListQuery(r *http.Request, fields []string, defaultSort string) (listQuery, error) {
+	v := r.URL.Query()
+	q := listQuery{Limit: v2DefaultLimit}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > v2MaxLimit { return q, fmt.Errorf("limit 必须是 1 到 %d 之间的整数", v2MaxLimit) }
+		q.Limit = n
+	}
+	spec := v.Get("sort")
+	if spec == "" { spec = defaultSort }
+	q.Sort, q.Desc = strings.TrimPrefix(spec, "-"), strings.HasPrefix(spec, "-")
+	valid := false
+	for _, f := range fields {
+		if f == q.Sort { valid = true; break }
+	}
+	if !valid { return q, fmt.Errorf("不支持按 %s 排序，可用字段: %s", q.Sort, strings.Join(fields, ", ")) }
+	if s := v.Get("cursor"); s != "" {
+		var c v2Cursor
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err == nil { err = json.Unmarshal(b, &c) }
+		if err != nil { return q, errors.New("cursor 无效") }
+		if c.Sort != q.sortSpec() { return q, errors.New("cursor 与当前排序方式不一致") }
+		q.Cursor = &c
+	}
+	return q, nil
+}
+
+// paginate 按 q 排序并取出游标之后的一页，返回下一页的游标 (没有更多时为空)。
+// key 返回条目在排序字段上的值，id 返回唯一标识，二者共同决定顺序。
+func paginate[T any](items []T, q listQuery, key func(T, string) string, id func(T) string) ([]T, string) {
+	k := func(t T) [2]string { return [2]string{key(t, q.Sort), id(t)} }
+	less := func(a, b [2]string) bool {
+		if a[0] != b[0] { return a[0] < b[0] }
+		return a[1] < b[1]
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if q.Desc { return less(k(items[j]), k(items[i])) }
+		return less(k(items[i]), k(items[j]))
+	})
+	start := 0
+	if q.Cursor != nil {
+		c := [2]string{q.Cursor.Key, q.Cursor.ID}
+		start = sort.Search(len(items), func(i int) bool {
+			if q.Desc { return less(k(items[i]), c) }
+			return less(c, k(items[i]))
+		})
+	}
+	end := min(start+q.Limit, len(items))
+	page := items[start:end]
+	if end == len(items) { return page, "" }
+	last := items[end-1]
+	b, _ := json.Marshal(v2Cursor{Sort: q.sortSpec(), Key: key(last, q.Sort), ID: id(last)})
+	return page, base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeV2Page(w http.ResponseWriter, data interface{}, next string) {
+	writeV2(w, 200, v2Response{Data: data, NextCursor: next})
+}
+
+// ================= licenses =================
+
+var licenseSortFields = []string{"generate_time", "expiry_date", "machine_id", "customer", "product"}
+
+func licenseSortKey(rec HistoryRecord, field string) string {
+	switch field {
+	case "expiry_date":
+		return rec.ExpiryDate
+	case "machine_id":
+		return rec.MachineID
+	case "customer":
+		return rec.Customer
+	case "product":
+		return rec.Product
+	}
+	return rec.ID // ULID 按签发时间有序
+}
+
+// v2ListLicenses 筛选参数: view (active/archive/trash/all，默认 active)、status、machine_id、customer、product、
+// issued_by、source、format、q (模糊匹配)、expires_after / expires_before (yyyy-mm-dd，含当天)
+func v2ListLicenses(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
+	q, err := parseListQuery(r, licenseSortFields, "-generate_time")
+	if err != nil { writeV2Error(w, 400, ErrInvalidRequest, err.Error()); return }
+	v := r.URL.Query()
+	f := LicenseFilter{View: v.Get("view"), Query: strings.TrimSpace(v.Get("q"))}
+	switch f.View {
+	case "", ViewActive, ViewArchive, ViewTrash, ViewAll:
+	default:
+		writeV2Error(w, 400, ErrInvalidRequest, "view 只能是 active、archive、trash 或 all"); return
+	}
+	exact := map[string]func(HistoryRecord) string{
+		"status":     func(rec HistoryRecord) string { return rec.Status },
+		"machine_id": func(rec HistoryRecord) string { return rec.MachineID },
+		"customer":   func(rec HistoryRecord) string { return rec.Customer },
+		"product":    func(rec HistoryRecord) string { return rec.Product },
+		"issued_by":  func(rec HistoryRecord) string { return rec.IssuedBy },
+		"source":     func(rec HistoryRecord) string { return rec.Source },
+		"format":     func(rec HistoryRecord) string { return rec.Format },
+	}
+	after, before := v.Get("expires_after"), v.Get("expires_before")
+
+	all, err := store.ListLicenses()
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	matched := []HistoryRecord{}
+	for _, rec := range all {
+		if !f.match(rec) { continue }
+		if (after != "" && rec.ExpiryDate < after) || (before != "" && rec.ExpiryDate > before) { continue }
+		ok := true
+		for param, field := range exact {
+			if want := v.Get(param); want != "" && field(rec) != want { ok = false; break }
+		}
+		if ok { matched = append(matched, rec) }
+	}
+	page, next := paginate(matched, q, licenseSortKey, func(rec HistoryRecord) string { return rec.ID })
+	writeV2Page(w, page, next)
+}
+
+// v2CreateLicense 签发一个激活码，请求体与 /api/generate 相同 (output 除外)
+func v2CreateLicense(w http.ResponseWriter, r *http.Request) {
+	var req GenerateRequest
+	if !decodeV2(w, r, &req) { return }
+	p := authorize(w, r, PermGenerate, req.Token)
+	if p == nil { return }
+	rec, ok := issueLicense(w, r, p, req)
+	if !ok { return }
+	// 生成时间等由存储层填写，以保存后的记录为准
+	if saved, err := store.GetLicense(rec.ID); err == nil && saved != nil { rec = *saved }
+	w.Header().Set("Location", "/api/v2/licenses/"+rec.ID)
+	writeV2Data(w, 201, rec)
+}
+
+func v2GetLicense(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
+	rec, err := store.GetLicense(r.PathValue("id"))
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	if rec == nil { writeV2Error(w, 404, ErrNotFound, "激活码不存在"); return }
+	writeV2Data(w, 200, rec)
+}
+
+// updateLicenseV2 修改记录并返回修改后的内容
+func updateLicenseV2(w http.ResponseWriter, id string, fn func(*HistoryRecord)) *HistoryRecord {
+	found, err := store.UpdateLicense(id, fn)
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return nil }
+	if !found { writeV2Error(w, 404, ErrNotFound, "激活码不存在"); return nil }
+	rec, err := store.GetLicense(id)
+	if err != nil || rec == nil { writeV2Error(w, 500, ErrInternal, fmt.Sprint("读取记录失败: ", err)); return nil }
+	return rec
+}
+
+func v2RevokeLicense(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermRevoke, "")
+	if p == nil { return }
+	rec := updateLicenseV2(w, r.PathValue("id"), func(rec *HistoryRecord) { rec.Status = LicenseRevoked })
+	if rec == nil { return }
+	audit(r, p, "license.revoke", rec.ID, "")
+	writeV2Data(w, 200, rec)
+}
+
+// v2DeleteLicense 移入回收站
+func v2DeleteLicense(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermHistoryDelete, "")
+	if p == nil { return }
+	rec := updateLicenseV2(w, r.PathValue("id"), func(rec *HistoryRecord) { if rec.DeletedAt == "" { rec.DeletedAt = trashTime() } })
+	if rec == nil { return }
+	audit(r, p, "license.delete", rec.ID, "")
+	writeV2Data(w, 200, rec)
+}
+
+// ================= machines =================
+
+// MachineView 是 v2 返回的机器信息，单条查询时附带其激活码 (需要 history.read 权限)
+type MachineView struct {
+	MachineRecord
+	Licenses []HistoryRecord `json:"licenses,omitempty"`
+}
+
+// v2ListMachines 筛选参数: view (active/trash/all，默认 active)、q (机器码模糊匹配)
+func v2ListMachines(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermMachinesRead, "") == nil { return }
+	q, err := parseListQuery(r, []string{"machine_id", "last_seen"}, "-last_seen")
+	if err != nil { writeV2Error(w, 400, ErrInvalidRequest, err.Error()); return }
+	view, search := r.URL.Query().Get("view"), strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if view == "" { view = ViewActive }
+	if view != ViewActive && view != ViewTrash && view != ViewAll { writeV2Error(w, 400, ErrInvalidRequest, "view 只能是 active、trash 或 all"); return }
+
+	all, err := store.ListMachines()
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	matched := []MachineRecord{}
+	for _, m := range all {
+		if (view == ViewActive && m.DeletedAt != "") || (view == ViewTrash && m.DeletedAt == "") { continue }
+		if search != "" && !strings.Contains(strings.ToLower(m.MachineID), search) { continue }
+		matched = append(matched, m)
+	}
+	page, next := paginate(matched, q, func(m MachineRecord, field string) string {
+		if field == "last_seen" { return m.LastSeen }
+		return m.MachineID
+	}, func(m MachineRecord) string { return m.MachineID })
+	writeV2Page(w, page, next)
+}
+
+func v2GetMachine(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermMachinesRead, "")
+	if p == nil { return }
+	m, err := findMachine(r.PathValue("id"))
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	if m == nil { writeV2Error(w, 404, ErrNotFound, "机器码不存在"); return }
+	view := MachineView{MachineRecord: *m}
+	if p.Can(PermHistoryRead) {
+		if view.Licenses, err = store.LicensesByMachine(m.MachineID); err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	}
+	writeV2Data(w, 200, view)
+}
+
+// v2DeleteMachine 移入回收站
+func v2DeleteMachine(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermMachinesDelete, "")
+	if p == nil { return }
+	id := r.PathValue("id")
+	found, err := store.UpdateMachine(id, func(m *MachineRecord) { if m.DeletedAt == "" { m.DeletedAt = trashTime() } })
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	if !found { writeV2Error(w, 404, ErrNotFound, "机器码不存在"); return }
+	audit(r, p, "machine.delete", id, "")
+	m, err := findMachine(id)
+	if err != nil || m == nil { writeV2Error(w, 500, ErrInternal, fmt.Sprint("读取机器码失败: ", err)); return }
+	writeV2Data(w, 200, m)
+}
+
+// ================= customers =================
+
+// Customer 由激活码记录中的客户字段汇总而来 (不含回收站中的记录)
+type Customer struct {
+	Name           string          `json:"name"`
+	Licenses       int             `json:"licenses"`
+	ActiveLicenses int             `json:"active_licenses"` // 未吊销、未归档且未到期
+	Machines       int             `json:"machines"`
+	Products       []string        `json:"products"`
+	FirstIssued    string          `json:"first_issued"`
+	LastIssued     string          `json:"last_issued"`
+	LatestExpiry   string          `json:"latest_expiry"`
+	Records        []HistoryRecord `json:"records,omitempty"` // 仅单条查询时返回
+}
+
+// listCustomers 按客户汇总记录，records 为 true 时附带每个客户的记录
+func listCustomers(records bool) ([]*Customer, error) {
+	all, err := store.ListLicenses()
+	if err != nil { return nil, err }
+	today := time.Now().In(expiryLocation()).Format("2006-01-02")
+	byName := map[string]*Customer{}
+	machines := map[string]map[string]bool{}
+	products := map[string]map[string]bool{}
+	var list []*Customer
+	for _, rec := range all {
+		if rec.Customer == "" || rec.DeletedAt != "" { continue }
+		c := byName[rec.Customer]
+		if c == nil {
+			c = &Customer{Name: rec.Customer, FirstIssued: rec.GenerateTime}
+			byName[rec.Customer], machines[rec.Customer], products[rec.Customer] = c, map[string]bool{}, map[string]bool{}
+			list = append(list, c)
+		}
+		c.Licenses++
+		if rec.Status != LicenseRevoked && rec.ArchivedAt == "" && rec.ExpiryDate >= today { c.ActiveLicenses++ }
+		machines[c.Name][rec.MachineID] = true
+		if rec.Product != "" && !products[c.Name][rec.Product] { products[c.Name][rec.Product] = true; c.Products = append(c.Products, rec.Product) }
+		c.LastIssued = rec.GenerateTime
+		if rec.ExpiryDate > c.LatestExpiry { c.LatestExpiry = rec.ExpiryDate }
+		if records { c.Records = append(c.Records, rec) }
+	}
+	for _, c := range list {
+		c.Machines = len(machines[c.Name])
+		if c.Products == nil { c.Products = []string{} }
+	}
+	return list, nil
+}
+
+// v2ListCustomers 筛选参数: q (名称模糊匹配)、product
+func v2ListCustomers(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
+	q, err := parseListQuery(r, []string{"name", "licenses", "last_issued", "latest_expiry"}, "name")
+	if err != nil { writeV2Error(w, 400, ErrInvalidRequest, err.Error()); return }
+	search, product := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q"))), r.URL.Query().Get("product")
+
+	all, err := listCustomers(false)
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	matched := []*Customer{}
+	for _, c := range all {
+		if search != "" && !strings.Contains(strings.ToLower(c.Name), search) { continue }
+		if product != "" {
+			has := false
+			for _, p := range c.Products {
+				if p == product { has = true; break }
+			}
+			if !has { continue }
+		}
+		matched = append(matched, c)
+	}
+	page, next := paginate(matched, q, func(c *Customer, field string) string {
+		switch field {
+		case "licenses":
+			return fmt.Sprintf("%010d", c.Licenses)
+		case "last_issued":
+			return c.LastIssued
+		case "latest_expiry":
+			return c.LatestExpiry
+		}
+		return c.Name
+	}, func(c *Customer) string { return c.Name })
+	writeV2Page(w, page, next)
+}
+
+func v2GetCustomer(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
+	all, err := listCustomers(true)
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	for _, c := range all {
+		if c.Name == r.PathValue("name") { writeV2Data(w, 200, c); return }
+	}
+	writeV2Error(w, 404, ErrNotFound, "客户不存在")
+}
+
+// ================= keys =================
+
+// v2ListKeys 筛选参数: status (active/revoked/expired)
+func v2ListKeys(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermKeys, "") == nil { return }
+	q, err := parseListQuery(r, []string{"name", "created_at", "last_used_at"}, "-created_at")
+	if err != nil { writeV2Error(w, 400, ErrInvalidRequest, err.Error()); return }
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "active" && status != "revoked" && status != "expired" { writeV2Error(w, 400, ErrInvalidRequest, "status 只能是 active、revoked 或 expired"); return }
+
+	keys, err := listAPIKeys()
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	now := time.Now()
+	matched := []APIKeyView{}
+	for _, k := range keys {
+		s := "active"
+		if k.RevokedAt != "" { s = "revoked" } else if !k.usable(now) { s = "expired" }
+		if status == "" || status == s { matched = append(matched, k.view()) }
+	}
+	page, next := paginate(matched, q, func(k APIKeyView, field string) string {
+		switch field {
+		case "name":
+			return k.Name
+		case "last_used_at":
+			return k.LastUsedAt
+		}
+		return k.CreatedAt
+	}, func(k APIKeyView) string { return k.ID })
+	writeV2Page(w, page, next)
+}
+
+// v2CreateKey 创建 Key，完整的 Key 只在响应中出现这一次
+func v2CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	if !decodeV2(w, r, &req) { return }
+	p := authorize(w, r, PermKeys, req.Token)
+	if p == nil { return }
+	v, err := createAPIKey(req, p.Name)
+	if err != nil { writeV2Error(w, 400, ErrInvalidRequest, err.Error()); return }
+	audit(r, p, "key.create", v.ID, fmt.Sprintf("name=%s scopes=%v products=%v max_days=%d", v.Name, v.Scopes, v.Products, v.MaxDays))
+	w.Header().Set("Location", "/api/v2/keys/"+v.ID)
+	writeV2Data(w, 201, v)
+}
+
+func v2GetKey(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermKeys, "") == nil { return }
+	var k APIKey
+	found, err := store.GetDoc(apiKeyDocKind, r.PathValue("id"), &k)
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	if !found { writeV2Error(w, 404, ErrNotFound, "API Key 不存在"); return }
+	writeV2Data(w, 200, k.view())
+}
+
+// v2RevokeKey 吊销 Key
+func v2RevokeKey(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermKeys, "")
+	if p == nil { return }
+	k, err := revokeAPIKey(r.PathValue("id"))
+	if err != nil { writeV2Error(w, 500, ErrInternal, err.Error()); return }
+	if k == nil { writeV2Error(w, 404, ErrNotFound, "API Key 不存在"); return }
+	audit(r, p, "key.revoke", k.ID, k.Name)
+	writeV2Data(w, 200, k.view())
+}
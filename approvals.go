@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 超出策略的签发审批 =================
+//
+// 签发请求超出全局有效期限制或 API Key 的产品、有效期限制时，/api/generate 返回 403 并带 X-Approval-Required 头。
+// 签发人可以把同样的请求连同理由提交到 /api/approvals/submit，申请保存为 approvals 文档并通过 Telegram 通知审批人；
+// 有 license.approve 权限的用户 (默认仅管理员) 在 /approvals 页面批准或驳回并填写意见，不能审批自己的申请。
+// 批准时 (需二次验证) 按原请求签发，签发人记为申请人，申请人通过 /api/approvals/result 以申请时的输出格式取回。
+// 提交、批准、驳回均写入审计日志。
+
+const approvalDocKind = "approvals"
+
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+var approvalStatusNames = map[string]string{ApprovalPending: "待审批", ApprovalApproved: "已批准", ApprovalRejected: "已驳回"}
+
+// Approval 是一条签发申请
+type Approval struct {
+	ID        string          `json:"id"`
+	Request   GenerateRequest `json:"request"` // 不含 Token
+	Reason    string          `json:"reason"`
+	Violation string          `json:"violation"` // 提交时超出的策略
+	Requester string          `json:"requester"`
+	CreatedAt string          `json:"created_at"`
+	Status    string          `json:"status"`
+	Decider   string          `json:"decider,omitempty"`
+	Comment   string          `json:"comment,omitempty"`
+	DecidedAt string          `json:"decided_at,omitempty"`
+	LicenseID string          `json:"license_id,omitempty"`
+}
+
+// PolicyError 表示签发请求超出了策略，可走审批流程
+type PolicyError struct{ Reasons []string }
+
+func (e *PolicyError) Error() string { return strings.Join(e.Reasons, "；") }
+
+// issuePolicy 检查全局有效期限制及 API Key 的限制，超出时返回 *PolicyError
+func issuePolicy(p *Principal, product string, expiryUTC int64) error {
+	var reasons []string
+	if exceedsMaxExpiry(expiryUTC) { reasons = append(reasons, errExpiryTooLong.Error()) }
+	if err := p.checkIssue(product, expiryUTC); err != nil { reasons = append(reasons, err.Error()) }
+	if len(reasons) == 0 { return nil }
+	return &PolicyError{Reasons: reasons}
+}
+
+// denyOverPolicy 写入 403，并提示调用方可以提交审批申请
+func denyOverPolicy(w http.ResponseWriter, err error) {
+	w.Header().Set("X-Approval-Required", "1")
+	http.Error(w, err.Error()+"。如确有需要，可提交审批申请 (POST /api/approvals/submit)", 403)
+}
+
+// approvalMu 串行化审批，避免两个审批人同时批准导致重复签发
+var approvalMu sync.Mutex
+
+func listApprovals() ([]Approval, error) {
+	docs, err := store.ListDocs(approvalDocKind)
+	if err != nil { return nil, err }
+	list := make([]Approval, 0, len(docs))
+	for _, d := range docs {
+		var a Approval
+		if err := json.Unmarshal(d.Body, &a); err != nil { return nil, fmt.Errorf("审批申请 %s 数据损坏: %v", d.ID, err) }
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	return list, nil
+}
+
+// visibleTo 审批人可以查看全部申请，其他人只能查看自己提交的
+func (a *Approval) visibleTo(p *Principal) bool { return p.Can(PermApprove) || a.Requester == p.Name }
+
+// submitApproval 校验并保存申请，只接受确实超出策略的请求
+func submitApproval(p *Principal, req GenerateRequest, reason string) (*Approval, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" { return nil, errors.New("请填写申请理由") }
+	if req.MachineID == "" || req.Expiry == "" { return nil, errors.New("机器码或日期为空") }
+	if !machineIDPattern.MatchString(req.MachineID) { return nil, errors.New(machineIDRule) }
+	if !generateOutputs[req.Output] { return nil, errors.New("不支持的输出格式: " + req.Output) }
+	if _, err := resolveLicenseFormat(req.Format, req.Product); err != nil { return nil, err }
+	expiryUTC, err := expiryTime(req.Expiry)
+	if err != nil { return nil, err }
+	if time.Unix(expiryUTC, 0).Before(time.Now()) { return nil, errors.New("到期日期已过") }
+	violation := issuePolicy(p, req.Product, expiryUTC)
+	if violation == nil { return nil, errors.New("该请求未超出签发策略，可直接签发") }
+
+	req.Token = ""
+	a := Approval{ID: newLicenseID(), Request: req, Reason: reason, Violation: violation.Error(), Requester: p.Name, CreatedAt: time.Now().UTC().Format(time.RFC3339), Status: ApprovalPending}
+	if err := store.PutDoc(approvalDocKind, a.ID, &a); err != nil { return nil, err }
+	return &a, nil
+}
+
+// decideApproval 批准 (签发) 或驳回一条待审批的申请
+func decideApproval(p *Principal, id string, approve bool, comment string) (*Approval, *HistoryRecord, error) {
+	approvalMu.Lock()
+	defer approvalMu.Unlock()
+	var a Approval
+	found, err := store.GetDoc(approvalDocKind, id, &a)
+	if err != nil { return nil, nil, err }
+	if !found { return nil, nil, errNotFound }
+	if a.Status != ApprovalPending { return nil, nil, errors.New("该申请" + approvalStatusNames[a.Status]) }
+	if a.Requester == p.Name { return nil, nil, errors.New("不能审批自己的申请") }
+
+	var rec *HistoryRecord
+	if approve {
+		req := a.Request
+		format, err := resolveLicenseFormat(req.Format, req.Product)
+		if err != nil { return nil, nil, err }
+		issued, err := generateLicenseCore(LicenseParams{MachineID: req.MachineID, Expiry: req.Expiry, Features: req.Features, Format: format, Product: req.Product, Approved: true})
+		if err != nil { return nil, nil, err }
+		issued.Customer, issued.Source, issued.IssuedBy = req.Customer, licenseSource(req.Source), a.Requester
+		if err := store.AddLicenses([]HistoryRecord{issued}); err != nil { return nil, nil, err }
+		if exp, err := expiryTime(req.Expiry); err == nil {
+			if err := chargeQuota(a.Requester, []int64{exp}, false); err != nil { log.Printf("❌ 记录配额用量失败: %v", err) }
+		}
+		rec = &issued
+		a.Status, a.LicenseID = ApprovalApproved, issued.ID
+	} else {
+		a.Status = ApprovalRejected
+	}
+	a.Decider, a.Comment, a.DecidedAt = p.Name, strings.TrimSpace(comment), time.Now().UTC().Format(time.RFC3339)
+	if err := store.PutDoc(approvalDocKind, a.ID, &a); err != nil { return nil, nil, err }
+	return &a, rec, nil
+}
+
+var errNotFound = errors.New("申请不存在")
+
+// ApprovalSubmitRequest 是提交申请的请求体：与 /api/generate 相同的字段加上理由
+type ApprovalSubmitRequest struct {
+	GenerateRequest
+	Reason string `json:"reason"`
+}
+
+// ApprovalDecideRequest 是审批的请求体
+type ApprovalDecideRequest struct {
+	Token   string `json:"token,omitempty"`
+	ID      string `json:"id"`
+	Approve bool   `json:"approve"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// handleApprovals GET /api/approvals 返回可见的申请；POST /api/approvals/submit 提交；POST /api/approvals/decide 审批；
+// GET /api/approvals/result?id= 取回已批准申请的激活码
+func handleApprovals(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/approvals":
+		p := authorize(w, r, PermGenerate, "")
+		if p == nil { return }
+		list, err := listApprovals()
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		status := r.URL.Query().Get("status")
+		visible := []Approval{}
+		for _, a := range list {
+			if a.visibleTo(p) && (status == "" || a.Status == status) { visible = append(visible, a) }
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(visible)
+
+	case "/api/approvals/submit":
+		if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+		var req ApprovalSubmitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+		p := authorize(w, r, PermGenerate, req.Token)
+		if p == nil { return }
+		a, err := submitApproval(p, req.GenerateRequest, req.Reason)
+		if err != nil { http.Error(w, err.Error(), 400); return }
+		audit(r, p, "approval.submit", a.ID, fmt.Sprintf("machine=%s expiry=%s product=%s violation=%s reason=%s", a.Request.MachineID, a.Request.Expiry, a.Request.Product, a.Violation, a.Reason))
+		sendTelegramMessage(fmt.Sprintf("📝 <b>新的签发审批申请</b>\n\n"+
+			"👤 <b>申请人:</b> %s\n"+
+			"💻 <b>机器码:</b> <code>%s</code>\n"+
+			"📅 <b>到期日:</b> %s\n"+
+			"⚠️ <b>超出策略:</b> %s\n"+
+			"💬 <b>理由:</b> %s\n\n请到 /approvals 页面处理",
+			html.EscapeString(a.Requester), html.EscapeString(a.Request.MachineID), html.EscapeString(a.Request.Expiry), html.EscapeString(a.Violation), html.EscapeString(a.Reason)))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(a)
+
+	case "/api/approvals/decide":
+		if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+		var req ApprovalDecideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+		p := authorize(w, r, PermApprove, req.Token)
+		if p == nil { return }
+		if !req.Approve && strings.TrimSpace(req.Comment) == "" { http.Error(w, "驳回时请填写意见", 400); return }
+		if req.Approve && !stepUp(w, r, p) { return }
+		a, rec, err := decideApproval(p, req.ID, req.Approve, req.Comment)
+		if errors.Is(err, errNotFound) { http.Error(w, err.Error(), 404); return }
+		if err != nil { http.Error(w, err.Error(), 400); return }
+		if rec != nil {
+			audit(r, p, "approval.approve", a.ID, fmt.Sprintf("license=%s comment=%s", rec.ID, a.Comment))
+			audit(r, p, "license.generate", rec.ID, fmt.Sprintf("machine=%s expiry=%s product=%s approval=%s requester=%s", rec.MachineID, rec.ExpiryDate, rec.Product, a.ID, a.Requester))
+			sendTelegramNotification(rec.MachineID, rec.ExpiryDate, a.Requester+" (审批人: "+p.Name+")")
+		} else {
+			audit(r, p, "approval.reject", a.ID, a.Comment)
+		}
+		sendTelegramMessage(fmt.Sprintf("%s <b>审批结果: %s</b>\n\n👤 <b>申请人:</b> %s\n💻 <b>机器码:</b> <code>%s</code>\n🧑‍⚖️ <b>审批人:</b> %s\n💬 <b>意见:</b> %s",
+			map[bool]string{true: "✅", false: "❌"}[req.Approve], approvalStatusNames[a.Status], html.EscapeString(a.Requester), html.EscapeString(a.Request.MachineID), html.EscapeString(p.Name), html.EscapeString(a.Comment)))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(a)
+
+	case "/api/approvals/result":
+		p := authorize(w, r, PermGenerate, "")
+		if p == nil { return }
+		var a Approval
+		found, err := store.GetDoc(approvalDocKind, r.URL.Query().Get("id"), &a)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if !found || !a.visibleTo(p) { http.Error(w, errNotFound.Error(), 404); return }
+		if a.Status != ApprovalApproved { http.Error(w, "该申请"+approvalStatusNames[a.Status], 409); return }
+		rec, err := store.GetLicense(a.LicenseID)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if rec == nil { http.Error(w, "激活码记录已不存在", 410); return }
+		output := r.URL.Query().Get("output")
+		if output == "" { output = a.Request.Output }
+		if !generateOutputs[output] { http.Error(w, "不支持的输出格式: "+output, 400); return }
+		w.Header().Set("X-License-ID", rec.ID)
+		writeGenerateOutput(w, r, output, *rec)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleApprovalsPage 审批页面：审批人看到全部申请并可处理，其他人只看到自己的申请
+func handleApprovalsPage(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermGenerate, "")
+	if p == nil { return }
+	list, err := listApprovals()
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	approver := p.Can(PermApprove)
+
+	rowsHtml, pending := "", 0
+	for _, a := range list {
+		if !a.visibleTo(p) { continue }
+		status := approvalStatusNames[a.Status]
+		switch a.Status {
+		case ApprovalPending:
+			pending++
+			status = `<span style="color:#c77700">` + status + `</span>`
+		case ApprovalApproved:
+			status = `<span style="color:green">` + status + `</span>`
+		case ApprovalRejected:
+			status = `<span style="color:#c00">` + status + `</span>`
+		}
+		decision := ""
+		if a.Decider != "" { decision = fmt.Sprintf(`<div style="font-size:12px;color:#555">%s：%s</div>`, html.EscapeString(a.Decider), html.EscapeString(a.Comment)) }
+		action := ""
+		switch {
+		case a.Status == ApprovalPending && approver && a.Requester != p.Name:
+			action = fmt.Sprintf(`<button onclick="decide('%s',true)" class="ok-btn">批准</button> <button onclick="decide('%s',false)" class="del-btn">驳回</button>`, a.ID, a.ID)
+		case a.Status == ApprovalApproved:
+			action = fmt.Sprintf(`<button onclick="fetchCode('%s')" class="ok-btn">查看激活码</button>`, a.ID)
+		}
+		product := a.Request.Product
+		if product == "" { product = "-" }
+		rowsHtml += fmt.Sprintf(`<tr><td style="font-size:12px">%s</td><td>%s</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td>%s</td><td style="font-size:12px;color:#c00">%s</td><td style="font-size:13px">%s</td><td>%s%s</td><td style="text-align:center;white-space:nowrap">%s</td></tr>`,
+			a.CreatedAt, html.EscapeString(a.Requester), html.EscapeString(a.Request.MachineID), html.EscapeString(a.Request.Expiry), html.EscapeString(product), html.EscapeString(a.Violation), html.EscapeString(a.Reason), status, decision, action)
+	}
+	title := "我的审批申请"
+	if approver { title = fmt.Sprintf("签发审批 (%d 条待处理)", pending) }
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>签发审批</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1100px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333;word-break:break-all}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .del-btn:hover{background:#ff3b30;color:white}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .ok-btn:hover{background:#0071e3;color:white}#code{display:none;margin-top:15px;padding:10px;background:#eef6ff;border-radius:6px;font-family:monospace;word-break:break-all}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">✅ %s <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2>
+	<table><thead><tr><th>提交时间</th><th>申请人</th><th>机器码</th><th>到期</th><th>产品</th><th>超出策略</th><th>理由</th><th>状态</th><th></th></tr></thead><tbody>%s</tbody></table><div id="code"></div></div>
+	<script>async function decide(id,ok){var c=prompt(ok?'审批意见 (可选)':'驳回原因');if(c===null||(!ok&&!c))return;
+	let res=await fetch('/api/approvals/decide',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({id:id,approve:ok,comment:c})});if(res.ok)location.reload();else alert(await res.text())}
+	async function fetchCode(id){let res=await fetch('/api/approvals/result?id='+id+'&output=text');var box=document.getElementById('code');box.style.display='block';box.innerText=await res.text()}</script></body></html>`,
+		html.EscapeString(title), rowsHtml)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"license-server/client"
+)
+
+// ================= 审计日志 =================
+//
+// 审计日志是只追加的 JSON Lines 文件 (AUDIT_LOG，默认 audit.log)，与存储后端无关。
+// 每条记录带序号和上一条的哈希，自身哈希覆盖除 Hash 外的全部字段，任何修改、删除、插入都会使链条断开。
+// 服务每隔 AUDIT_SIGN_INTERVAL (默认 1h) 以及退出时追加一条 audit.sign 记录，用服务器私钥
+// (优先 Ed25519，否则 RSA) 对上一条的哈希签名，防止攻击者重算整条哈希链；最后一次签名之后的记录只受哈希链保护。
+// license-server audit verify 校验整个文件，/audit 页面可按条件查询。
+
+var (
+	AuditLogPath      = getEnv("AUDIT_LOG", "audit.log")
+	AuditSignInterval = getEnvDuration("AUDIT_SIGN_INTERVAL", time.Hour)
+)
+
+const auditSignAction = "audit.sign"
+
+// AuditEntry 是一条审计记录：谁 (账号与来源 IP) 在什么时候对什么对象做了什么
+type AuditEntry struct {
+	Seq    int64  `json:"seq"`
+	Time   string `json:"time"` // RFC 3339 UTC
+	Actor  string `json:"actor"`
+	IP     string `json:"ip"`
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	KeyID  string `json:"kid,omitempty"` // 仅签名记录
+	Sig    string `json:"sig,omitempty"` // 仅签名记录：对 Prev 的签名
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+// computeHash 计算记录的哈希 (Hash 字段置空后的 JSON)
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+var auditLog = struct {
+	sync.Mutex
+	f        *os.File
+	size     int64 // 已确认写入的字节数，写入失败时截回此处
+	seq      int64
+	last     string
+	unsigned int                      // 最近一次签名之后的记录数
+	watchers map[chan AuditEntry]bool // 订阅新记录的 gRPC WatchEvents
+}{watchers: map[chan AuditEntry]bool{}}
+
+// openAuditLog 打开审计日志并读出链尾
+func openAuditLog() error {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if err := dropTornAuditTail(AuditLogPath); err != nil { return err }
+	err := scanAuditLog(AuditLogPath, func(e AuditEntry) error {
+		auditLog.seq, auditLog.last = e.Seq, e.Hash
+		if e.Action == auditSignAction { auditLog.unsigned = 0 } else { auditLog.unsigned++ }
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) { return err }
+	f, err := os.OpenFile(AuditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil { return err }
+	info, err := f.Stat()
+	if err != nil { f.Close(); return err }
+	auditLog.f, auditLog.size = f, info.Size()
+	log.Printf(">>> 审计日志: %s (%d 条)", AuditLogPath, auditLog.seq)
+	return nil
+}
+
+// dropTornAuditTail 截掉末尾缺少换行的残缺记录。appendAudit 一次写入整行，缺少换行说明写入时崩溃，
+// 该记录从未确认成功；之前的记录仍按哈希链严格校验
+func dropTornAuditTail(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) { return nil }
+	if err != nil { return err }
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil { return err }
+	size := info.Size()
+	if size == 0 { return nil }
+
+	// 单行最长 4MB (与 scanAuditLog 的缓冲区一致)，只需读末尾这么多即可找到最后一个换行
+	n := size
+	if n > 4*1024*1024+1 { n = 4*1024*1024 + 1 }
+	tail := make([]byte, n)
+	if _, err := f.ReadAt(tail, size-n); err != nil { return err }
+	i := bytes.LastIndexByte(tail, '\n')
+	if i == len(tail)-1 { return nil }
+	if i < 0 && n < size { return fmt.Errorf("审计日志末行超过 4MB") }
+	keep := size - n + int64(i) + 1
+	if err := f.Truncate(keep); err != nil { return err }
+	if err := f.Sync(); err != nil { return err }
+	log.Printf(">>> ⚠️ 审计日志末尾有一条不完整的记录 (%d 字节)，已丢弃", size-keep)
+	return nil
+}
+
+// appendAudit 把记录接到链尾并落盘，调用方需持有锁
+func appendAudit(e AuditEntry) error {
+	if auditLog.f == nil { return errors.New("审计日志未打开") }
+	e.Seq, e.Prev = auditLog.seq+1, auditLog.last
+	e.Hash = e.computeHash()
+	b, _ := json.Marshal(e)
+	line := append(b, '\n')
+	if _, err := auditLog.f.Write(line); err != nil { auditLog.f.Truncate(auditLog.size); return err }
+	if err := auditLog.f.Sync(); err != nil { auditLog.f.Truncate(auditLog.size); return err }
+	auditLog.size += int64(len(line))
+	auditLog.seq, auditLog.last = e.Seq, e.Hash
+	for ch := range auditLog.watchers {
+		select {
+		case ch <- e:
+		default: // 订阅方跟不上时断开，由其按序号重连补发
+			delete(auditLog.watchers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// watchAudit 订阅之后写入的审计记录，同时返回订阅时的链尾序号，供调用方补发此前的记录。
+// 订阅方跟不上时通道被关闭
+func watchAudit() (<-chan AuditEntry, int64, func()) {
+	ch := make(chan AuditEntry, 256)
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.watchers[ch] = true
+	return ch, auditLog.seq, func() {
+		auditLog.Lock()
+		defer auditLog.Unlock()
+		if auditLog.watchers[ch] { delete(auditLog.watchers, ch); close(ch) }
+	}
+}
+
+// audit 记录一次操作，p 为空表示未登录的调用方 (如登录失败)
+func audit(r *http.Request, p *Principal, action, target, detail string) {
+	e := AuditEntry{Time: time.Now().UTC().Format(time.RFC3339), IP: clientIP(r), Action: action, Target: target, Detail: detail}
+	if p != nil { e.Actor = p.Name }
+	auditLog.Lock()
+	err := appendAudit(e)
+	if err == nil { auditLog.unsigned++ }
+	auditLog.Unlock()
+	if err != nil { log.Printf("❌ 写入审计日志失败: %v (%s %s %s)", err, action, e.Actor, target) }
+}
+
+// auditSigner 返回用于签名的服务器私钥
+func auditSigner() (crypto.Signer, error) {
+	if k, err := loadShortKeySigner(); err == nil { return k, nil }
+	if k, err := loadPrivateKey(); err == nil { return k, nil }
+	return nil, errors.New("没有可用的服务器私钥")
+}
+
+func signAuditHash(key crypto.Signer, hash string) (string, error) {
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(hash))
+	default:
+		sum := sha256.Sum256([]byte(hash))
+		sig, err = key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	return base64.StdEncoding.EncodeToString(sig), err
+}
+
+// signAuditLog 若有未签名的记录，追加一条签名记录
+func signAuditLog() error {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.unsigned == 0 { return nil }
+	key, err := auditSigner()
+	if err != nil { return err }
+	sig, err := signAuditHash(key, auditLog.last)
+	if err != nil { return err }
+	e := AuditEntry{Time: time.Now().UTC().Format(time.RFC3339), Actor: "system", Action: auditSignAction, Detail: fmt.Sprintf("%d 条", auditLog.unsigned), KeyID: client.KeyID(key.Public()), Sig: sig}
+	if err := appendAudit(e); err != nil { return err }
+	auditLog.unsigned = 0
+	return nil
+}
+
+// startAuditLog 打开审计日志并启动定时签名，返回的函数在退出时签名并关闭文件
+func startAuditLog() func() {
+	if err := openAuditLog(); err != nil { log.Fatalf(">>> ❌ 审计日志打开失败: %v", err) }
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(AuditSignInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := signAuditLog(); err != nil { log.Printf("❌ 审计日志签名失败: %v", err) }
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		if err := signAuditLog(); err != nil { log.Printf("❌ 审计日志签名失败: %v", err) }
+		auditLog.Lock()
+		if auditLog.f != nil { auditLog.f.Close(); auditLog.f = nil }
+		auditLog.Unlock()
+	}
+}
+
+// ================= 读取与校验 =================
+
+// scanAuditLog 逐条读取审计日志
+func scanAuditLog(path string, fn func(AuditEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil { return err }
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 { continue }
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil { return fmt.Errorf("第 %d 行解析失败: %v", line, err) }
+		if err := fn(e); err != nil { return err }
+	}
+	return sc.Err()
+}
+
+// AuditReport 是一次完整性校验的结果
+type AuditReport struct {
+	Entries    int64
+	Signatures int
+	Unsigned   int      // 最后一次签名之后的记录数
+	Problems   []string // 哈希不符、断链、签名无效等，任何一条都意味着日志被改动
+	Warnings   []string // 无法验证的签名 (如密钥已轮换)
+}
+
+func (r *AuditReport) OK() bool { return len(r.Problems) == 0 }
+
+// verifyAuditLog 校验哈希链、序号连续性和签名
+func verifyAuditLog(path string, keys []crypto.PublicKey) (*AuditReport, error) {
+	rep := &AuditReport{}
+	prev, seq := "", int64(0)
+	err := scanAuditLog(path, func(e AuditEntry) error {
+		rep.Entries++
+		if e.Seq != seq+1 { rep.Problems = append(rep.Problems, fmt.Sprintf("#%d: 序号不连续 (上一条为 #%d)，可能有记录被删除或插入", e.Seq, seq)) }
+		if e.Prev != prev { rep.Problems = append(rep.Problems, fmt.Sprintf("#%d: 与上一条的哈希不衔接", e.Seq)) }
+		if h := e.computeHash(); h != e.Hash { rep.Problems = append(rep.Problems, fmt.Sprintf("#%d: 内容与哈希不符，记录被修改", e.Seq)) }
+		if e.Action == auditSignAction {
+			rep.Signatures++
+			rep.Unsigned = 0
+			switch ok, err := checkAuditSig(e, keys); {
+			case err != nil:
+				rep.Warnings = append(rep.Warnings, fmt.Sprintf("#%d: %v", e.Seq, err))
+			case !ok:
+				rep.Problems = append(rep.Problems, fmt.Sprintf("#%d: 签名无效", e.Seq))
+			}
+		} else {
+			rep.Unsigned++
+		}
+		prev, seq = e.Hash, e.Seq
+		return nil
+	})
+	return rep, err
+}
+
+func checkAuditSig(e AuditEntry, keys []crypto.PublicKey) (bool, error) {
+	sig, err := base64.StdEncoding.DecodeString(e.Sig)
+	if err != nil { return false, nil }
+	for _, key := range keys {
+		if client.KeyID(key) != e.KeyID { continue }
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			return ed25519.Verify(k, []byte(e.Prev), sig), nil
+		case *rsa.PublicKey:
+			sum := sha256.Sum256([]byte(e.Prev))
+			return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil, nil
+		}
+	}
+	return false, fmt.Errorf("找不到签名公钥 %s (可能已轮换)，无法验证签名", e.KeyID)
+}
+
+// cmdAudit 命令行校验审计日志，发现问题时返回错误 (退出码 1)
+//
+//	license-server audit verify [-file audit.log]
+func cmdAudit(args []string) error {
+	if len(args) == 0 || args[0] != "verify" { return fmt.Errorf("用法: audit verify [-file 路径]") }
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	file := fs.String("file", AuditLogPath, "审计日志文件")
+	fs.Parse(args[1:])
+
+	rep, err := verifyAuditLog(*file, verificationKeys())
+	if err != nil { return err }
+	for _, w := range rep.Warnings { log.Printf("⚠️ %s", w) }
+	for _, p := range rep.Problems { log.Printf("❌ %s", p) }
+	log.Printf(">>> 共 %d 条记录，%d 个签名，最后 %d 条尚未签名", rep.Entries, rep.Signatures, rep.Unsigned)
+	if !rep.OK() { return fmt.Errorf("审计日志校验未通过，发现 %d 处问题", len(rep.Problems)) }
+	log.Println(">>> ✅ 审计日志完整")
+	return nil
+}
+
+// ================= 查看页面 =================
+
+// AuditFilter 是审计页面的查询条件，空字段不限制
+type AuditFilter struct {
+	Actor, Action, IP, Query string
+	From, To                 string // yyyy-mm-dd (本地时间)
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	if f.Actor != "" && !strings.EqualFold(e.Actor, f.Actor) { return false }
+	if f.Action != "" && !strings.HasPrefix(e.Action, f.Action) { return false }
+	if f.IP != "" && e.IP != f.IP { return false }
+	if f.Query != "" && !strings.Contains(strings.ToLower(e.Target+" "+e.Detail), strings.ToLower(f.Query)) { return false }
+	if f.From != "" || f.To != "" {
+		t, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil { return false }
+		day := t.Local().Format("2006-01-02")
+		if (f.From != "" && day < f.From) || (f.To != "" && day > f.To) { return false }
+	}
+	return true
+}
+
+// handleAudit 审计日志查看页面，支持按账号、操作、IP、日期和关键字筛选；verify=1 时附带完整性校验结果
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermAudit, "") == nil { return }
+	q := r.URL.Query()
+	f := AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), IP: q.Get("ip"), Query: q.Get("q"), From: q.Get("from"), To: q.Get("to")}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 { page = 1 }
+	const pageSize = 50
+
+	var matched []AuditEntry
+	err := scanAuditLog(AuditLogPath, func(e AuditEntry) error {
+		if f.match(e) { matched = append(matched, e) }
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) { http.Error(w, err.Error(), 500); return }
+	total := len(matched)
+	totalPages := (total + pageSize - 1) / pageSize
+	if totalPages == 0 { totalPages = 1 }
+	if page > totalPages { page = totalPages }
+
+	rowsHtml := ""
+	for i := total - 1 - (page-1)*pageSize; i >= 0 && i > total-1-page*pageSize; i-- {
+		e := matched[i]
+		t, _ := time.Parse(time.RFC3339, e.Time)
+		detail := e.Detail
+		if e.Action == auditSignAction { detail += " · kid " + e.KeyID }
+		rowsHtml += fmt.Sprintf(`<tr><td style="color:#888">%d</td><td style="white-space:nowrap">%s</td><td>%s</td><td style="font-family:monospace">%s</td><td><b>%s</b></td><td style="font-family:monospace;font-size:12px">%s</td><td style="font-size:12px;color:#555">%s</td></tr>`,
+			e.Seq, t.Local().Format("2006-01-02 15:04:05"), html.EscapeString(e.Actor), html.EscapeString(e.IP), html.EscapeString(e.Action), html.EscapeString(e.Target), html.EscapeString(detail))
+	}
+
+	verifyHtml := `<a href="?verify=1" style="color:#0071e3;text-decoration:none">🔍 校验完整性</a>`
+	if q.Get("verify") == "1" {
+		rep, err := verifyAuditLog(AuditLogPath, verificationKeys())
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			verifyHtml = `<span style="color:#c00">校验失败: ` + html.EscapeString(err.Error()) + `</span>`
+		case err != nil:
+			verifyHtml = `<span style="color:#888">尚无审计记录</span>`
+		case rep.OK():
+			verifyHtml = fmt.Sprintf(`<span style="color:green">✅ 完整：%d 条记录，%d 个签名，最后 %d 条尚未签名</span>`, rep.Entries, rep.Signatures, rep.Unsigned)
+		default:
+			verifyHtml = fmt.Sprintf(`<span style="color:#c00">❌ 发现 %d 处问题：%s</span>`, len(rep.Problems), html.EscapeString(strings.Join(rep.Problems, "；")))
+		}
+		if err == nil && len(rep.Warnings) > 0 { verifyHtml += `<div style="color:#c77700;font-size:12px">` + html.EscapeString(strings.Join(rep.Warnings, "；")) + `</div>` }
+	}
+
+	params := url.Values{}
+	for k, v := range map[string]string{"actor": f.Actor, "action": f.Action, "ip": f.IP, "q": f.Query, "from": f.From, "to": f.To} {
+		if v != "" { params.Set(k, v) }
+	}
+	navHtml := `<div style="margin-top:20px;text-align:center">`
+	if page > 1 { params.Set("page", strconv.Itoa(page-1)); navHtml += fmt.Sprintf(`<a href="/audit?%s" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">上一页</a> `, html.EscapeString(params.Encode())) }
+	navHtml += fmt.Sprintf(`<span style="margin:0 10px">第 %d / %d 页 (共 %d 条)</span>`, page, totalPages, total)
+	if page < totalPages { params.Set("page", strconv.Itoa(page+1)); navHtml += fmt.Sprintf(`<a href="/audit?%s" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">下一页</a>`, html.EscapeString(params.Encode())) }
+	navHtml += `</div>`
+
+	input := func(name, value, placeholder, typ string) string {
+		return fmt.Sprintf(`<input name="%s" value="%s" placeholder="%s" type="%s">`, name, html.EscapeString(value), placeholder, typ)
+	}
+	form := `<form method="GET" style="display:flex;gap:6px;flex-wrap:wrap;font-size:14px">` + input("actor", f.Actor, "账号", "text") + input("action", f.Action, "操作 (前缀，如 license.)", "text") + input("ip", f.IP, "IP", "text") + input("q", f.Query, "对象 / 详情关键字", "text") + input("from", f.From, "", "date") + input("to", f.To, "", "date") + `<button type="submit">筛选</button> <a href="/audit" style="align-self:center;color:#0071e3;text-decoration:none">清除</a></form>`
+
+	body := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>审计日志</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1100px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:10px;border-bottom:1px solid #f5f5f5;color:#333;word-break:break-all}input{padding:6px 8px;border:1px solid #ccc;border-radius:4px}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">📝 审计日志 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2>
+	<div style="margin-bottom:12px;font-size:14px">%s</div>%s
+	<table><thead><tr><th>#</th><th>时间</th><th>账号</th><th>IP</th><th>操作</th><th>对象</th><th>详情</th></tr></thead><tbody>%s</tbody></table>%s</div></body></html>`,
+		verifyHtml, form, rowsHtml, navHtml)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(body))
+}
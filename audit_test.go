@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useAuditLog 把审计日志指向临时文件，并在结束时恢复全局状态
+func useAuditLog(t *testing.T) string {
+	oldPath := AuditLogPath
+	AuditLogPath = filepath.Join(t.TempDir(), "audit.log")
+	resetAuditLog()
+	t.Cleanup(func() { resetAuditLog(); AuditLogPath = oldPath })
+	return AuditLogPath
+}
+
+func resetAuditLog() {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.f != nil { auditLog.f.Close() }
+	auditLog.f, auditLog.size, auditLog.seq, auditLog.last, auditLog.unsigned = nil, 0, 0, "", 0
+}
+
+func appendTestAudit(t *testing.T, action string) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if err := appendAudit(AuditEntry{Time: "2026-01-01T00:00:00Z", Actor: "admin", Action: action}); err != nil { t.Fatal(err) }
+}
+
+// 追加时崩溃留下的残缺末行在启动时丢弃，之后的记录接在完整的链尾
+func TestAuditLogDropsTornTail(t *testing.T) {
+	path := useAuditLog(t)
+	if err := openAuditLog(); err != nil { t.Fatal(err) }
+	appendTestAudit(t, "license.generate")
+	appendTestAudit(t, "license.revoke")
+	resetAuditLog()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil { t.Fatal(err) }
+	f.Write([]byte(`{"seq":3,"time":"2026-01-01T00:0`))
+	f.Close()
+
+	if err := openAuditLog(); err != nil { t.Fatalf("残缺末行导致无法启动: %v", err) }
+	if auditLog.seq != 2 { t.Fatalf("链尾序号 = %d, 期望 2", auditLog.seq) }
+	appendTestAudit(t, "license.delete")
+
+	rep, err := verifyAuditLog(path, nil)
+	if err != nil { t.Fatal(err) }
+	if !rep.OK() || rep.Entries != 3 { t.Fatalf("校验结果: %+v", rep) }
+}
+
+// 残缺的只能是末行，中间的损坏记录仍然拒绝启动
+func TestAuditLogRejectsCorruptMiddleLine(t *testing.T) {
+	path := useAuditLog(t)
+	if err := openAuditLog(); err != nil { t.Fatal(err) }
+	appendTestAudit(t, "license.generate")
+	resetAuditLog()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil { t.Fatal(err) }
+	f.Write([]byte("{\"seq\":2,\"ti\n"))
+	f.Close()
+	if err := openAuditLog(); err == nil { t.Fatal("中间的损坏记录应当报错") }
+}
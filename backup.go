@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ================= 定时备份 =================
+//
+// BACKUP_SCHEDULE 为标准 5 段 cron 表达式 (如 "0 3 * * *")，为空则不启用定时备份。
+// 每一代备份包含：
+//
+//	backup-<时间>.jsonl.gz   存储的完整导出 (格式见 migrate.go)
+//	keyring-<时间>.enc       签名私钥，仅在设置了 BACKUP_KEYRING_PASSPHRASE 时生成，scrypt + AES-256-GCM 加密
+//
+// 上传后立即取回重新解析，数量不符即视为失败；成功后只保留最近 BACKUP_KEEP 代。
+// BACKUP_TARGET 为本地目录 (默认 backups) 或 s3://bucket/前缀，S3 兼容服务通过 BACKUP_S3_* 配置。
+
+var (
+	BackupSchedule   = os.Getenv("BACKUP_SCHEDULE")
+	BackupTarget     = getEnv("BACKUP_TARGET", "backups")
+	BackupKeep       = getEnvInt("BACKUP_KEEP", 7)
+	BackupPassphrase = os.Getenv("BACKUP_KEYRING_PASSPHRASE")
+
+	BackupS3Endpoint  = getEnv("BACKUP_S3_ENDPOINT", "https://s3.amazonaws.com")
+	BackupS3Region    = getEnv("BACKUP_S3_REGION", "us-east-1")
+	BackupS3AccessKey = os.Getenv("BACKUP_S3_ACCESS_KEY")
+	BackupS3SecretKey = os.Getenv("BACKUP_S3_SECRET_KEY")
+)
+
+const (
+	backupStampLayout = "20060102-150405"
+	keyRingMagic      = "LICKR1"
+)
+
+// backupTarget 是备份文件的存放位置，name 为不含目录的文件名
+type backupTarget interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List() ([]string, error)
+	Delete(name string) error
+	String() string
+}
+
+type dirTarget struct{ dir string }
+
+func (t dirTarget) Put(name string, data []byte) error {
+	if err := os.MkdirAll(t.dir, 0700); err != nil { return err }
+	return writeFileAtomic(filepath.Join(t.dir, name), data)
+}
+func (t dirTarget) Get(name string) ([]byte, error) { return os.ReadFile(filepath.Join(t.dir, name)) }
+func (t dirTarget) Delete(name string) error         { return os.Remove(filepath.Join(t.dir, name)) }
+func (t dirTarget) String() string                   { return t.dir }
+
+func (t dirTarget) List() ([]string, error) {
+	entries, err := os.ReadDir(t.dir)
+	if os.IsNotExist(err) { return nil, nil }
+	if err != nil { return nil, err }
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() { names = append(names, e.Name()) }
+	}
+	return names, nil
+}
+
+type s3Target struct {
+	client *s3Client
+	prefix string
+}
+
+func (t s3Target) Put(name string, data []byte) error { return t.client.Put(t.prefix+name, data) }
+func (t s3Target) Get(name string) ([]byte, error)   { return t.client.Get(t.prefix + name) }
+func (t s3Target) Delete(name string) error          { return t.client.Delete(t.prefix + name) }
+func (t s3Target) String() string                    { return "s3://" + t.client.bucket + "/" + t.prefix }
+
+func (t s3Target) List() ([]string, error) {
+	keys, err := t.client.List(t.prefix)
+	if err != nil { return nil, err }
+	var names []string
+	for _, k := range keys {
+		if name := strings.TrimPrefix(k, t.prefix); !strings.Contains(name, "/") { names = append(names, name) }
+	}
+	return names, nil
+}
+
+// openBackupTarget 解析 BACKUP_TARGET：s3://bucket/前缀 或本地目录
+func openBackupTarget(spec string) (backupTarget, error) {
+	if !strings.HasPrefix(spec, "s3://") { return dirTarget{dir: spec}, nil }
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(spec, "s3://"), "/")
+	if bucket == "" { return nil, fmt.Errorf("备份目标缺少 bucket: %s", spec) }
+	if BackupS3AccessKey == "" || BackupS3SecretKey == "" { return nil, fmt.Errorf("未设置 BACKUP_S3_ACCESS_KEY / BACKUP_S3_SECRET_KEY") }
+	if prefix != "" && !strings.HasSuffix(prefix, "/") { prefix += "/" }
+	c := &s3Client{endpoint: BackupS3Endpoint, region: BackupS3Region, accessKey: BackupS3AccessKey, secretKey: BackupS3SecretKey, bucket: bucket, http: &http.Client{Timeout: 5 * time.Minute}}
+	return s3Target{client: c, prefix: prefix}, nil
+}
+
+func backupDataName(stamp string) string    { return "backup-" + stamp + ".jsonl.gz" }
+func backupKeyRingName(stamp string) string { return "keyring-" + stamp + ".enc" }
+
+// listBackupStamps 返回目标中已有备份的时间戳，新 → 旧
+func listBackupStamps(t backupTarget) ([]string, error) {
+	names, err := t.List()
+	if err != nil { return nil, err }
+	var stamps []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "backup-") && strings.HasSuffix(name, ".jsonl.gz") {
+			stamps = append(stamps, strings.TrimSuffix(strings.TrimPrefix(name, "backup-"), ".jsonl.gz"))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(stamps)))
+	return stamps, nil
+}
+
+// runBackup 生成一代备份：导出 → 上传 → 取回校验 → 清理旧备份
+func runBackup(st Store, t backupTarget) (string, error) {
+	stamp := time.Now().Format(backupStampLayout)
+	data, err := readStoreData(st)
+	if err != nil { return "", fmt.Errorf("读取存储失败: %v", err) }
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := exportData(zw, data, StoreBackend); err != nil { return "", err }
+	if err := zw.Close(); err != nil { return "", err }
+	name := backupDataName(stamp)
+	if err := t.Put(name, buf.Bytes()); err != nil { return "", fmt.Errorf("上传 %s 失败: %v", name, err) }
+
+	got, err := t.Get(name)
+	if err != nil { return "", fmt.Errorf("取回 %s 失败: %v", name, err) }
+	check, err := decodeBackup(got)
+	if err != nil { return "", fmt.Errorf("备份 %s 校验失败: %v", name, err) }
+	if len(check.Licenses) != len(data.Licenses) || len(check.Machines) != len(data.Machines) || check.docCount() != data.docCount() {
+		return "", fmt.Errorf("备份 %s 校验失败: 数量与存储不一致", name)
+	}
+
+	if BackupPassphrase != "" {
+		ring, err := buildKeyRing()
+		if err != nil { return "", err }
+		blob, err := encryptKeyRing(ring, BackupPassphrase)
+		if err != nil { return "", err }
+		krName := backupKeyRingName(stamp)
+		if err := t.Put(krName, blob); err != nil { return "", fmt.Errorf("上传 %s 失败: %v", krName, err) }
+		if got, err = t.Get(krName); err != nil { return "", fmt.Errorf("取回 %s 失败: %v", krName, err) }
+		if _, err := decryptKeyRing(got, BackupPassphrase); err != nil { return "", fmt.Errorf("密钥备份 %s 校验失败: %v", krName, err) }
+	}
+
+	if err := pruneBackups(t, BackupKeep); err != nil { log.Printf("⚠️ 清理旧备份失败: %v", err) }
+	log.Printf(">>> 💾 备份完成: %s/%s (%d 条记录, %d 台机器, %d 字节)", t, name, len(data.Licenses), len(data.Machines), buf.Len())
+	return name, nil
+}
+
+// pruneBackups 只保留最近 keep 代，连同对应的密钥备份一起删除
+func pruneBackups(t backupTarget, keep int) error {
+	stamps, err := listBackupStamps(t)
+	if err != nil { return err }
+	names, err := t.List()
+	if err != nil { return err }
+	exists := map[string]bool{}
+	for _, n := range names { exists[n] = true }
+	for i := keep; i < len(stamps); i++ {
+		if err := t.Delete(backupDataName(stamps[i])); err != nil { return err }
+		if kr := backupKeyRingName(stamps[i]); exists[kr] {
+			if err := t.Delete(kr); err != nil { return err }
+		}
+	}
+	return nil
+}
+
+// decodeBackup 解压并解析备份文件，同时兼容未压缩的导出文件
+func decodeBackup(blob []byte) (*storeData, error) {
+	var r io.Reader = bytes.NewReader(blob)
+	if len(blob) > 2 && blob[0] == 0x1f && blob[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil { return nil, err }
+		r = zr
+	}
+	return parseExport(r)
+}
+
+// startBackupScheduler 按 BACKUP_SCHEDULE 定时备份，返回的函数停止调度并等待进行中的备份结束
+func startBackupScheduler() func() {
+	if BackupSchedule == "" { return func() {} }
+	sched, err := cron.ParseStandard(BackupSchedule)
+	if err != nil { log.Fatalf(">>> ❌ BACKUP_SCHEDULE 格式错误: %v", err) }
+	target, err := openBackupTarget(BackupTarget)
+	if err != nil { log.Fatalf(">>> ❌ BACKUP_TARGET 配置错误: %v", err) }
+	log.Printf("✅ 定时备份已启用 (%s → %s，保留 %d 代)", BackupSchedule, target, BackupKeep)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			timer := time.NewTimer(time.Until(sched.Next(time.Now())))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+				if _, err := runBackup(store, target); err != nil {
+					log.Printf("❌ 定时备份失败: %v", err)
+					sendTelegramMessage(fmt.Sprintf("❌ 定时备份失败\n目标: %s\n错误: %s", html.EscapeString(target.String()), html.EscapeString(err.Error())))
+				}
+			}
+		}
+	}()
+	return func() { close(stop); wg.Wait() }
+}
+
+// ================= 密钥备份 =================
+
+// buildKeyRing 收集当前使用的签名私钥，文件名 → PEM
+func buildKeyRing() (map[string]string, error) {
+	ring := map[string]string{}
+	if k, err := loadPrivateKey(); err == nil {
+		ring["private.pem"] = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}))
+	}
+	if k, err := loadShortKeySigner(); err == nil {
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil { return nil, err }
+		ring["ed25519.pem"] = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	if len(ring) == 0 { return nil, fmt.Errorf("没有可备份的私钥") }
+	return ring, nil
+}
+
+// encryptKeyRing 输出 magic | salt(16) | nonce(12) | AES-256-GCM 密文，magic 作为附加数据参与认证
+func encryptKeyRing(ring map[string]string, passphrase string) ([]byte, error) {
+	plain, err := json.Marshal(ring)
+	if err != nil { return nil, err }
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil { return nil, err }
+	gcm, err := keyRingCipher(passphrase, salt)
+	if err != nil { return nil, err }
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil { return nil, err }
+	out := append([]byte(keyRingMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(keyRingMagic)), nil
+}
+
+// decryptKeyRing 解密并确认每个私钥都能正常解析
+func decryptKeyRing(blob []byte, passphrase string) (map[string]string, error) {
+	if len(blob) < len(keyRingMagic)+16+12 || string(blob[:len(keyRingMagic)]) != keyRingMagic { return nil, fmt.Errorf("不是密钥备份文件") }
+	salt := blob[len(keyRingMagic) : len(keyRingMagic)+16]
+	gcm, err := keyRingCipher(passphrase, salt)
+	if err != nil { return nil, err }
+	rest := blob[len(keyRingMagic)+16:]
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], []byte(keyRingMagic))
+	if err != nil { return nil, fmt.Errorf("解密失败，口令错误或文件已损坏") }
+	var ring map[string]string
+	if err := json.Unmarshal(plain, &ring); err != nil { return nil, err }
+	for name, p := range ring {
+		block, _ := pem.Decode([]byte(p))
+		if block == nil { return nil, fmt.Errorf("%s 不是 PEM", name) }
+		switch name {
+		case "private.pem":
+			_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "ed25519.pem":
+			var k interface{}
+			if k, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+				if _, ok := k.(ed25519.PrivateKey); !ok { err = fmt.Errorf("不是 Ed25519 私钥") }
+			}
+		default:
+			err = fmt.Errorf("未知文件")
+		}
+		if err != nil { return nil, fmt.Errorf("%s 无效: %v", name, err) }
+	}
+	return ring, nil
+}
+
+func keyRingCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil { return nil, err }
+	block, err := aes.NewCipher(key)
+	if err != nil { return nil, err }
+	return cipher.NewGCM(block)
+}
+
+// ================= 命令行 =================
+
+func cmdBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	spec := fs.String("store", "", "存储 (类型:路径)，默认取环境变量")
+	targetSpec := fs.String("target", BackupTarget, "备份目标：本地目录或 s3://bucket/前缀")
+	fs.Parse(args)
+
+	target, err := openBackupTarget(*targetSpec)
+	if err != nil { return err }
+	st, err := openStoreSpec(*spec)
+	if err != nil { return err }
+	defer st.Close()
+	_, err = runBackup(st, target)
+	return err
+}
+
+// cmdRestore 恢复一代备份 (需先停止服务)：
+//
+//	server restore [-store 类型:路径] [-target 目标] [-keys] latest|<时间>|<本地文件>
+//
+// 先把备份导入同类型的临时存储并重新读出核对，全部通过后才把原数据移到 *.pre-restore-<时间> 并换入新数据。
+func cmdRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	spec := fs.String("store", "", "存储 (类型:路径)，默认取环境变量")
+	targetSpec := fs.String("target", BackupTarget, "备份目标：本地目录或 s3://bucket/前缀")
+	withKeys := fs.Bool("keys", false, "同时恢复签名私钥 (需要 BACKUP_KEYRING_PASSPHRASE)")
+	fs.Parse(args)
+	if fs.NArg() != 1 { return fmt.Errorf("用法: restore [-store 类型:路径] [-target 目标] [-keys] latest|<时间>|<本地文件>") }
+
+	var blob []byte
+	var ring map[string]string
+	stamp, arg := "", fs.Arg(0)
+	if _, err := os.Stat(arg); err == nil {
+		if *withKeys { return fmt.Errorf("从本地文件恢复时不支持 -keys") }
+		if blob, err = os.ReadFile(arg); err != nil { return err }
+	} else {
+		target, err := openBackupTarget(*targetSpec)
+		if err != nil { return err }
+		stamp = arg
+		if stamp == "latest" {
+			stamps, err := listBackupStamps(target)
+			if err != nil { return err }
+			if len(stamps) == 0 { return fmt.Errorf("%s 中没有备份", target) }
+			stamp = stamps[0]
+		}
+		if blob, err = target.Get(backupDataName(stamp)); err != nil { return fmt.Errorf("读取备份失败: %v", err) }
+		if *withKeys {
+			if BackupPassphrase == "" { return fmt.Errorf("未设置 BACKUP_KEYRING_PASSPHRASE") }
+			enc, err := target.Get(backupKeyRingName(stamp))
+			if err != nil { return fmt.Errorf("读取密钥备份失败: %v", err) }
+			if ring, err = decryptKeyRing(enc, BackupPassphrase); err != nil { return err }
+		}
+	}
+
+	data, err := decodeBackup(blob)
+	if err != nil { return fmt.Errorf("备份校验失败: %v", err) }
+	log.Printf(">>> 备份校验通过: %d 条记录, %d 台机器, %d 个文档", len(data.Licenses), len(data.Machines), data.docCount())
+
+	backend, path := StoreBackend, StorePath
+	if *spec != "" { backend, path, _ = strings.Cut(*spec, ":") }
+	if path == "" { path = defaultStorePath(backend) }
+	if err := restoreStore(backend, path, data); err != nil { return err }
+	if ring != nil { return restoreKeyRing(ring) }
+	return nil
+}
+
+// restoreStore 先写入临时存储并核对，再替换正式数据
+func restoreStore(backend, path string, data *storeData) error {
+	tmp := path + ".restore"
+	if backend == "" || backend == "json" { tmp = filepath.Join(path, ".restore") }
+	for _, ext := range []string{"", "-wal", "-shm"} { os.RemoveAll(tmp + ext) }
+	if backend == "" || backend == "json" {
+		if err := os.MkdirAll(tmp, 0700); err != nil { return err }
+	}
+
+	st, err := openStore(backend, tmp)
+	if err != nil { return err }
+	if err := writeStoreData(st, data, false); err != nil { st.Close(); return err }
+	check, err := readStoreData(st)
+	if err == nil && (len(check.Licenses) != len(data.Licenses) || len(check.Machines) != len(data.Machines)) { err = fmt.Errorf("数量不一致") }
+	for kind, docs := range data.Docs {
+		if err != nil { break }
+		got, lerr := st.ListDocs(kind)
+		if lerr != nil { err = lerr } else if len(got) != len(docs) { err = fmt.Errorf("文档 %s 数量不一致", kind) }
+	}
+	if cerr := st.Close(); err == nil { err = cerr }
+	if err != nil { os.RemoveAll(tmp); return fmt.Errorf("临时存储核对失败，原数据未改动: %v", err) }
+
+	suffix := ".pre-restore-" + time.Now().Format(backupStampLayout)
+	if backend == "" || backend == "json" {
+		aside := filepath.Join(path, "pre-restore-"+time.Now().Format(backupStampLayout))
+		names := []string{historyFile, machineFile, journalFile, compactFile}
+		for _, kind := range docKinds { names = append(names, kind+".json") }
+		for kind := range data.Docs { names = append(names, kind+".json") }
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(path, name)); err != nil { continue }
+			if err := os.MkdirAll(aside, 0700); err != nil { return err }
+			if err := os.Rename(filepath.Join(path, name), filepath.Join(aside, name)); err != nil { return err }
+		}
+		entries, err := os.ReadDir(tmp)
+		if err != nil { return err }
+		for _, e := range entries {
+			if err := os.Rename(filepath.Join(tmp, e.Name()), filepath.Join(path, e.Name())); err != nil { return err }
+		}
+		os.RemoveAll(tmp)
+		syncDir(path)
+		log.Printf(">>> ✅ 恢复完成，原数据已移到 %s", aside)
+		return nil
+	}
+
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Stat(path + ext); err == nil {
+			if err := os.Rename(path+ext, path+suffix+ext); err != nil { return err }
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil { return err }
+	syncDir(filepath.Dir(path))
+	log.Printf(">>> ✅ 恢复完成，原数据已移到 %s", path+suffix)
+	return nil
+}
+
+// restoreKeyRing 写回私钥文件，原文件改名保留
+func restoreKeyRing(ring map[string]string) error {
+	suffix := ".pre-restore-" + time.Now().Format(backupStampLayout)
+	for name, p := range ring {
+		if _, err := os.Stat(name); err == nil {
+			if err := os.Rename(name, name+suffix); err != nil { return err }
+		}
+		if err := writeFileAtomic(name, []byte(p)); err != nil { return err }
+		log.Printf(">>> 🔑 已恢复 %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================= 批量生成 =================
+
+const (
+	MaxBatchRows  = 1000
+	MaxBatchBytes = 5 << 20
+)
+
+type BatchRow struct {
+	MachineID string `json:"machine_id"`
+	Expiry    string `json:"expiry"`
+	Customer  string `json:"customer,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Features  uint16 `json:"features,omitempty"`
+}
+
+type BatchRequest struct {
+	Token         string     `json:"token"`
+	Format        string     `json:"format,omitempty"`         // 输出格式 csv / zip / json
+	LicenseFormat string     `json:"license_format,omitempty"` // 激活码格式，为空时按每行的产品配置
+	Source        string     `json:"source,omitempty"`
+	Rows          []BatchRow `json:"rows"`
+}
+
+type BatchResult struct {
+	Row         int    `json:"row"`
+	MachineID   string `json:"machine_id"`
+	Expiry      string `json:"expiry"`
+	Customer    string `json:"customer,omitempty"`
+	Product     string `json:"product,omitempty"`
+	LicenseID   string `json:"license_id,omitempty"`
+	LicenseCode string `json:"license_code,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// handleBatchGenerate 接收 CSV/JSON 文件 (multipart 表单) 或 JSON 请求体，
+// 先校验全部行，再统一签发并一次性写入历史，最后返回 CSV/ZIP/JSON 结果。
+func handleBatchGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "405", 405); return }
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBatchBytes)
+
+	var req BatchRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(MaxBatchBytes); err != nil { http.Error(w, "表单解析失败: "+err.Error(), 400); return }
+		req.Token = r.FormValue("token")
+		req.Format = r.FormValue("format")
+		req.LicenseFormat = r.FormValue("license_format")
+		req.Source = r.FormValue("source")
+		file, header, err := r.FormFile("file")
+		if err != nil { http.Error(w, "请上传 CSV 或 JSON 文件", 400); return }
+		defer file.Close()
+		content, err := io.ReadAll(file)
+		if err != nil { http.Error(w, err.Error(), 400); return }
+		if req.Rows, err = parseBatchFile(header.Filename, content); err != nil { http.Error(w, err.Error(), 400); return }
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, err.Error(), 400); return }
+	}
+	p := authorize(w, r, PermGenerate, req.Token)
+	if p == nil { return }
+
+	if len(req.Rows) == 0 { http.Error(w, "没有可处理的数据行", 400); return }
+	if len(req.Rows) > MaxBatchRows { http.Error(w, fmt.Sprintf("单次最多 %d 行", MaxBatchRows), 400); return }
+	format := strings.ToLower(req.Format)
+	if format == "" { format = "csv" }
+	if format != "csv" && format != "zip" && format != "json" { http.Error(w, "不支持的输出格式: "+req.Format, 400); return }
+
+	// 请求本身有效时才要求二次验证，避免先输入验证码再被拒绝
+	for _, row := range req.Rows {
+		if longLicense(row.Expiry) { if !stepUp(w, r, p) { return }; break }
+	}
+
+	results, err := generateBatch(req.Rows, req.LicenseFormat, licenseSource(req.Source), p)
+	var qe *QuotaError
+	if errors.As(err, &qe) { denyOverQuota(w, r, p, err); return }
+	if err != nil { log.Printf("批量生成失败: %v", err); http.Error(w, err.Error(), 500); return }
+
+	failed := 0
+	for _, res := range results { if res.Error != "" { failed++ } }
+	audit(r, p, "license.batch", "", fmt.Sprintf("total=%d failed=%d", len(results), failed))
+	if ok := len(results) - failed; ok > 0 {
+		sendTelegramMessage(fmt.Sprintf("📦 <b>批量激活码已生成!</b>\n\n"+
+			"✅ <b>成功:</b> %d 条\n"+
+			"❌ <b>失败:</b> %d 条\n"+
+			"👤 <b>签发人:</b> %s\n"+
+			"🕒 <b>时间:</b> %s",
+			ok, failed, html.EscapeString(p.Name), time.Now().Format("2006-01-02 15:04:05")))
+	}
+
+	w.Header().Set("X-Batch-Total", strconv.Itoa(len(results)))
+	w.Header().Set("X-Batch-Failed", strconv.Itoa(failed))
+	stamp := time.Now().Format("20060102-150405")
+
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(results)
+	case "zip":
+		data, err := buildBatchZip(results)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="licenses-%s.zip"`, stamp))
+		w.Write(data)
+	default:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="licenses-%s.csv"`, stamp))
+		w.Write(buildBatchCSV(results))
+	}
+}
+
+// generateBatch 先校验所有行并按通过校验的行扣减配额 (不足时整批拒绝)，再签发激活码，并在同一事务中保存全部成功记录
+func generateBatch(rows []BatchRow, licenseFormat, source string, p *Principal) ([]BatchResult, error) {
+	results := make([]BatchResult, len(rows))
+	expiries := make([]int64, len(rows))
+	formats := make([]string, len(rows))
+	seen := map[string]int{}
+	for i, row := range rows {
+		res := BatchResult{Row: i + 1, MachineID: strings.TrimSpace(row.MachineID), Expiry: strings.TrimSpace(row.Expiry), Customer: strings.TrimSpace(row.Customer), Product: strings.TrimSpace(row.Product)}
+		switch {
+		case res.MachineID == "" || res.Expiry == "":
+			res.Error = "机器码或日期为空"
+		case !machineIDPattern.MatchString(res.MachineID):
+			res.Error = machineIDRule
+		case seen[res.MachineID] > 0:
+			res.Error = fmt.Sprintf("与第 %d 行机器码重复", seen[res.MachineID])
+		default:
+			seen[res.MachineID] = res.Row
+			exp, err := parseExpiry(res.Expiry)
+			if err != nil { res.Error = err.Error(); break }
+			expiries[i] = exp
+			if err := p.checkIssue(res.Product, exp); err != nil { res.Error = err.Error(); break }
+			if formats[i], err = resolveLicenseFormat(licenseFormat, res.Product); err != nil { res.Error = err.Error() }
+		}
+		results[i] = res
+	}
+
+	var charged []int64
+	for i := range results {
+		if results[i].Error == "" { charged = append(charged, expiries[i]) }
+	}
+	if err := chargeQuota(p.Name, charged, true); err != nil { return nil, err }
+
+	signer := &licenseSigner{}
+	var recs []HistoryRecord
+	var failed, issued []int64
+	for i := range results {
+		if results[i].Error != "" { continue }
+		params := LicenseParams{MachineID: results[i].MachineID, Expiry: results[i].Expiry, Features: rows[i].Features, Format: formats[i], Product: results[i].Product}
+		rec, err := signer.issue(params, expiries[i])
+		if err != nil { results[i].Error = err.Error(); failed = append(failed, expiries[i]); continue }
+		results[i].LicenseID, results[i].LicenseCode = rec.ID, rec.LicenseCode
+		rec.Customer, rec.Source, rec.IssuedBy = results[i].Customer, source, p.Name
+		recs = append(recs, rec)
+		issued = append(issued, expiries[i])
+	}
+	refundQuota(p.Name, failed)
+
+	if len(recs) > 0 {
+		if err := store.AddLicenses(recs); err != nil {
+			refundQuota(p.Name, issued)
+			return nil, fmt.Errorf("保存记录失败，本批次未生效: %v", err)
+		}
+	}
+	return results, nil
+}
+
+// parseBatchFile 根据扩展名或内容判断是 JSON 数组还是 CSV
+func parseBatchFile(name string, content []byte) ([]BatchRow, error) {
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(content)
+	if strings.HasSuffix(strings.ToLower(name), ".json") || bytes.HasPrefix(trimmed, []byte("[")) {
+		var rows []BatchRow
+		if err := json.Unmarshal(trimmed, &rows); err != nil { return nil, fmt.Errorf("JSON 格式错误: %v", err) }
+		return rows, nil
+	}
+	return parseBatchCSV(content)
+}
+
+// parseBatchCSV 支持带表头 (按列名匹配) 或不带表头 (按 机器码,到期日期,客户,产品,功能位 顺序) 的 CSV
+func parseBatchCSV(content []byte) ([]BatchRow, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil { return nil, fmt.Errorf("CSV 格式错误: %v", err) }
+	if len(records) == 0 { return nil, nil }
+
+	cols := map[string]int{"machine_id": 0, "expiry": 1, "customer": 2, "product": 3, "features": 4}
+	aliases := map[string]string{
+		"machine_id": "machine_id", "机器码": "machine_id",
+		"expiry": "expiry", "expiry_date": "expiry", "到期日期": "expiry", "到期日": "expiry",
+		"customer": "customer", "客户": "customer",
+		"product": "product", "产品": "product",
+		"features": "features", "功能": "features",
+	}
+	header := map[string]int{}
+	for i, cell := range records[0] {
+		if key, ok := aliases[strings.ToLower(strings.TrimSpace(cell))]; ok { header[key] = i }
+	}
+	if _, ok := header["machine_id"]; ok {
+		cols = map[string]int{"machine_id": -1, "expiry": -1, "customer": -1, "product": -1, "features": -1}
+		for k, v := range header { cols[k] = v }
+		records = records[1:]
+	}
+
+	get := func(rec []string, key string) string {
+		if i := cols[key]; i >= 0 && i < len(rec) { return rec[i] }
+		return ""
+	}
+	rows := make([]BatchRow, 0, len(records))
+	for n, rec := range records {
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" { continue }
+		row := BatchRow{MachineID: get(rec, "machine_id"), Expiry: get(rec, "expiry"), Customer: get(rec, "customer"), Product: get(rec, "product")}
+		if f := strings.TrimSpace(get(rec, "features")); f != "" {
+			v, err := strconv.ParseUint(f, 0, 16)
+			if err != nil { return nil, fmt.Errorf("第 %d 行 features 不是合法数字: %s", n+1, f) }
+			row.Features = uint16(v)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func buildBatchCSV(results []BatchResult) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\xef\xbb\xbf") // BOM，方便 Excel 正确识别 UTF-8
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"row", "machine_id", "expiry", "customer", "product", "license_id", "license_code", "error"})
+	for _, res := range results {
+		cw.Write([]string{strconv.Itoa(res.Row), res.MachineID, res.Expiry, res.Customer, res.Product, res.LicenseID, res.LicenseCode, res.Error})
+	}
+	cw.Flush()
+	return buf.Bytes()
+}
+
+// buildBatchZip 打包汇总 CSV，以及每台机器单独一个 .lic 授权文件
+func buildBatchZip(results []BatchResult) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("result.csv")
+	if err != nil { return nil, err }
+	f.Write(buildBatchCSV(results))
+
+	for _, res := range results {
+		if res.LicenseCode == "" { continue }
+		f, err := zw.Create(fmt.Sprintf("licenses/%03d_%s", res.Row, licenseFileName(res.MachineID)))
+		if err != nil { return nil, err }
+		f.Write(buildLicenseFile(res.LicenseID, res.MachineID, res.Expiry, res.LicenseCode))
+	}
+	if err := zw.Close(); err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
+
+// safeFileName 只保留字母数字和 -_. ，避免机器码中的特殊字符破坏压缩包路径
+func safeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' { return r }
+		return '_'
+	}, s)
+	if len(s) > 64 { s = s[:64] }
+	return s
+}
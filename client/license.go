@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ================= 标准激活码 (gzip + base64) =================
+
+var (
+	ErrLicenseFormat    = errors.New("激活码格式错误")
+	ErrLicenseSignature = errors.New("激活码签名无效")
+	ErrLicenseMachine   = errors.New("激活码与本机机器码不匹配")
+)
+
+// LicenseData 与服务端签名的数据结构一致
+type LicenseData struct {
+	LicenseID string `json:"license_id,omitempty"`
+	MachineID string `json:"machine_id"`
+	ExpiryUTC int64  `json:"expiry_utc"`
+	Features  uint16 `json:"features,omitempty"`
+}
+
+type license struct {
+	Data      string `json:"data"`
+	Signature string `json:"signature"`
+}
+
+// ExpiresAt 返回到期时刻
+func (d *LicenseData) ExpiresAt() time.Time { return time.Unix(d.ExpiryUTC, 0) }
+
+// VerifyLicense 校验标准激活码的 RSA 签名和机器码；machineID 为空时跳过机器码比对。
+// 只校验真实性，是否过期由调用方根据 ExpiresAt 判断。
+func VerifyLicense(code, machineID string, pub *rsa.PublicKey) (*LicenseData, error) {
+	compressed, err := base64.StdEncoding.DecodeString(code)
+	if err != nil { return nil, ErrLicenseFormat }
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil { return nil, ErrLicenseFormat }
+	raw, err := io.ReadAll(io.LimitReader(zr, 64<<10))
+	if err != nil { return nil, ErrLicenseFormat }
+
+	var lic license
+	if err := json.Unmarshal(raw, &lic); err != nil { return nil, ErrLicenseFormat }
+	dataJSON, err := base64.StdEncoding.DecodeString(lic.Data)
+	if err != nil { return nil, ErrLicenseFormat }
+	sig, err := base64.StdEncoding.DecodeString(lic.Signature)
+	if err != nil { return nil, ErrLicenseFormat }
+
+	hashed := sha256.Sum256(dataJSON)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig); err != nil { return nil, ErrLicenseSignature }
+
+	var data LicenseData
+	if err := json.Unmarshal(dataJSON, &data); err != nil { return nil, ErrLicenseFormat }
+	if machineID != "" && data.MachineID != machineID { return &data, ErrLicenseMachine }
+	return &data, nil
+}
+
+// ParsePublicKey 解析 PEM 格式 (PKIX "PUBLIC KEY") 的 RSA 或 Ed25519 公钥
+func ParsePublicKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil { return nil, fmt.Errorf("公钥 PEM 解析失败") }
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil { return nil, err }
+	switch k := pub.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("不支持的公钥类型 %T", pub)
+}
@@ -0,0 +1,278 @@
+// Package client 提供给客户端/集成方使用的激活码离线校验工具。
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"filippo.io/edwards25519"
+)
+
+// ================= 短激活码 =================
+//
+// 短激活码的二进制结构 (大端)：
+//
+//	[0]      key ID       签名公钥指纹的第一个字节
+//	[1:7]    machine hash SHA-256(机器码) 前 6 字节
+//	[7:9]    expiry day   自 2020-01-01 起的天数 (北京时间日期)
+//	[9:11]   features     功能位
+//	[11:59]  signature    48 字节 Schnorr 签名 (Ed25519 曲线，128 位挑战值)
+//
+// 整体用 Crockford base32 编码成 95 个字符，再追加 1 位 Luhn mod 32 校验字符，
+// 每 6 个字符用 - 分组，形如 XXXXXX-XXXXXX-...-XXXXXX。
+//
+// 签名是 Ed25519 曲线上的 Schnorr 签名 (e, s)：e = SHA-512(R || A || "LICSK1" || payload) 的前 16 字节，
+// s = r + e·a。私钥 a 与 JWT-EdDSA、PASETO 和审计日志签名共用，这样做是安全的：
+//   - nonce 不会重复：Ed25519 的 nonce 为 SHA-512(h[32:] || M)，短激活码的 nonce 由另一把从种子派生的密钥
+//     SHA-512("LICSK1/nonce" || seed) 计算，与任何 Ed25519 签名都不会出现同一个 r 配不同挑战值而泄露 a；
+//   - 签名不能互相转换：Ed25519 的挑战值是完整的 SHA-512(R || A || M) mod l，短激活码的挑战值带 "LICSK1"
+//     前缀且小于 2^128，把一种签名当作另一种使用需要找到 SHA-512 的原像。
+
+const (
+	ShortKeyPayloadSize = 11
+	ShortKeySigSize     = 48
+	ShortKeyChars       = 96
+	ShortKeyGroup       = 6
+
+	shortKeyDomain  = "LICSK1"
+	crockfordDigits = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+var (
+	ErrShortKeyFormat    = errors.New("短激活码格式错误")
+	ErrShortKeyChecksum  = errors.New("短激活码校验位错误，请检查是否输错")
+	ErrShortKeySignature = errors.New("短激活码签名无效")
+	ErrShortKeyMachine   = errors.New("短激活码与本机机器码不匹配")
+	ErrShortKeyKeyID     = errors.New("短激活码的签名密钥未知")
+
+	shortKeyEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cstZone       = time.FixedZone("CST", 8*3600)
+)
+
+// ShortKey 是解码后的短激活码内容
+type ShortKey struct {
+	KeyID       byte
+	MachineHash [6]byte
+	ExpiryDay   uint16
+	Features    uint16
+	Signature   []byte
+}
+
+// ShortKeyKeyID 返回公钥对应的 key ID (公钥 SHA-256 的第一个字节)
+func ShortKeyKeyID(pub ed25519.PublicKey) byte {
+	sum := sha256.Sum256(pub)
+	return sum[0]
+}
+
+// ShortKeyMachineHash 返回机器码哈希前缀
+func ShortKeyMachineHash(machineID string) [6]byte {
+	var h [6]byte
+	sum := sha256.Sum256([]byte(machineID))
+	copy(h[:], sum[:6])
+	return h
+}
+
+// ShortKeyDay 把 yyyy-mm-dd 日期转换成自 2020-01-01 起的天数
+func ShortKeyDay(date string) (uint16, error) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil { return 0, err }
+	days := int(t.Sub(shortKeyEpoch).Hours() / 24)
+	if days < 0 || days > 0xFFFF { return 0, fmt.Errorf("日期超出短激活码可表示范围: %s", date) }
+	return uint16(days), nil
+}
+
+// ExpiryDate 返回到期日期 (yyyy-mm-dd)
+func (k *ShortKey) ExpiryDate() string {
+	return shortKeyEpoch.AddDate(0, 0, int(k.ExpiryDay)).Format("2006-01-02")
+}
+
+// ExpiresAt 返回到期时刻：到期日期当天北京时间 23:59:59
+func (k *ShortKey) ExpiresAt() time.Time {
+	d := shortKeyEpoch.AddDate(0, 0, int(k.ExpiryDay))
+	return time.Date(d.Year(), d.Month(), d.Day(), 23, 59, 59, 0, cstZone)
+}
+
+// Payload 返回参与签名的 11 字节载荷
+func (k *ShortKey) Payload() []byte {
+	p := make([]byte, ShortKeyPayloadSize)
+	p[0] = k.KeyID
+	copy(p[1:7], k.MachineHash[:])
+	binary.BigEndian.PutUint16(p[7:9], k.ExpiryDay)
+	binary.BigEndian.PutUint16(p[9:11], k.Features)
+	return p
+}
+
+// String 返回带分组和校验位的短激活码
+func (k *ShortKey) String() string {
+	raw := append(k.Payload(), k.Signature...)
+	body := encodeCrockford(raw)
+	body += string(crockfordDigits[luhn32Check(body)])
+	var b strings.Builder
+	for i := 0; i < len(body); i += ShortKeyGroup {
+		if i > 0 { b.WriteByte('-') }
+		end := i + ShortKeyGroup; if end > len(body) { end = len(body) }
+		b.WriteString(body[i:end])
+	}
+	return b.String()
+}
+
+// LooksLikeShortKey 粗略判断字符串是否为短激活码 (用于自动识别格式)
+func LooksLikeShortKey(s string) bool {
+	body := normalizeShortKey(s)
+	if len(body) != ShortKeyChars { return false }
+	for _, c := range body {
+		if strings.IndexRune(crockfordDigits, c) < 0 { return false }
+	}
+	return true
+}
+
+// ParseShortKey 解析短激活码 (不区分大小写，忽略分隔符，I/L 视为 1，O 视为 0)，只校验格式与校验位
+func ParseShortKey(s string) (*ShortKey, error) {
+	body := normalizeShortKey(s)
+	if len(body) != ShortKeyChars { return nil, ErrShortKeyFormat }
+	for _, c := range body {
+		if strings.IndexRune(crockfordDigits, c) < 0 { return nil, ErrShortKeyFormat }
+	}
+	if luhn32Check(body[:ShortKeyChars-1]) != strings.IndexByte(crockfordDigits, body[ShortKeyChars-1]) { return nil, ErrShortKeyChecksum }
+	raw, ok := decodeCrockford(body[:ShortKeyChars-1], ShortKeyPayloadSize+ShortKeySigSize)
+	if !ok { return nil, ErrShortKeyFormat }
+
+	k := &ShortKey{KeyID: raw[0], ExpiryDay: binary.BigEndian.Uint16(raw[7:9]), Features: binary.BigEndian.Uint16(raw[9:11])}
+	copy(k.MachineHash[:], raw[1:7])
+	k.Signature = append([]byte(nil), raw[ShortKeyPayloadSize:]...)
+	return k, nil
+}
+
+// VerifyShortKey 校验短激活码的校验位、签名和机器码；machineID 为空时跳过机器码比对。
+// 只校验真实性，是否过期由调用方根据 ExpiresAt 判断。
+func VerifyShortKey(s, machineID string, pub ed25519.PublicKey) (*ShortKey, error) {
+	k, err := ParseShortKey(s)
+	if err != nil { return nil, err }
+	if k.KeyID != ShortKeyKeyID(pub) { return k, ErrShortKeyKeyID }
+	if !verifyShortSig(pub, k.Payload(), k.Signature) { return k, ErrShortKeySignature }
+	if machineID != "" && k.MachineHash != ShortKeyMachineHash(machineID) { return k, ErrShortKeyMachine }
+	return k, nil
+}
+
+// SignShortKey 生成短激活码 (服务端使用)。签名是确定性的，参数相同时得到相同的激活码
+func SignShortKey(machineID, expiryDate string, features uint16, priv ed25519.PrivateKey) (string, error) {
+	day, err := ShortKeyDay(expiryDate)
+	if err != nil { return "", err }
+	pub := priv.Public().(ed25519.PublicKey)
+	k := &ShortKey{KeyID: ShortKeyKeyID(pub), MachineHash: ShortKeyMachineHash(machineID), ExpiryDay: day, Features: features}
+	payload := k.Payload()
+
+	// 与 Ed25519 相同的私钥标量：a = clamp(SHA-512(seed)[:32])
+	h := sha512.Sum512(priv.Seed())
+	a, err := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	if err != nil { return "", err }
+
+	// 确定性 nonce：r = SHA-512(nonceKey || payload)，nonceKey 与 Ed25519 的 nonce 前缀相互独立，见文件头注释
+	nonceKey := sha512.Sum512(append([]byte(shortKeyDomain+"/nonce"), priv.Seed()...))
+	nh := sha512.New()
+	nh.Write(nonceKey[:32]); nh.Write(payload)
+	r, err := edwards25519.NewScalar().SetUniformBytes(nh.Sum(nil))
+	if err != nil { return "", err }
+	R := new(edwards25519.Point).ScalarBaseMult(r)
+
+	e := shortKeyChallenge(R.Bytes(), pub, payload)
+	s := edwards25519.NewScalar().MultiplyAdd(challengeScalar(e), a, r)
+	k.Signature = append(append([]byte{}, e...), s.Bytes()...)
+	return k.String(), nil
+}
+
+// shortKeyChallenge 计算 Schnorr 挑战值 e = SHA-512(R || A || domain || payload) 的前 16 字节
+func shortKeyChallenge(r, pub, payload []byte) []byte {
+	h := sha512.New()
+	h.Write(r); h.Write(pub); h.Write([]byte(shortKeyDomain)); h.Write(payload)
+	return h.Sum(nil)[:16]
+}
+
+// challengeScalar 把 16 字节挑战值转成标量
+func challengeScalar(e []byte) *edwards25519.Scalar {
+	var buf [32]byte
+	copy(buf[:], e)
+	s, _ := edwards25519.NewScalar().SetCanonicalBytes(buf[:]) // 2^128 < l，必定是规范值
+	return s
+}
+
+// verifyShortSig 校验 (e, s)：R' = s·B - e·A，要求挑战值 H(R' || A || m) 与 e 一致
+func verifyShortSig(pub ed25519.PublicKey, payload, sig []byte) bool {
+	if len(pub) != ed25519.PublicKeySize || len(sig) != ShortKeySigSize { return false }
+	A, err := new(edwards25519.Point).SetBytes(pub)
+	if err != nil { return false }
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(sig[16:])
+	if err != nil { return false }
+	e := sig[:16]
+	negE := edwards25519.NewScalar().Negate(challengeScalar(e))
+	R := new(edwards25519.Point).VarTimeDoubleScalarBaseMult(negE, A, s)
+	return subtle.ConstantTimeCompare(shortKeyChallenge(R.Bytes(), pub, payload), e) == 1
+}
+
+func normalizeShortKey(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(s) {
+		switch c {
+		case '-', ' ', '\t', '\r', '\n':
+			continue
+		case 'I', 'L':
+			c = '1'
+		case 'O':
+			c = '0'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func encodeCrockford(data []byte) string {
+	var b strings.Builder
+	var buf uint32
+	bits := 0
+	for _, v := range data {
+		buf = buf<<8 | uint32(v)
+		bits += 8
+		for bits >= 5 {
+			b.WriteByte(crockfordDigits[(buf>>(bits-5))&31])
+			bits -= 5
+		}
+	}
+	if bits > 0 { b.WriteByte(crockfordDigits[(buf<<(5-bits))&31]) }
+	return b.String()
+}
+
+func decodeCrockford(s string, n int) ([]byte, bool) {
+	out := make([]byte, 0, n)
+	var buf uint32
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		buf = buf<<5 | uint32(strings.IndexByte(crockfordDigits, s[i]))
+		bits += 5
+		if bits >= 8 {
+			out = append(out, byte(buf>>(bits-8)))
+			bits -= 8
+		}
+	}
+	// 末尾补齐的位必须为 0，且字节数必须与预期一致
+	if len(out) != n || buf&(1<<bits-1) != 0 { return nil, false }
+	return out, true
+}
+
+// luhn32Check 计算 Luhn mod 32 校验字符的序号，可发现任意单字符错误和绝大多数相邻字符对调
+func luhn32Check(s string) int {
+	factor, sum := 2, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(crockfordDigits, s[i])
+		addend = addend/32 + addend%32
+		sum += addend
+		if factor == 2 { factor = 1 } else { factor = 2 }
+	}
+	return (32 - sum%32) % 32
+}
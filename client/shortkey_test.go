@@ -0,0 +1,99 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newShortKeyPair(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil { t.Fatal(err) }
+	return pub, priv
+}
+
+func signTestShortKey(t *testing.T, priv ed25519.PrivateKey) string {
+	code, err := SignShortKey("MACHINE-1", "2031-06-30", 0x0105, priv)
+	if err != nil { t.Fatal(err) }
+	return code
+}
+
+// resign 修改解析后的字段并重新编码 (重新计算校验位)，模拟篡改
+func resign(t *testing.T, code string, fn func(k *ShortKey)) string {
+	k, err := ParseShortKey(code)
+	if err != nil { t.Fatal(err) }
+	fn(k)
+	return k.String()
+}
+
+func TestShortKeyRoundTrip(t *testing.T) {
+	pub, priv := newShortKeyPair(t)
+	code := signTestShortKey(t, priv)
+	if len(strings.ReplaceAll(code, "-", "")) != ShortKeyChars || !LooksLikeShortKey(code) { t.Fatalf("格式不符: %s", code) }
+
+	again := signTestShortKey(t, priv)
+	if again != code { t.Fatal("相同参数的签名应当确定") }
+
+	// 不区分大小写、忽略分隔符
+	for _, input := range []string{code, strings.ToLower(code), strings.ReplaceAll(code, "-", " ")} {
+		for _, machineID := range []string{"MACHINE-1", ""} {
+			k, err := VerifyShortKey(input, machineID, pub)
+			if err != nil { t.Fatalf("校验 %q (机器码 %q): %v", input, machineID, err) }
+			if k.ExpiryDate() != "2031-06-30" || k.Features != 0x0105 || k.KeyID != ShortKeyKeyID(pub) { t.Fatalf("字段不符: %+v", k) }
+			if k.String() != code { t.Fatalf("规范写法不符: %s", k.String()) }
+		}
+	}
+	k, _ := ParseShortKey(code)
+	if want := time.Date(2031, 6, 30, 23, 59, 59, 0, time.FixedZone("CST", 8*3600)); !k.ExpiresAt().Equal(want) { t.Fatalf("到期时刻 = %v", k.ExpiresAt()) }
+}
+
+func TestShortKeyTamper(t *testing.T) {
+	pub, priv := newShortKeyPair(t)
+	code := signTestShortKey(t, priv)
+	cases := map[string]func(k *ShortKey){
+		"features":  func(k *ShortKey) { k.Features ^= 0x8000 },
+		"expiry":    func(k *ShortKey) { k.ExpiryDay++ },
+		"machine":   func(k *ShortKey) { k.MachineHash[0] ^= 1 },
+		"challenge": func(k *ShortKey) { k.Signature[0] ^= 1 },
+		"scalar":    func(k *ShortKey) { k.Signature[20] ^= 1 },
+	}
+	for name, fn := range cases {
+		if _, err := VerifyShortKey(resign(t, code, fn), "", pub); !errors.Is(err, ErrShortKeySignature) { t.Errorf("篡改 %s: err = %v", name, err) }
+	}
+}
+
+func TestShortKeyWrongMachine(t *testing.T) {
+	pub, priv := newShortKeyPair(t)
+	if _, err := VerifyShortKey(signTestShortKey(t, priv), "MACHINE-2", pub); !errors.Is(err, ErrShortKeyMachine) { t.Fatalf("err = %v", err) }
+}
+
+func TestShortKeyWrongKey(t *testing.T) {
+	_, priv := newShortKeyPair(t)
+	otherPub, _ := newShortKeyPair(t)
+	code := signTestShortKey(t, priv)
+	if ShortKeyKeyID(otherPub) != ShortKeyKeyID(priv.Public().(ed25519.PublicKey)) {
+		if _, err := VerifyShortKey(code, "", otherPub); !errors.Is(err, ErrShortKeyKeyID) { t.Fatalf("err = %v", err) }
+	}
+	// key ID 一致 (1/256 的概率会碰上) 也必须因签名不符而拒绝
+	forged := resign(t, code, func(k *ShortKey) { k.KeyID = ShortKeyKeyID(otherPub) })
+	if _, err := VerifyShortKey(forged, "", otherPub); !errors.Is(err, ErrShortKeySignature) { t.Fatalf("err = %v", err) }
+}
+
+func TestShortKeyChecksum(t *testing.T) {
+	_, priv := newShortKeyPair(t)
+	body := strings.ReplaceAll(signTestShortKey(t, priv), "-", "")
+	// 任意一个字符输错都必须被校验位发现
+	for i := 0; i < len(body); i++ {
+		for _, d := range crockfordDigits {
+			if byte(d) == body[i] { continue }
+			typo := body[:i] + string(d) + body[i+1:]
+			if _, err := ParseShortKey(typo); !errors.Is(err, ErrShortKeyChecksum) { t.Fatalf("第 %d 位改为 %c: err = %v", i, d, err) }
+		}
+	}
+	for _, bad := range []string{body[:len(body)-1], body + "0", strings.Replace(body, body[:1], "U", 1)} {
+		if _, err := ParseShortKey(bad); !errors.Is(err, ErrShortKeyFormat) { t.Errorf("%q: err = %v", bad, err) }
+	}
+}
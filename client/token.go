@@ -0,0 +1,197 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ================= JWT / PASETO 令牌 =================
+
+const PasetoHeader = "v4.public."
+
+var (
+	ErrTokenFormat    = errors.New("令牌格式错误")
+	ErrTokenSignature = errors.New("令牌签名无效")
+	ErrTokenKey       = errors.New("令牌的签名密钥未知")
+	ErrTokenMachine   = errors.New("令牌与本机机器码不匹配")
+)
+
+// Claims 是 JWT 与 PASETO 共用的授权声明，sub 为机器码，jti 为激活码 ID
+type Claims struct {
+	ID        string `json:"jti,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Features  uint16 `json:"features,omitempty"`
+	Product   string `json:"product,omitempty"`
+}
+
+// PASETO 规范要求 exp/iat 使用 ISO 8601 字符串
+type pasetoClaims struct {
+	ID        string `json:"jti,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	IssuedAt  string `json:"iat"`
+	ExpiresAt string `json:"exp"`
+	Features  uint16 `json:"features,omitempty"`
+	Product   string `json:"product,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// KeyID 返回公钥的 kid：PKIX DER 的 SHA-256 前 8 字节十六进制
+func KeyID(pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil { return "" }
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// LooksLikeJWT 判断是否为 JWS compact 格式
+func LooksLikeJWT(s string) bool { return strings.Count(s, ".") == 2 && strings.HasPrefix(s, "eyJ") }
+
+// LooksLikePaseto 判断是否为 v4.public PASETO
+func LooksLikePaseto(s string) bool { return strings.HasPrefix(s, PasetoHeader) }
+
+// ExpiresTime 返回到期时刻
+func (c *Claims) ExpiresTime() time.Time { return time.Unix(c.ExpiresAt, 0) }
+
+// SignJWT 用 RSA (RS256) 或 Ed25519 (EdDSA) 私钥签发 JWS compact 令牌，header 中带 kid
+func SignJWT(claims Claims, key crypto.Signer) (string, error) {
+	h := jwtHeader{Typ: "JWT", Kid: KeyID(key.Public())}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		h.Alg = "RS256"
+	case ed25519.PrivateKey:
+		h.Alg = "EdDSA"
+	default:
+		return "", errors.New("不支持的 JWT 签名密钥")
+	}
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	signing := b64(hb) + "." + b64(cb)
+
+	var sig []byte
+	var err error
+	if h.Alg == "RS256" {
+		sum := sha256.Sum256([]byte(signing))
+		sig, err = key.Sign(nil, sum[:], crypto.SHA256)
+	} else {
+		sig, err = key.Sign(nil, []byte(signing), crypto.Hash(0))
+	}
+	if err != nil { return "", err }
+	return signing + "." + b64(sig), nil
+}
+
+// VerifyJWT 按 header 中的 kid 从 keys 里挑选公钥校验签名；machineID 为空时跳过机器码比对。
+// 只校验真实性，是否过期由调用方根据 ExpiresTime 判断。
+func VerifyJWT(token, machineID string, keys ...crypto.PublicKey) (*Claims, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { return nil, "", ErrTokenFormat }
+	hb, err1 := unb64(parts[0])
+	cb, err2 := unb64(parts[1])
+	sig, err3 := unb64(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil { return nil, "", ErrTokenFormat }
+
+	var h jwtHeader
+	if err := json.Unmarshal(hb, &h); err != nil { return nil, "", ErrTokenFormat }
+	pub := findKey(h.Kid, keys)
+	if pub == nil { return nil, h.Kid, ErrTokenKey }
+
+	signing := []byte(parts[0] + "." + parts[1])
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(signing)
+		if h.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) != nil { return nil, h.Kid, ErrTokenSignature }
+	case ed25519.PublicKey:
+		if h.Alg != "EdDSA" || !ed25519.Verify(k, signing, sig) { return nil, h.Kid, ErrTokenSignature }
+	default:
+		return nil, h.Kid, ErrTokenKey
+	}
+
+	var c Claims
+	if err := json.Unmarshal(cb, &c); err != nil { return nil, h.Kid, ErrTokenFormat }
+	if machineID != "" && c.Subject != machineID { return &c, h.Kid, ErrTokenMachine }
+	return &c, h.Kid, nil
+}
+
+// SignPaseto 签发 v4.public PASETO 令牌，footer 为 {"kid": "..."}
+func SignPaseto(claims Claims, key ed25519.PrivateKey) (string, error) {
+	m, _ := json.Marshal(pasetoClaims{
+		ID: claims.ID, Issuer: claims.Issuer, Subject: claims.Subject, Features: claims.Features, Product: claims.Product,
+		IssuedAt: time.Unix(claims.IssuedAt, 0).UTC().Format(time.RFC3339), ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339),
+	})
+	f, _ := json.Marshal(map[string]string{"kid": KeyID(key.Public())})
+	sig := ed25519.Sign(key, pae([]byte(PasetoHeader), m, f, nil))
+	return PasetoHeader + b64(append(m, sig...)) + "." + b64(f), nil
+}
+
+// VerifyPaseto 按 footer 中的 kid 挑选 Ed25519 公钥校验 v4.public 令牌
+func VerifyPaseto(token, machineID string, keys ...crypto.PublicKey) (*Claims, string, error) {
+	if !LooksLikePaseto(token) { return nil, "", ErrTokenFormat }
+	parts := strings.Split(token[len(PasetoHeader):], ".")
+	if len(parts) > 2 { return nil, "", ErrTokenFormat }
+	body, err := unb64(parts[0])
+	if err != nil || len(body) < ed25519.SignatureSize { return nil, "", ErrTokenFormat }
+	var footer []byte
+	if len(parts) == 2 {
+		if footer, err = unb64(parts[1]); err != nil { return nil, "", ErrTokenFormat }
+	}
+
+	var f struct{ Kid string `json:"kid"` }
+	json.Unmarshal(footer, &f)
+	pub, ok := findKey(f.Kid, keys).(ed25519.PublicKey)
+	if !ok { return nil, f.Kid, ErrTokenKey }
+
+	m, sig := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(pub, pae([]byte(PasetoHeader), m, footer, nil), sig) { return nil, f.Kid, ErrTokenSignature }
+
+	var pc pasetoClaims
+	if err := json.Unmarshal(m, &pc); err != nil { return nil, f.Kid, ErrTokenFormat }
+	exp, err := time.Parse(time.RFC3339, pc.ExpiresAt)
+	if err != nil { return nil, f.Kid, ErrTokenFormat }
+	iat, _ := time.Parse(time.RFC3339, pc.IssuedAt)
+	c := &Claims{ID: pc.ID, Issuer: pc.Issuer, Subject: pc.Subject, IssuedAt: iat.Unix(), ExpiresAt: exp.Unix(), Features: pc.Features, Product: pc.Product}
+	if machineID != "" && c.Subject != machineID { return c, f.Kid, ErrTokenMachine }
+	return c, f.Kid, nil
+}
+
+// pae 是 PASETO 的 Pre-Authentication Encoding
+func pae(pieces ...[]byte) []byte {
+	le64 := func(n int) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(n)&(1<<63-1))
+		return b
+	}
+	out := le64(len(pieces))
+	for _, p := range pieces {
+		out = append(out, le64(len(p))...)
+		out = append(out, p...)
+	}
+	return out
+}
+
+func findKey(kid string, keys []crypto.PublicKey) crypto.PublicKey {
+	for _, k := range keys {
+		if k != nil && KeyID(k) == kid { return k }
+	}
+	return nil
+}
+
+func b64(b []byte) string            { return base64.RawURLEncoding.EncodeToString(b) }
+func unb64(s string) ([]byte, error) { return base64.RawURLEncoding.DecodeString(s) }
@@ -0,0 +1,158 @@
+// mock-oidc 是一个仅供本地调试单点登录的模拟 OIDC 身份提供方，不做任何真实认证，切勿用于生产。
+//
+//	go run ./cmd/mock-oidc -addr :9000 -groups lic-admins
+//	OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=license-server OIDC_ROLE_MAP=lic-admins=admin ./license-server
+//
+// 授权页可以填写任意用户名和组；加 -auto 时直接以 -user / -groups 签发，便于脚本测试。
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	addr         = flag.String("addr", ":9000", "监听地址")
+	issuer       = flag.String("issuer", "http://localhost:9000", "issuer，需与服务端 OIDC_ISSUER 一致")
+	clientID     = flag.String("client-id", "license-server", "允许的 client_id")
+	clientSecret = flag.String("client-secret", "", "client_secret，为空表示公共客户端")
+	defaultUser  = flag.String("user", "alice", "默认用户名")
+	defaultGroup = flag.String("groups", "lic-admins", "默认组，逗号分隔")
+	auto         = flag.Bool("auto", false, "不显示授权页，直接以默认用户签发")
+)
+
+const keyID = "mock-1"
+
+var signingKey *rsa.PrivateKey
+
+// grant 是已签发但尚未兑换的授权码
+type grant struct {
+	ClientID, RedirectURI, Challenge, Nonce, User string
+	Groups                                        []string
+	Expires                                       time.Time
+}
+
+var grants = struct {
+	sync.Mutex
+	m map[string]grant
+}{m: map[string]grant{}}
+
+func main() {
+	flag.Parse()
+	var err error
+	if signingKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil { log.Fatal(err) }
+
+	http.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, map[string]interface{}{
+			"issuer": *issuer, "authorization_endpoint": *issuer + "/authorize", "token_endpoint": *issuer + "/token", "jwks_uri": *issuer + "/jwks",
+			"response_types_supported": []string{"code"}, "subject_types_supported": []string{"public"}, "id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	http.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		pub := signingKey.PublicKey
+		writeJSON(w, 200, map[string]interface{}{"keys": []map[string]string{{"kty": "RSA", "use": "sig", "alg": "RS256", "kid": keyID, "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}}})
+	})
+	http.HandleFunc("/authorize", handleAuthorize)
+	http.HandleFunc("/token", handleToken)
+
+	log.Printf(">>> 🧪 模拟 OIDC 提供方: %s (监听 %s，client_id=%s)", *issuer, *addr, *clientID)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	q := r.Form
+	if q.Get("response_type") != "code" || q.Get("client_id") != *clientID { http.Error(w, "invalid_request", 400); return }
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" { http.Error(w, "PKCE (S256) required", 400); return }
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" { http.Error(w, "invalid redirect_uri", 400); return }
+
+	user, groups := q.Get("user"), q.Get("groups")
+	if *auto && r.Method == "GET" { user, groups = *defaultUser, *defaultGroup }
+	if r.Method == "GET" && !*auto {
+		hidden := ""
+		for _, k := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			hidden += fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, k, html.EscapeString(q.Get(k)))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset="UTF-8"><title>Mock OIDC</title></head><body style="font-family:sans-serif;max-width:400px;margin:60px auto">
+<h3>🧪 模拟登录</h3><form method="POST">%s<p>用户名 <input name="user" value="%s"></p><p>组 <input name="groups" value="%s"></p><button>登录</button></form></body></html>`,
+			hidden, html.EscapeString(*defaultUser), html.EscapeString(*defaultGroup))
+		return
+	}
+
+	code := randomString()
+	g := grant{ClientID: *clientID, RedirectURI: q.Get("redirect_uri"), Challenge: q.Get("code_challenge"), Nonce: q.Get("nonce"), User: user, Expires: time.Now().Add(time.Minute)}
+	for _, s := range strings.Split(groups, ",") {
+		if s = strings.TrimSpace(s); s != "" { g.Groups = append(g.Groups, s) }
+	}
+	grants.Lock()
+	grants.m[code] = g
+	grants.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	r.ParseForm()
+	id, secret, ok := r.BasicAuth()
+	if !ok { id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret") }
+	if id != *clientID || secret != *clientSecret { writeJSON(w, 401, map[string]string{"error": "invalid_client"}); return }
+
+	code := r.PostFormValue("code")
+	grants.Lock()
+	g, found := grants.m[code]
+	delete(grants.m, code)
+	grants.Unlock()
+	if !found || time.Now().After(g.Expires) || r.PostFormValue("redirect_uri") != g.RedirectURI { writeJSON(w, 400, map[string]string{"error": "invalid_grant"}); return }
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.Challenge { writeJSON(w, 400, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"}); return }
+
+	now := time.Now()
+	idToken, err := signRS256(map[string]interface{}{
+		"iss": *issuer, "sub": "mock|" + g.User, "aud": g.ClientID, "iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(), "nonce": g.Nonce,
+		"preferred_username": g.User, "email": g.User + "@example.com", "groups": g.Groups,
+	})
+	if err != nil { writeJSON(w, 500, map[string]string{"error": "server_error"}); return }
+	writeJSON(w, 200, map[string]interface{}{"access_token": randomString(), "token_type": "Bearer", "expires_in": 300, "id_token": idToken})
+}
+
+func signRS256(claims map[string]interface{}) (string, error) {
+	enc := base64.RawURLEncoding.EncodeToString
+	hb, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	cb, _ := json.Marshal(claims)
+	signing := enc(hb) + "." + enc(cb)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, signingKey, crypto.SHA256, sum[:])
+	if err != nil { return "", err }
+	return signing + "." + enc(sig), nil
+}
+
+func randomString() string { b := make([]byte, 24); rand.Read(b); return base64.RawURLEncoding.EncodeToString(b) }
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"license-server/licensepb"
+)
+
+// ================= gRPC 服务 =================
+//
+// GRPC_PORT 不为空时在该端口上提供 licensepb.LicenseService (定义见 licensepb/license.proto)，并开启反射便于 grpcurl 调试。
+// 除 WatchEvents 外，每个调用都转换成等价的 HTTP 请求交给与 HTTP 服务相同的处理链 (白名单、幂等键、认证、
+// 签发策略、配额、审计、通知)，再把 /api/v2 的响应转换为 protobuf，两边的行为因此不会分叉。
+// 认证信息从 metadata 中读取，见 grpcForwardedMetadata。
+
+var GRPCPort = getEnv("GRPC_PORT", "")
+
+// grpcForwardedMetadata 是转成 HTTP 头的 metadata (gRPC 的 metadata 键均为小写)
+var grpcForwardedMetadata = []string{"authorization", "x-api-key", "x-step-up", "idempotency-key", "forwarded", "x-forwarded-for", "user-agent"}
+
+// watchableActions 是没有 audit.read 权限时 WatchEvents 推送的事件前缀
+var watchableActions = []string{"license.", "machine.", "trash.", "approval."}
+
+type grpcServer struct {
+	licensepb.UnimplementedLicenseServiceServer
+	h    http.Handler
+	stop chan struct{} // 关闭时结束 WatchEvents，使 GracefulStop 不被长连接卡住
+}
+
+// grpcRecorder 保存 HTTP 处理链写出的响应
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *grpcRecorder) Header() http.Header { return rw.header }
+
+func (rw *grpcRecorder) WriteHeader(status int) {
+	if rw.status == 0 { rw.status = status }
+}
+
+func (rw *grpcRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 { rw.status = 200 }
+	return rw.body.Write(b)
+}
+
+// grpcHTTPRequest 用调用的 metadata 和对端地址构造等价的 HTTP 请求
+func grpcHTTPRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	var rd *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil { return nil, status.Error(codes.Internal, err.Error()) }
+		rd = bytes.NewReader(b)
+	} else {
+		rd = bytes.NewReader(nil)
+	}
+	u := path
+	if len(query) > 0 { u += "?" + query.Encode() }
+	r, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil { return nil, status.Error(codes.InvalidArgument, err.Error()) }
+	r.Header.Set("Accept", "application/json")
+	if body != nil { r.Header.Set("Content-Type", "application/json") }
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, k := range grpcForwardedMetadata {
+		for _, v := range md.Get(k) { r.Header.Add(k, v) }
+	}
+	r.RemoteAddr = "0.0.0.0:0"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil { r.RemoteAddr = p.Addr.String() }
+	return r, nil
+}
+
+// grpcCode 把 /api/v2 的错误码映射为 gRPC 状态码
+func grpcCode(code string) codes.Code {
+	switch code {
+	case ErrInvalidRequest:
+		return codes.InvalidArgument
+	case ErrUnauthenticated, ErrStepUpRequired:
+		return codes.Unauthenticated
+	case ErrForbidden:
+		return codes.PermissionDenied
+	case ErrPolicyViolation:
+		return codes.FailedPrecondition
+	case ErrNotFound:
+		return codes.NotFound
+	case ErrMethodNotAllowed:
+		return codes.Unimplemented
+	case ErrConflict:
+		return codes.Aborted
+	case ErrQuotaExceeded, ErrRateLimited:
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
+
+// grpcError 把错误响应 (v2 错误对象或纯文本) 转换为 gRPC 状态
+func grpcError(rw *grpcRecorder) error {
+	var resp v2Response
+	if strings.HasPrefix(rw.header.Get("Content-Type"), "application/json") && json.Unmarshal(rw.body.Bytes(), &resp) == nil && resp.Error != nil {
+		return status.Error(grpcCode(resp.Error.Code), resp.Error.Message)
+	}
+	return status.Error(grpcCode(v2ErrorCode(rw.status, rw.header)), strings.TrimSpace(rw.body.String()))
+}
+
+// call 执行等价的 HTTP 请求，成功时返回响应
+func (s *grpcServer) call(ctx context.Context, method, path string, query url.Values, body interface{}) (*grpcRecorder, error) {
+	r, err := grpcHTTPRequest(ctx, method, path, query, body)
+	if err != nil { return nil, err }
+	rw := &grpcRecorder{header: http.Header{}}
+	s.h.ServeHTTP(rw, r)
+	if rw.status == 0 { rw.status = 200 }
+	if rw.status >= 400 { return nil, grpcError(rw) }
+	return rw, nil
+}
+
+// callV2 调用 /api/v2 接口，把 data 解码到 out，返回 next_cursor
+func (s *grpcServer) callV2(ctx context.Context, method, path string, query url.Values, body, out interface{}) (string, error) {
+	rw, err := s.call(ctx, method, path, query, body)
+	if err != nil { return "", err }
+	var resp struct {
+		Data       json.RawMessage `json:"data"`
+		NextCursor string          `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(rw.body.Bytes(), &resp); err != nil { return "", status.Error(codes.Internal, "无法解析响应: "+err.Error()) }
+	if len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, out); err != nil { return "", status.Error(codes.Internal, "无法解析响应: "+err.Error()) }
+	}
+	return resp.NextCursor, nil
+}
+
+func licenseToPB(rec HistoryRecord) *licensepb.License {
+	return &licensepb.License{Id: rec.ID, GenerateTime: rec.GenerateTime, MachineId: rec.MachineID, ExpiryDate: rec.ExpiryDate, LicenseCode: rec.LicenseCode,
+		Customer: rec.Customer, Product: rec.Product, Format: rec.Format, ExpiresAt: rec.ExpiresAt, KeyId: rec.KeyID, Issuer: rec.Issuer,
+		Status: rec.Status, Source: rec.Source, IssuedBy: rec.IssuedBy, DeletedAt: rec.DeletedAt, ArchivedAt: rec.ArchivedAt}
+}
+
+func listValues(limit int32, sort, cursor string) url.Values {
+	q := url.Values{}
+	if limit != 0 { q.Set("limit", strconv.Itoa(int(limit))) }
+	if sort != "" { q.Set("sort", sort) }
+	if cursor != "" { q.Set("cursor", cursor) }
+	return q
+}
+
+func setQuery(q url.Values, key, value string) {
+	if value != "" { q.Set(key, value) }
+}
+
+func (s *grpcServer) Generate(ctx context.Context, in *licensepb.GenerateRequest) (*licensepb.License, error) {
+	if in.Features > 0xFFFF { return nil, status.Error(codes.InvalidArgument, "features 超出范围 (0-65535)") }
+	req := GenerateRequest{MachineID: in.MachineId, Expiry: in.Expiry, Format: in.Format, Features: uint16(in.Features), Customer: in.Customer, Product: in.Product, Source: in.Source}
+	var rec HistoryRecord
+	if _, err := s.callV2(ctx, "POST", "/api/v2/licenses", nil, req, &rec); err != nil { return nil, err }
+	return licenseToPB(rec), nil
+}
+
+func (s *grpcServer) Verify(ctx context.Context, in *licensepb.VerifyRequest) (*licensepb.VerifyResponse, error) {
+	rw, err := s.call(ctx, "POST", "/api/verify", nil, VerifyRequest{Code: in.Code, MachineID: in.MachineId})
+	if err != nil { return nil, err }
+	var v VerifyResponse
+	if err := json.Unmarshal(rw.body.Bytes(), &v); err != nil { return nil, status.Error(codes.Internal, "无法解析响应: "+err.Error()) }
+	return &licensepb.VerifyResponse{Valid: v.Valid, LicenseId: v.LicenseID, Status: v.Status, Format: v.Format, MachineId: v.MachineID, ExpiryDate: v.ExpiryDate,
+		ExpiresAt: v.ExpiresAt, Expired: v.Expired, Features: uint32(v.Features), Product: v.Product, KeyId: v.KeyID, Error: v.Error}, nil
+}
+
+func (s *grpcServer) Revoke(ctx context.Context, in *licensepb.RevokeRequest) (*licensepb.License, error) {
+	if in.Id == "" { return nil, status.Error(codes.InvalidArgument, "缺少 id") }
+	var rec HistoryRecord
+	if _, err := s.callV2(ctx, "POST", "/api/v2/licenses/"+url.PathEscape(in.Id)+"/revoke", nil, nil, &rec); err != nil { return nil, err }
+	return licenseToPB(rec), nil
+}
+
+func (s *grpcServer) ListLicenses(ctx context.Context, in *licensepb.ListLicensesRequest) (*licensepb.ListLicensesResponse, error) {
+	q := listValues(in.Limit, in.Sort, in.Cursor)
+	for k, v := range map[string]string{"view": in.View, "status": in.Status, "machine_id": in.MachineId, "customer": in.Customer, "product": in.Product,
+		"issued_by": in.IssuedBy, "source": in.Source, "format": in.Format, "q": in.Query, "expires_after": in.ExpiresAfter, "expires_before": in.ExpiresBefore} {
+		setQuery(q, k, v)
+	}
+	var recs []HistoryRecord
+	next, err := s.callV2(ctx, "GET", "/api/v2/licenses", q, nil, &recs)
+	if err != nil { return nil, err }
+	out := &licensepb.ListLicensesResponse{NextCursor: next}
+	for _, rec := range recs { out.Licenses = append(out.Licenses, licenseToPB(rec)) }
+	return out, nil
+}
+
+func (s *grpcServer) ListMachines(ctx context.Context, in *licensepb.ListMachinesRequest) (*licensepb.ListMachinesResponse, error) {
+	q := listValues(in.Limit, in.Sort, in.Cursor)
+	setQuery(q, "view", in.View)
+	setQuery(q, "q", in.Query)
+	var machines []MachineRecord
+	next, err := s.callV2(ctx, "GET", "/api/v2/machines", q, nil, &machines)
+	if err != nil { return nil, err }
+	out := &licensepb.ListMachinesResponse{NextCursor: next}
+	for _, m := range machines { out.Machines = append(out.Machines, &licensepb.Machine{MachineId: m.MachineID, LastSeen: m.LastSeen, DeletedAt: m.DeletedAt}) }
+	return out, nil
+}
+
+// WatchEvents 推送审计日志中的新记录；since_seq 大于 0 时先从日志文件补发
+func (s *grpcServer) WatchEvents(in *licensepb.WatchEventsRequest, stream licensepb.LicenseService_WatchEventsServer) error {
+	ctx := stream.Context()
+	r, err := grpcHTTPRequest(ctx, "GET", licensepb.LicenseService_WatchEvents_FullMethodName, nil, nil)
+	if err != nil { return err }
+	var p *Principal
+	rw := &grpcRecorder{header: http.Header{}}
+	ipFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { p = authorize(w, r, PermHistoryRead, "") })).ServeHTTP(rw, r)
+	if p == nil { return grpcError(rw) }
+
+	all := p.Can(PermAudit)
+	visible := func(e AuditEntry) bool {
+		if !all && !hasAnyPrefix(e.Action, watchableActions) { return false }
+		return len(in.Actions) == 0 || hasAnyPrefix(e.Action, in.Actions)
+	}
+	send := func(e AuditEntry) error {
+		if !visible(e) { return nil }
+		return stream.Send(&licensepb.Event{Seq: e.Seq, Time: e.Time, Actor: e.Actor, Ip: e.IP, Action: e.Action, Target: e.Target, Detail: e.Detail})
+	}
+
+	ch, last, cancel := watchAudit()
+	defer cancel()
+	if in.SinceSeq > 0 && in.SinceSeq < last {
+		err := scanAuditLog(AuditLogPath, func(e AuditEntry) error {
+			if e.Seq <= in.SinceSeq || e.Seq > last { return nil }
+			return send(e)
+		})
+		if err != nil { return status.Error(codes.Internal, "读取审计日志失败: "+err.Error()) }
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.stop:
+			return status.Error(codes.Unavailable, "服务正在关闭")
+		case e, ok := <-ch:
+			if !ok { return status.Error(codes.Aborted, "事件推送跟不上，请用 since_seq 重新订阅") }
+			if err := send(e); err != nil { return err }
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) { return true }
+	}
+	return false
+}
+
+// startGRPC 在 GRPC_PORT 上启动 gRPC 服务，h 为 HTTP 服务的处理链，返回停止函数
+func startGRPC(h http.Handler) func() {
+	if GRPCPort == "" { return func() {} }
+	lis, err := net.Listen("tcp", net.JoinHostPort(ListenHost, GRPCPort))
+	if err != nil { log.Fatalf(">>> ❌ gRPC 监听失败: %v", err) }
+	srv := grpc.NewServer()
+	gs := &grpcServer{h: h, stop: make(chan struct{})}
+	licensepb.RegisterLicenseServiceServer(srv, gs)
+	reflection.Register(srv)
+	go func() {
+		if err := srv.Serve(lis); err != nil { log.Printf(">>> ❌ gRPC 服务异常退出: %v", err) }
+	}()
+	log.Printf(">>> 🚀 gRPC 服务准备监听: %s:%s", ListenHost, GRPCPort)
+	return func() { close(gs.stop); srv.GracefulStop() }
+}
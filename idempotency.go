@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 幂等键 =================
+//
+// 修改类接口 (/api/ 下的 POST/PUT/PATCH/DELETE) 支持 Idempotency-Key 头：
+// 首次请求的响应保存 IDEMPOTENCY_TTL (默认 24 小时)，同一凭据用相同的键和相同的请求重试时
+// 直接返回保存的响应 (带 Idempotent-Replayed: true)，不会重复签发、写记录或推送通知；
+// 相同的键配不同的请求返回 422，首次请求尚未完成时返回 409。
+//
+// 401/403/409/429 和 5xx 不保存：这些结果会随登录状态、权限、配额或服务状态变化，重试时重新执行。
+// 记录保存在文档存储中 (不参与备份和导出)，过期后由定期维护清理。
+
+const (
+	idempotencyDocKind   = "idempotency"
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKey    = 255
+)
+
+var IdempotencyTTL = getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+
+// IdempotencyRecord 是保存的首次响应
+type IdempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"` // 方法、路径和请求体的 SHA-256
+	Method      string      `json:"method"`
+	Path        string      `json:"path"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+	CreatedAt   string      `json:"created_at"`
+	ExpiresAt   string      `json:"expires_at"`
+}
+
+func (rec *IdempotencyRecord) expired(now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, rec.ExpiresAt)
+	return err != nil || !now.Before(t)
+}
+
+// idempotencyInFlight 记录正在处理的键，防止并发重试同时执行
+var idempotencyInFlight = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+func idempotentMethod(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH" || method == "DELETE"
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKey { return false }
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e { return false }
+	}
+	return true
+}
+
+// idempotencyScope 用请求携带的凭据区分不同调用方，避免不同调用方的键互相冲突
+func idempotencyScope(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(apiKeyFromRequest(r) + "\n" + r.Header.Get("Authorization") + "\n"))
+	if c, err := r.Cookie(sessionCookie); err == nil { h.Write([]byte(c.Value)) }
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// storableStatus 判断响应是否应保存以供重放
+func storableStatus(status int) bool {
+	switch status {
+	case 401, 403, 409, 429:
+		return false
+	}
+	return status < 500
+}
+
+func idempotencyError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if strings.HasPrefix(r.URL.Path, "/api/v2/") { writeV2Error(w, status, v2ErrorCode(status, w.Header()), msg); return }
+	http.Error(w, msg, status)
+}
+
+// idempotencyRecorder 把响应同时写给客户端和缓冲区
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rw *idempotencyRecorder) WriteHeader(status int) {
+	if rw.status == 0 { rw.status = status }
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 { rw.status = 200 }
+	if !rw.overflow {
+		if rw.body.Len()+len(b) > MaxBatchBytes { rw.overflow = true; rw.body.Reset() } else { rw.body.Write(b) }
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// idempotent 为带 Idempotency-Key 的修改类请求提供重放保护
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || !idempotentMethod(r.Method) || !strings.HasPrefix(r.URL.Path, "/api/") { next.ServeHTTP(w, r); return }
+		if !validIdempotencyKey(key) { idempotencyError(w, r, 400, "Idempotency-Key 须为不超过 255 个字符的可见 ASCII"); return }
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxBatchBytes+1))
+		if err != nil { idempotencyError(w, r, 400, "读取请求失败"); return }
+		if len(body) > MaxBatchBytes { idempotencyError(w, r, 413, "请求过大"); return }
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256([]byte(idempotencyScope(r) + "\n" + key))
+		id := hex.EncodeToString(sum[:])
+		fingerprint := idempotencyFingerprint(r, body)
+
+		idempotencyInFlight.Lock()
+		if idempotencyInFlight.m[id] {
+			idempotencyInFlight.Unlock()
+			idempotencyError(w, r, 409, "相同 Idempotency-Key 的请求正在处理，请稍后重试")
+			return
+		}
+		var rec IdempotencyRecord
+		found, err := store.GetDoc(idempotencyDocKind, id, &rec)
+		if err != nil { idempotencyInFlight.Unlock(); idempotencyError(w, r, 500, err.Error()); return }
+		if found && !rec.expired(time.Now()) {
+			idempotencyInFlight.Unlock()
+			if rec.Fingerprint != fingerprint { idempotencyError(w, r, 422, "Idempotency-Key 已用于不同的请求"); return }
+			// 凭据可能已被吊销或禁用，重放前重新认证；失败时交给接口本身返回错误
+			var legacy struct{ Token string `json:"token"` }
+			json.Unmarshal(body, &legacy)
+			if authenticate(r, legacy.Token) == nil { next.ServeHTTP(w, r); return }
+			for k, v := range rec.Header { w.Header()[k] = v }
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(rec.Status)
+			w.Write(rec.Body)
+			return
+		}
+		idempotencyInFlight.m[id] = true
+		idempotencyInFlight.Unlock()
+		defer func() {
+			idempotencyInFlight.Lock()
+			delete(idempotencyInFlight.m, id)
+			idempotencyInFlight.Unlock()
+		}()
+
+		rw := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.status == 0 { rw.status = 200 }
+		if !storableStatus(rw.status) { return }
+		if rw.overflow { log.Printf("⚠️ 响应过大，未保存幂等记录: %s %s", r.Method, r.URL.Path); return }
+
+		header := w.Header().Clone()
+		header.Del("Set-Cookie")
+		now := time.Now().UTC()
+		rec = IdempotencyRecord{Fingerprint: fingerprint, Method: r.Method, Path: r.URL.Path, Status: rw.status, Header: header, Body: rw.body.Bytes(),
+			CreatedAt: now.Format(time.RFC3339), ExpiresAt: now.Add(IdempotencyTTL).Format(time.RFC3339)}
+		if err := store.PutDoc(idempotencyDocKind, id, rec); err != nil { log.Printf("❌ 保存幂等记录失败: %v", err) }
+	})
+}
+
+// purgeIdempotency 删除过期的幂等记录，返回删除数量
+func purgeIdempotency(now time.Time) (int, error) {
+	docs, err := store.ListDocs(idempotencyDocKind)
+	if err != nil { return 0, err }
+	n := 0
+	for _, d := range docs {
+		var rec IdempotencyRecord
+		if json.Unmarshal(d.Body, &rec) == nil && !rec.expired(now) { continue }
+		if _, err := store.DeleteDoc(idempotencyDocKind, d.ID); err != nil { return n, err }
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,332 @@
+// 授权服务的 gRPC 接口，与 HTTP 接口共用签发、校验逻辑和认证、策略检查。
+//
+// 认证通过 metadata 传递，与 HTTP 头相同：
+//   x-api-key: lk_<id>_<secret>
+//   authorization: Bearer lk_<id>_<secret> 或 Basic base64(用户名:密码)
+// 修改类调用可带 idempotency-key，语义与 HTTP 的 Idempotency-Key 相同。
+//
+// 修改本文件后重新生成：
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative licensepb/license.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: licensepb/license.proto
+
+package licensepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LicenseService_Generate_FullMethodName     = "/licenseserver.v1.LicenseService/Generate"
+	LicenseService_Verify_FullMethodName       = "/licenseserver.v1.LicenseService/Verify"
+	LicenseService_Revoke_FullMethodName       = "/licenseserver.v1.LicenseService/Revoke"
+	LicenseService_ListLicenses_FullMethodName = "/licenseserver.v1.LicenseService/ListLicenses"
+	LicenseService_ListMachines_FullMethodName = "/licenseserver.v1.LicenseService/ListMachines"
+	LicenseService_WatchEvents_FullMethodName  = "/licenseserver.v1.LicenseService/WatchEvents"
+)
+
+// LicenseServiceClient is the client API for LicenseService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LicenseServiceClient interface {
+	// 签发激活码，参数与 POST /api/v2/licenses 相同。超出签发策略返回 FAILED_PRECONDITION，超出配额返回 RESOURCE_EXHAUSTED
+	Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (*License, error)
+	// 在线校验激活码，与 POST /api/verify 相同，无需认证
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*License, error)
+	ListLicenses(ctx context.Context, in *ListLicensesRequest, opts ...grpc.CallOption) (*ListLicensesResponse, error)
+	ListMachines(ctx context.Context, in *ListMachinesRequest, opts ...grpc.CallOption) (*ListMachinesResponse, error)
+	// 推送审计日志中的事件。需要 history.read 权限，没有 audit.read 权限时只推送激活码、机器码、回收站和审批事件
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type licenseServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLicenseServiceClient(cc grpc.ClientConnInterface) LicenseServiceClient {
+	return &licenseServiceClient{cc}
+}
+
+func (c *licenseServiceClient) Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (*License, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(License)
+	err := c.cc.Invoke(ctx, LicenseService_Generate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, LicenseService_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*License, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(License)
+	err := c.cc.Invoke(ctx, LicenseService_Revoke_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) ListLicenses(ctx context.Context, in *ListLicensesRequest, opts ...grpc.CallOption) (*ListLicensesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListLicensesResponse)
+	err := c.cc.Invoke(ctx, LicenseService_ListLicenses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) ListMachines(ctx context.Context, in *ListMachinesRequest, opts ...grpc.CallOption) (*ListMachinesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMachinesResponse)
+	err := c.cc.Invoke(ctx, LicenseService_ListMachines_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *licenseServiceClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LicenseService_ServiceDesc.Streams[0], LicenseService_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LicenseService_WatchEventsClient = grpc.ServerStreamingClient[Event]
+
+// LicenseServiceServer is the server API for LicenseService service.
+// All implementations must embed UnimplementedLicenseServiceServer
+// for forward compatibility.
+type LicenseServiceServer interface {
+	// 签发激活码，参数与 POST /api/v2/licenses 相同。超出签发策略返回 FAILED_PRECONDITION，超出配额返回 RESOURCE_EXHAUSTED
+	Generate(context.Context, *GenerateRequest) (*License, error)
+	// 在线校验激活码，与 POST /api/verify 相同，无需认证
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	Revoke(context.Context, *RevokeRequest) (*License, error)
+	ListLicenses(context.Context, *ListLicensesRequest) (*ListLicensesResponse, error)
+	ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error)
+	// 推送审计日志中的事件。需要 history.read 权限，没有 audit.read 权限时只推送激活码、机器码、回收站和审批事件
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedLicenseServiceServer()
+}
+
+// UnimplementedLicenseServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLicenseServiceServer struct{}
+
+func (UnimplementedLicenseServiceServer) Generate(context.Context, *GenerateRequest) (*License, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Generate not implemented")
+}
+func (UnimplementedLicenseServiceServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedLicenseServiceServer) Revoke(context.Context, *RevokeRequest) (*License, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Revoke not implemented")
+}
+func (UnimplementedLicenseServiceServer) ListLicenses(context.Context, *ListLicensesRequest) (*ListLicensesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLicenses not implemented")
+}
+func (UnimplementedLicenseServiceServer) ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMachines not implemented")
+}
+func (UnimplementedLicenseServiceServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedLicenseServiceServer) mustEmbedUnimplementedLicenseServiceServer() {}
+func (UnimplementedLicenseServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeLicenseServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LicenseServiceServer will
+// result in compilation errors.
+type UnsafeLicenseServiceServer interface {
+	mustEmbedUnimplementedLicenseServiceServer()
+}
+
+func RegisterLicenseServiceServer(s grpc.ServiceRegistrar, srv LicenseServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLicenseServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LicenseService_ServiceDesc, srv)
+}
+
+func _LicenseService_Generate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).Generate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_Generate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).Generate(ctx, req.(*GenerateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_Revoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).Revoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_Revoke_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).Revoke(ctx, req.(*RevokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_ListLicenses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLicensesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).ListLicenses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_ListLicenses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).ListLicenses(ctx, req.(*ListLicensesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_ListMachines_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMachinesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LicenseServiceServer).ListMachines(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LicenseService_ListMachines_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LicenseServiceServer).ListMachines(ctx, req.(*ListMachinesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LicenseService_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LicenseServiceServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LicenseService_WatchEventsServer = grpc.ServerStreamingServer[Event]
+
+// LicenseService_ServiceDesc is the grpc.ServiceDesc for LicenseService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LicenseService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "licenseserver.v1.LicenseService",
+	HandlerType: (*LicenseServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Generate",
+			Handler:    _LicenseService_Generate_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _LicenseService_Verify_Handler,
+		},
+		{
+			MethodName: "Revoke",
+			Handler:    _LicenseService_Revoke_Handler,
+		},
+		{
+			MethodName: "ListLicenses",
+			Handler:    _LicenseService_ListLicenses_Handler,
+		},
+		{
+			MethodName: "ListMachines",
+			Handler:    _LicenseService_ListMachines_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _LicenseService_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "licensepb/license.proto",
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// ================= 激活码记录 =================
+
+// 激活码状态
+const (
+	LicenseActive  = "active"
+	LicenseRevoked = "revoked"
+)
+
+// 签发来源：网页端 / API
+const (
+	LicenseSourceUI  = "ui"
+	LicenseSourceAPI = "api"
+)
+
+// newLicenseID 生成 ULID：按时间有序，同一毫秒内单调递增
+func newLicenseID() string { return ulid.Make().String() }
+
+// legacyLicenseID 为没有 ID 的旧记录补一个确定性的 ULID：时间取生成时间，随机部分取激活码与位置的哈希，
+// 同一份数据重复迁移得到相同的 ID
+func legacyLicenseID(rec HistoryRecord, n int) string {
+	t := time.Unix(0, 0)
+	for _, layout := range generateTimeLayouts {
+		if v, err := time.ParseInLocation(layout, rec.GenerateTime, time.Local); err == nil { t = v; break }
+	}
+	sum := sha256.Sum256([]byte(rec.LicenseCode + "\x00" + strconv.Itoa(n)))
+	return ulid.MustNew(ulid.Timestamp(t), bytes.NewReader(sum[:])).String()
+}
+
+// fillLegacyFields 为旧记录补齐 ID 和状态，返回是否有改动
+func fillLegacyFields(rec *HistoryRecord, n int) bool {
+	changed := false
+	if rec.ID == "" { rec.ID = legacyLicenseID(*rec, n); changed = true }
+	if rec.Status == "" { rec.Status = LicenseActive; changed = true }
+	return changed
+}
+
+// licenseSource 规范化请求中声明的来源：页面表单为 ui，其余一律视为 api
+func licenseSource(s string) string {
+	if s == LicenseSourceUI { return LicenseSourceUI }
+	return LicenseSourceAPI
+}
+
+// handleGetLicense 按 ID 查询一条记录
+func handleGetLicense(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
+	rec, err := store.GetLicense(r.URL.Query().Get("id"))
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if rec == nil { http.Error(w, "记录不存在", 404); return }
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(rec)
+}
+
+// handleRevokeLicense 吊销激活码：记录保留，在线校验接口返回无效
+func handleRevokeLicense(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p := authorize(w, r, PermRevoke, req.Token)
+	if p == nil { return }
+	if req.ID == "" { http.Error(w, "ID Empty", 400); return }
+	found, err := store.UpdateLicense(req.ID, func(rec *HistoryRecord) { rec.Status = LicenseRevoked })
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if !found { http.Error(w, "记录不存在", 404); return }
+	audit(r, p, "license.revoke", req.ID, "")
+	w.Write([]byte(fmt.Sprintf("✅ 已吊销: %s", req.ID)))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// ================= 授权文件 & 二维码 =================
+
+const (
+	LicBegin = "-----BEGIN LICENSE-----"
+	LicEnd   = "-----END LICENSE-----"
+)
+
+// 生成接口支持的输出方式
+var generateOutputs = map[string]bool{"": true, "text": true, "lic": true, "qr": true, "qr_svg": true, "json": true}
+
+type GenerateResponse struct {
+	LicenseID   string `json:"license_id"`
+	LicenseCode string `json:"license_code"`
+	LicenseFile string `json:"license_file"`
+	FileName    string `json:"file_name"`
+	QRSVG       string `json:"qr_svg"`
+}
+
+// buildLicenseFile 生成 .lic 文件：# 开头的说明头 + BEGIN/END 包裹、按 64 字符折行的激活码。
+// 客户端只需取 BEGIN/END 之间的内容去掉换行即可得到原始激活码。
+func buildLicenseFile(licenseID, machineID, expiry, code string) []byte {
+	var b strings.Builder
+	b.WriteString("# ==================== LICENSE ====================\n")
+	b.WriteString("# License ID : " + licenseID + "\n")
+	b.WriteString("# Machine ID : " + machineID + "\n")
+	b.WriteString("# Expires    : " + expiry + " 23:59:59 (Asia/Shanghai)\n")
+	b.WriteString("# Issued     : " + time.Now().Format("2006-01-02 15:04:05") + "\n")
+	b.WriteString("# 请勿修改本文件内容，任何改动都会导致签名校验失败\n")
+	b.WriteString("# =================================================\n")
+	b.WriteString(LicBegin + "\n")
+	for i := 0; i < len(code); i += 64 {
+		end := i + 64; if end > len(code) { end = len(code) }
+		b.WriteString(code[i:end] + "\n")
+	}
+	b.WriteString(LicEnd + "\n")
+	return []byte(b.String())
+}
+
+func licenseFileName(machineID string) string { return safeFileName(machineID) + ".lic" }
+
+// renderQRPNG 渲染二维码 PNG，size 为图片边长像素
+func renderQRPNG(code string, size int) ([]byte, error) {
+	return qrcode.Encode(code, qrcode.Medium, size)
+}
+
+// renderQRSVG 渲染二维码 SVG，每个模块一个单位，交给浏览器/打印机自由缩放
+func renderQRSVG(code string) ([]byte, error) {
+	q, err := qrcode.New(code, qrcode.Medium)
+	if err != nil { return nil, err }
+	bitmap := q.Bitmap()
+	n := len(bitmap)
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] { continue }
+			start := x
+			for x < len(row) && row[x] { x++ }
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges"><rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`, n, n, path.String())
+	return []byte(svg), nil
+}
+
+// writeGenerateOutput 按请求的 output 返回激活码：纯文本 / .lic 文件 / 二维码 / JSON 汇总
+func writeGenerateOutput(w http.ResponseWriter, r *http.Request, output string, rec HistoryRecord) {
+	machineID, code := rec.MachineID, rec.LicenseCode
+	switch output {
+	case "lic":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, licenseFileName(machineID)))
+		w.Write(buildLicenseFile(rec.ID, machineID, rec.ExpiryDate, code))
+	case "qr":
+		size := 512
+		if s, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && s >= 128 && s <= 2048 { size = s }
+		png, err := renderQRPNG(code, size)
+		if err != nil { http.Error(w, "二维码生成失败: "+err.Error(), 500); return }
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	case "qr_svg":
+		svg, err := renderQRSVG(code)
+		if err != nil { http.Error(w, "二维码生成失败: "+err.Error(), 500); return }
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(svg)
+	case "json":
+		resp := GenerateResponse{LicenseID: rec.ID, LicenseCode: code, LicenseFile: string(buildLicenseFile(rec.ID, machineID, rec.ExpiryDate, code)), FileName: licenseFileName(machineID)}
+		if svg, err := renderQRSVG(code); err == nil { resp.QRSVG = string(svg) }
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(resp)
+	default:
+		w.Write([]byte(code))
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -11,24 +13,29 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+
+	"license-server/client"
 )
 
 // ================= 全局配置 =================
 
 var (
-	SecurityToken = getEnv("SECURITY_TOKEN", "123456")
-	TgBotToken    = os.Getenv("TELEGRAM_BOT_TOKEN")
-	TgChatID      = os.Getenv("TELEGRAM_CHAT_ID")
+	TgBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+	TgChatID   = os.Getenv("TELEGRAM_CHAT_ID")
 )
 
 const PageSize = 20
@@ -36,8 +43,10 @@ const PageSize = 20
 // ================= 数据结构 =================
 
 type LicenseData struct {
+	LicenseID string `json:"license_id,omitempty"`
 	MachineID string `json:"machine_id"`
 	ExpiryUTC int64  `json:"expiry_utc"`
+	Features  uint16 `json:"features,omitempty"`
 }
 
 type License struct {
@@ -46,46 +55,81 @@ type License struct {
 }
 
 type GenerateRequest struct {
-	Token     string `json:"token"`
+	Token     string `json:"token,omitempty"` // 旧版共享 Token，见 SECURITY_TOKEN
 	MachineID string `json:"machine_id"`
 	Expiry    string `json:"expiry"`
+	Output    string `json:"output,omitempty"` // text(默认) / lic / qr / qr_svg / json
+	Format    string `json:"format,omitempty"` // classic / short / jwt / jwt_eddsa / paseto，为空时按产品配置
+	Features  uint16 `json:"features,omitempty"`
+	Customer  string `json:"customer,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Source    string `json:"source,omitempty"` // 网页端传 ui，其余视为 api
+}
+
+// LicenseParams 是签发一个激活码所需的全部参数
+type LicenseParams struct {
+	ID        string // 为空时签发前自动生成
+	MachineID string
+	Expiry    string
+	Features  uint16
+	Format    string
+	Product   string
+	Approved  bool // 已审批通过的申请，不受有效期限制
 }
 
 type DeleteRequest struct {
-	Token     string `json:"token"`
-	No        int    `json:"no,omitempty"`
+	Token     string `json:"token,omitempty"`
+	ID        string `json:"id,omitempty"`
 	MachineID string `json:"machine_id,omitempty"`
+	All       bool   `json:"all,omitempty"` // 仅用于 /api/purge：清空回收站
 }
 
 type HistoryRecord struct {
+	ID           string `json:"id"` // ULID，签发时生成并写入签名内容 (短激活码容量不足，只保存在记录中)
 	GenerateTime string `json:"generate_time"`
 	MachineID    string `json:"machine_id"`
 	ExpiryDate   string `json:"expiry_date"`
 	LicenseCode  string `json:"license_code"`
+	Customer     string `json:"customer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Format       string `json:"format,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"` // 精确到期时间 (RFC 3339, UTC)
+	KeyID        string `json:"key_id,omitempty"`     // 签名密钥 ID
+	Issuer       string `json:"issuer,omitempty"`
+	Status       string `json:"status,omitempty"` // 见 LicenseActive 等常量
+	Source       string `json:"source,omitempty"` // ui / api
+	IssuedBy     string `json:"issued_by,omitempty"` // 签发用户
+	DeletedAt    string `json:"deleted_at,omitempty"`  // 移入回收站的时间 (RFC 3339, UTC)
+	ArchivedAt   string `json:"archived_at,omitempty"` // 归档时间 (RFC 3339, UTC)
 }
 
 type MachineRecord struct {
 	MachineID string `json:"machine_id"`
 	LastSeen  string `json:"last_seen"`
+	DeletedAt string `json:"deleted_at,omitempty"`
 }
 
 // ================= 全局存储 =================
 
-var (
-	historyList []HistoryRecord
-	machineList []MachineRecord
+const (
 	historyFile = "history.json"
 	machineFile = "machines.json"
-	mutex       sync.Mutex
 )
 
 // ================= 主程序入口 =================
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if len(os.Args) > 1 { os.Exit(runCommand(os.Args[1], os.Args[2:])) }
 	log.Println(">>> 正在启动应用...")
 
-	safeLoadData()
+	var err error
+	if store, err = openStore(StoreBackend, StorePath); err != nil { log.Fatalf(">>> ❌ 存储初始化失败: %v", err) }
+	ensureAdmin()
+	loadProducts()
+	stopBackups := startBackupScheduler()
+	stopMaintenance := startMaintenance()
+	stopAudit := startAuditLog()
 
 	if TgBotToken != "" && TgChatID != "" {
 		log.Printf("✅ Telegram 通知已启用 (目标: %s)", TgChatID)
@@ -93,29 +137,51 @@ func main() {
 		log.Println("⚠️ Telegram 配置未找到，将不会推送通知")
 	}
 
-	http.HandleFunc("/", handleIndex)
-	http.HandleFunc("/history", handleHistory)
-	http.HandleFunc("/machines", handleMachines)
-	http.HandleFunc("/setup", handleSetup)
-	http.HandleFunc("/api/generate", handleAPI)
-	http.HandleFunc("/api/delete", handleDeleteHistory)
-	http.HandleFunc("/api/machines/delete", handleDeleteMachine)
+	checkOpenAPI(registerRoutes(http.DefaultServeMux))
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		w.Write([]byte("OK"))
-	})
+	handler := ipFilter(idempotent(http.DefaultServeMux))
+	stopGRPC := startGRPC(handler)
 
 	port := getEnv("PORT", "8080")
-	log.Printf(">>> 🚀 服务准备监听: 0.0.0.0:%s", port)
-	if err := http.ListenAndServe("0.0.0.0:"+port, nil); err != nil {
+	srv := &http.Server{Addr: ListenHost + ":" + port, Handler: handler}
+
+	// 收到 SIGTERM/SIGINT 时停止接收请求，等待进行中的请求结束后再关闭存储，确保日志合并落盘
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		log.Println(">>> 正在关闭服务...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	log.Printf(">>> 🚀 服务准备监听: %s:%s", ListenHost, port)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf(">>> ❌ 致命错误: %v", err)
 	}
+	stopGRPC()
+	stopBackups()
+	stopMaintenance()
+	stopAudit()
+	if err := store.Close(); err != nil { log.Printf(">>> ❌ 关闭存储失败: %v", err) }
+	log.Println(">>> 已退出")
 }
 
 // ================= Telegram 推送逻辑 =================
 
-func sendTelegramNotification(machineID, expiry, tokenUsed string) {
+func sendTelegramNotification(machineID, expiry, issuedBy string) {
+	msg := fmt.Sprintf("🔔 <b>新激活码已生成!</b>\n\n"+
+		"💻 <b>机器码:</b> <code>%s</code>\n"+
+		"📅 <b>到期日:</b> %s\n"+
+		"👤 <b>签发人:</b> %s\n"+
+		"🕒 <b>时间:</b> %s",
+		html.EscapeString(machineID), html.EscapeString(expiry), html.EscapeString(issuedBy), time.Now().Format("2006-01-02 15:04:05"))
+	sendTelegramMessage(msg)
+}
+
+// sendTelegramMessage 异步推送一条 HTML 格式的消息到所有配置的会话
+func sendTelegramMessage(msg string) {
 	if TgBotToken == "" || TgChatID == "" {
 		return
 	}
@@ -123,13 +189,6 @@ func sendTelegramNotification(machineID, expiry, tokenUsed string) {
 	go func() {
 		apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", TgBotToken)
 
-		msg := fmt.Sprintf("🔔 <b>新激活码已生成!</b>\n\n"+
-			"💻 <b>机器码:</b> <code>%s</code>\n"+
-			"📅 <b>到期日:</b> %s\n"+
-			"🔑 <b>使用Token:</b> %s\n"+
-			"🕒 <b>时间:</b> %s",
-			machineID, expiry, tokenUsed, time.Now().Format("2006-01-02 15:04:05"))
-
 		// 支持逗号分隔多个ID
 		ids := strings.Split(TgChatID, ",")
 
@@ -152,9 +211,86 @@ func sendTelegramNotification(machineID, expiry, tokenUsed string) {
 
 // ================= 核心逻辑 =================
 
-func generateLicenseCore(machineID, expiryStr string) (string, error) {
-	if machineID == "" || expiryStr == "" { return "", fmt.Errorf("机器码或日期为空") }
+// generateLicenseCore 校验参数并签发，返回待保存的记录 (Customer、Source 由调用方填写)
+func generateLicenseCore(p LicenseParams) (HistoryRecord, error) {
+	if p.MachineID == "" || p.Expiry == "" { return HistoryRecord{}, fmt.Errorf("机器码或日期为空") }
+	if !machineIDPattern.MatchString(p.MachineID) { return HistoryRecord{}, errors.New(machineIDRule) }
 
+	parse := parseExpiry
+	if p.Approved { parse = expiryTime }
+	expiryUTC, err := parse(p.Expiry)
+	if err != nil { return HistoryRecord{}, err }
+
+	return (&licenseSigner{}).issue(p, expiryUTC)
+}
+
+// licenseSigner 按需加载并缓存各格式所需的私钥，批量签发时复用
+type licenseSigner struct {
+	rsaKey *rsa.PrivateKey
+	edKey  ed25519.PrivateKey
+}
+
+func (s *licenseSigner) rsa() (*rsa.PrivateKey, error) {
+	if s.rsaKey == nil {
+		k, err := loadPrivateKey()
+		if err != nil { return nil, err }
+		s.rsaKey = k
+	}
+	return s.rsaKey, nil
+}
+
+func (s *licenseSigner) ed() (ed25519.PrivateKey, error) {
+	if s.edKey == nil {
+		k, err := loadShortKeySigner()
+		if err != nil { return nil, err }
+		s.edKey = k
+	}
+	return s.edKey, nil
+}
+
+// issue 分配激活码 ID (如未指定)、签发并生成记录
+func (s *licenseSigner) issue(p LicenseParams, expiryUTC int64) (HistoryRecord, error) {
+	if p.ID == "" { p.ID = newLicenseID() }
+	code, keyID, err := s.sign(p, expiryUTC)
+	if err != nil { return HistoryRecord{}, err }
+	return HistoryRecord{
+		ID: p.ID, MachineID: p.MachineID, ExpiryDate: p.Expiry, LicenseCode: code, Product: p.Product, Format: p.Format,
+		ExpiresAt: time.Unix(expiryUTC, 0).UTC().Format(time.RFC3339), KeyID: keyID, Issuer: TokenIssuer, Status: LicenseActive,
+	}, nil
+}
+
+// sign 按 p.Format 签发：classic / short 使用自有格式，jwt / jwt_eddsa / paseto 输出标准令牌。同时返回签名密钥 ID。
+func (s *licenseSigner) sign(p LicenseParams, expiryUTC int64) (string, string, error) {
+	var code string
+	switch p.Format {
+	case "", "classic", "jwt":
+		key, err := s.rsa()
+		if err != nil { return "", "", err }
+		if p.Format == "jwt" {
+			code, err = client.SignJWT(licenseClaims(p, expiryUTC), key)
+		} else {
+			code, err = signLicense(key, p.ID, p.MachineID, expiryUTC, p.Features)
+		}
+		return code, client.KeyID(&key.PublicKey), err
+	case "short", "jwt_eddsa", "paseto":
+		key, err := s.ed()
+		if err != nil { return "", "", err }
+		switch p.Format {
+		case "jwt_eddsa":
+			code, err = client.SignJWT(licenseClaims(p, expiryUTC), key)
+		case "paseto":
+			code, err = client.SignPaseto(licenseClaims(p, expiryUTC), key)
+		default:
+			code, err = client.SignShortKey(p.MachineID, p.Expiry, p.Features, key)
+			return code, fmt.Sprintf("%02x", client.ShortKeyKeyID(key.Public().(ed25519.PublicKey))), err
+		}
+		return code, client.KeyID(key.Public()), err
+	}
+	return "", "", fmt.Errorf("不支持的激活码格式: %s", p.Format)
+}
+
+// loadPrivateKey 读取签名私钥：优先本地 private.pem，其次环境变量 PRIVATE_KEY
+func loadPrivateKey() (*rsa.PrivateKey, error) {
 	var rawKey []byte
 	var source string
 
@@ -165,13 +301,13 @@ func generateLicenseCore(machineID, expiryStr string) (string, error) {
 		if envKey != "" { rawKey = []byte(envKey); source = "env" }
 	}
 
-	if len(rawKey) == 0 { return "", fmt.Errorf("❌ 未找到私钥") }
+	if len(rawKey) == 0 { return nil, fmt.Errorf("❌ 未找到私钥") }
 
 	var block *pem.Block
 	block, _ = pem.Decode(rawKey)
 
 	if block == nil {
-		if source == "file" { return "", fmt.Errorf("本地文件格式错误") }
+		if source == "file" { return nil, fmt.Errorf("本地文件格式错误") }
 		cleanKey := string(rawKey)
 		cleanKey = strings.Map(func(r rune) rune {
 			if r == '-' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '+' || r == '/' || r == '=' { return r }
@@ -191,31 +327,49 @@ func generateLicenseCore(machineID, expiryStr string) (string, error) {
 		block, _ = pem.Decode([]byte(builder.String()))
 	}
 
-	if block == nil { return "", fmt.Errorf("私钥解析失败") }
+	if block == nil { return nil, fmt.Errorf("私钥解析失败") }
 
-	var privKey *rsa.PrivateKey
-	var err error
-	privKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
 		if pkcs8, err2 := x509.ParsePKCS8PrivateKey(block.Bytes); err2 == nil {
-			if k, ok := pkcs8.(*rsa.PrivateKey); ok { privKey = k } else { return "", fmt.Errorf("不是 RSA 私钥") }
-		} else { return "", fmt.Errorf("私钥格式错误: %v", err) }
+			if k, ok := pkcs8.(*rsa.PrivateKey); ok { privKey = k } else { return nil, fmt.Errorf("不是 RSA 私钥") }
+		} else { return nil, fmt.Errorf("私钥格式错误: %v", err) }
 	}
+	return privKey, nil
+}
+
+var errExpiryTooLong = errors.New("❌ 有效期限制：不能超过1个月")
 
+func expiryLocation() *time.Location {
 	loc, err := time.LoadLocation("Asia/Shanghai")
 	if err != nil { loc = time.FixedZone("CST", 8*3600) }
+	return loc
+}
+
+// expiryTime 解析到期日期 (北京时间)，返回到期当天最后一秒的 UTC 时间戳，不检查有效期限制
+func expiryTime(expiryStr string) (int64, error) {
+	t, err := time.ParseInLocation("2006-01-02", expiryStr, expiryLocation())
+	if err != nil { return 0, fmt.Errorf("日期格式错误: %v", err) }
+	return t.Add(24*time.Hour - time.Second).UTC().Unix(), nil
+}
 
-	t, err := time.ParseInLocation("2006-01-02", expiryStr, loc)
-	if err != nil { return "", fmt.Errorf("日期格式错误: %v", err) }
+// exceedsMaxExpiry 判断到期时间是否超出全局有效期限制 (1 个月)
+func exceedsMaxExpiry(expiryUTC int64) bool {
+	day := time.Unix(expiryUTC, 0).Add(-(24*time.Hour - time.Second))
+	maxAllowed := time.Now().In(expiryLocation()).AddDate(0, 1, 0)
+	return day.After(maxAllowed.Add(24 * time.Hour))
+}
 
-	now := time.Now().In(loc)
-	maxAllowed := now.AddDate(0, 1, 0)
-	if t.After(maxAllowed.Add(24 * time.Hour)) {
-		return "", fmt.Errorf("❌ 有效期限制：不能超过1个月")
-	}
+// parseExpiry 解析到期日期 (北京时间) 并检查有效期限制，返回到期当天最后一秒的 UTC 时间戳
+func parseExpiry(expiryStr string) (int64, error) {
+	expiryUTC, err := expiryTime(expiryStr)
+	if err != nil { return 0, err }
+	if exceedsMaxExpiry(expiryUTC) { return 0, errExpiryTooLong }
+	return expiryUTC, nil
+}
 
-	expiryUTC := t.Add(24*time.Hour - time.Second).UTC().Unix()
-	licenseData := LicenseData{MachineID: machineID, ExpiryUTC: expiryUTC}
+func signLicense(privKey *rsa.PrivateKey, licenseID, machineID string, expiryUTC int64, features uint16) (string, error) {
+	licenseData := LicenseData{LicenseID: licenseID, MachineID: machineID, ExpiryUTC: expiryUTC, Features: features}
 	dataJSON, _ := json.Marshal(licenseData)
 	hasher := sha256.New(); hasher.Write(dataJSON); hashed := hasher.Sum(nil)
 	signature, err := rsa.SignPKCS1v15(rand.Reader, privKey, crypto.SHA256, hashed)
@@ -232,7 +386,8 @@ func generateLicenseCore(machineID, expiryStr string) (string, error) {
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" { http.NotFound(w, r); return }
-	html := `<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>License Keygen</title>
+	if authorize(w, r, "", "") == nil { return }
+	html := `<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>License Keygen</title>
 	<style>
 		body{font-family:-apple-system,sans-serif;max-width:600px;margin:20px auto;padding:20px;background:#f5f5f7}
 		.card{background:white;padding:30px;border-radius:12px;box-shadow:0 4px 12px rgba(0,0,0,0.1)}
@@ -240,18 +395,34 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		button{width:100%;padding:12px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}
 		button:hover{background:#005bb5}
 		#res{margin-top:20px;word-break:break-all;padding:10px;background:#eee;border-radius:6px;display:none;font-family:monospace}
+		#out{display:none;margin-top:10px;text-align:center}
+		#out img{width:220px;height:220px;border:1px solid #eee;border-radius:6px}
+		#out button{width:auto;padding:8px 16px;margin:8px 4px 0;background:#fff;color:#0071e3;border:1px solid #0071e3}
 		.link-box{margin-bottom:15px;text-align:right;font-size:12px}
 		a{color:#666;text-decoration:none;margin-left:10px} a:hover{color:#0071e3}
 		.tags { display: flex; gap: 8px; margin-bottom: 5px; }
 		.tag { padding: 4px 10px; border-radius: 15px; background: #eef6ff; color: #0071e3; font-size: 12px; cursor: pointer; border: 1px solid #dcebfa; user-select: none; transition: all 0.2s; }
 		.tag:hover { background: #0071e3; color: white; }
+		.batch{margin-top:25px;padding-top:15px;border-top:1px dashed #ddd}
+		.batch .tip{font-size:12px;color:#888;margin:0 0 10px}
+		select{width:100%;padding:10px;margin:5px 0 15px;border:1px solid #ccc;border-radius:6px;background:white}
+		#bres{margin-top:10px;font-size:13px}
 	</style>
 	</head><body><div class="card"><h2>🔐 激活码生成器</h2>
 	<div class="link-box">
-		<a href="#" onclick="goPage('/machines');return false">💻 机器管理</a>
-		<a href="#" onclick="goPage('/history');return false">📜 生成记录</a>
+		<span id="me" style="color:#888"></span>
+		<a href="/machines">💻 机器管理</a>
+		<a href="/history">📜 生成记录</a>
+		<a href="/approvals">✅ 签发审批</a>
+		<a href="/quotas">📊 签发配额</a>
+		<a href="/users" id="users" style="display:none">👥 用户管理</a>
+		<a href="/keys" id="keys" style="display:none">🔑 API Key</a>
+		<a href="/security" id="security" style="display:none">🚫 访问控制</a>
+		<a href="/audit" id="audit" style="display:none">📝 审计日志</a>
+		<a href="#" onclick="changePwd();return false">🔑 修改密码</a>
+		<a href="/2fa">🛡️ 两步验证</a>
+		<a href="#" onclick="fetch('/logout',{method:'POST'}).then(()=>location='/login');return false">🚪 退出</a>
 	</div>
-	<label>鉴权Token</label><input type="password" id="token" placeholder="默认为 123456">
 	<label>机器码</label><input type="text" id="mid" placeholder="客户机器码">
 	<label>到期日期</label>
 	<div class="tags">
@@ -261,34 +432,78 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		<div class="tag" onclick="addMonth(1)">+1月</div>
 	</div>
 	<input type="date" id="date">
-	<button onclick="gen()" id="btn">生成激活码</button><div id="res" onclick="copy(this)"></div></div>
+	<label>激活码格式</label>
+	<select id="fmt"><option value="classic">标准 (gzip+base64)</option><option value="short">短激活码 (可手工输入)</option><option value="jwt">JWT (RS256)</option><option value="jwt_eddsa">JWT (EdDSA)</option><option value="paseto">PASETO v4.public</option></select>
+	<button onclick="gen()" id="btn">生成激活码</button><div id="res" onclick="copy(this)"></div>
+	<div id="out"><img id="qr" alt="QR"><br><button onclick="dl()">⬇️ 下载 .lic 文件</button><button onclick="dlQR()">⬇️ 下载二维码</button></div>
+	<div class="batch"><h3>📦 批量生成</h3>
+	<p class="tip">上传 CSV (列: machine_id, expiry, customer, product；表头可选) 或 JSON 数组，全部校验后统一生成，失败行会在结果中注明原因。</p>
+	<input type="file" id="bfile" accept=".csv,.json,text/csv,application/json">
+	<select id="bfmt"><option value="csv">下载 CSV</option><option value="zip">下载 ZIP (含每台机器的 .lic 文件)</option></select>
+	<button onclick="batch()" id="bbtn">批量生成</button><div id="bres"></div></div></div>
 	<script>
 	document.getElementById('date').valueAsDate = new Date();
 	function addDate(days) { const d = new Date(); d.setDate(d.getDate() + days); document.getElementById('date').valueAsDate = d; }
 	function addMonth(months) { const d = new Date(); d.setMonth(d.getMonth() + months); document.getElementById('date').valueAsDate = d; }
-	if(localStorage.getItem('lt')) document.getElementById('token').value = localStorage.getItem('lt');
-	function goPage(path){var t=document.getElementById('token').value;if(!t)return alert('请输入Token');location.href=path+'?token='+t}
+	fetch('/api/me').then(r=>r.json()).then(u=>{document.getElementById('me').innerText='👤 '+u.username+' ('+u.role+')';if(u.role=='admin'){document.getElementById('users').style.display='';document.getElementById('keys').style.display='';document.getElementById('security').style.display='';document.getElementById('audit').style.display=''}});
+	async function changePwd(){
+		var o=prompt('当前密码');if(!o)return;var n=prompt('新密码');if(!n)return;
+		var r=await fetch('/api/password',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({old_password:o,new_password:n})});
+		alert(await r.text());
+	}
 	async function gen(){
-		var t=document.getElementById('token').value, m=document.getElementById('mid').value, d=document.getElementById('date').value;
-		if(!t||!m||!d)return alert('请填写完整');
-		localStorage.setItem('lt',t);
-		var btn=document.getElementById('btn'), res=document.getElementById('res');
-		btn.disabled=true; btn.innerText="生成中...";
+		var m=document.getElementById('mid').value, d=document.getElementById('date').value;
+		if(!m||!d)return alert('请填写完整');
+		var btn=document.getElementById('btn'), res=document.getElementById('res'), out=document.getElementById('out');
+		btn.disabled=true; btn.innerText="生成中..."; out.style.display='none';
 		try{
-			var r = await fetch('/api/generate',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify({token:t,machine_id:m,expiry:d})});
-			var txt = await r.text();
+			var body={machine_id:m,expiry:d,format:document.getElementById('fmt').value,output:'json',source:'ui'};
+			var r = await fetch('/api/generate',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify(body)});
 			res.style.display='block';
-			if(r.ok){res.style.color='green';res.innerText=txt;}else{res.style.color='red';res.innerText="错误: "+txt;}
+			if(r.ok){
+				last=await r.json(); res.style.color='green'; res.innerText=last.license_code;
+				document.getElementById('qr').src='data:image/svg+xml;charset=utf-8,'+encodeURIComponent(last.qr_svg); out.style.display='block';
+			}else if(r.headers.get('X-Approval-Required')){
+				var msg=await r.text(); res.style.color='red'; res.innerText="错误: "+msg;
+				var reason=prompt(msg+'\n\n如需提交审批申请，请填写理由：');
+				if(reason){body.reason=reason;var s=await fetch('/api/approvals/submit',{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify(body)});
+				if(s.ok){res.style.color='#c77700';res.innerText='已提交审批申请，批准后可在「签发审批」页面查看激活码'}else{res.innerText="错误: "+await s.text()}}
+			}else{res.style.color='red';res.innerText="错误: "+await r.text();}
 		}catch(e){alert(e)}
 		btn.disabled=false; btn.innerText="生成激活码";
 	}
+	async function batch(){
+		var f=document.getElementById('bfile').files[0];
+		if(!f)return alert('请选择文件');
+		var btn=document.getElementById('bbtn'), res=document.getElementById('bres');
+		var fd=new FormData(); fd.append('format',document.getElementById('bfmt').value); fd.append('license_format',document.getElementById('fmt').value); fd.append('source','ui'); fd.append('file',f);
+		btn.disabled=true; btn.innerText="生成中...";
+		try{
+			var r = await fetch('/api/generate/batch',{method:'POST',body:fd});
+			if(r.ok){
+				var blob=await r.blob(), name=(r.headers.get('Content-Disposition')||'').split('filename="')[1];
+				var a=document.createElement('a'); a.href=URL.createObjectURL(blob); a.download=name?name.replace('"',''):'licenses'; a.click();
+				var total=+r.headers.get('X-Batch-Total'), failed=+r.headers.get('X-Batch-Failed');
+				res.style.color=failed?'#c77700':'green'; res.innerText='共 '+total+' 行，成功 '+(total-failed)+' 行，失败 '+failed+' 行';
+			}else{res.style.color='red';res.innerText="错误: "+await r.text();}
+		}catch(e){alert(e)}
+		btn.disabled=false; btn.innerText="批量生成";
+	}
+	var last=null;
+	function save(blob,name){var a=document.createElement('a');a.href=URL.createObjectURL(blob);a.download=name;a.click()}
+	function dl(){if(last)save(new Blob([last.license_file],{type:'application/octet-stream'}),last.file_name)}
+	function dlQR(){if(last)save(new Blob([last.qr_svg],{type:'image/svg+xml'}),last.file_name.replace(/\.lic$/,'.svg'))}
 	function copy(e){navigator.clipboard.writeText(e.innerText).then(()=>alert('已复制'))}
 	</script></body></html>`
 	w.Write([]byte(html))
 }
 
 func handleSetup(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermSetup, "")
+	if p == nil { return }
 	if r.Method == "POST" {
+		if !stepUp(w, r, p) { return }
+		audit(r, p, "setup.keys", "", "生成新的签名密钥")
 		priv, _ := rsa.GenerateKey(rand.Reader, 2048)
 		privBytes := x509.MarshalPKCS1PrivateKey(priv)
 		pubBytes, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
@@ -296,77 +511,89 @@ func handleSetup(w http.ResponseWriter, r *http.Request) {
 		pubPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
 		os.WriteFile("private.pem", privPem, 0600)
 		os.WriteFile("public.pem", pubPem, 0644)
-		json.NewEncoder(w).Encode(map[string]string{"private_key": string(privPem), "public_key": string(pubPem)})
+
+		// 短激活码使用的 Ed25519 密钥
+		edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+		edPrivBytes, _ := x509.MarshalPKCS8PrivateKey(edPriv)
+		edPubBytes, _ := x509.MarshalPKIXPublicKey(edPub)
+		edPrivPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edPrivBytes})
+		edPubPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edPubBytes})
+		os.WriteFile("ed25519.pem", edPrivPem, 0600)
+		os.WriteFile("ed25519_public.pem", edPubPem, 0644)
+
+		json.NewEncoder(w).Encode(map[string]string{"private_key": string(privPem), "public_key": string(pubPem), "ed25519_private_key": string(edPrivPem), "ed25519_public_key": string(edPubPem)})
 		return
 	}
-	html := `<!DOCTYPE html><html><body style="font-family:sans-serif;padding:20px;max-width:800px;margin:0 auto"><h2>🛠️ 密钥工具</h2><button onclick="gen()" style="padding:10px 20px;background:red;color:white;border:none;border-radius:5px;cursor:pointer">生成新密钥</button><div id="box" style="display:none;margin-top:20px"><h3>私钥</h3><textarea id="priv" style="width:100%;height:150px" onclick="this.select()"></textarea><h3>公钥</h3><textarea id="pub" style="width:100%;height:150px" onclick="this.select()"></textarea></div><script>async function gen(){if(!confirm('确定生成吗？'))return;var res=await fetch('/setup',{method:'POST'});var d=await res.json();document.getElementById('box').style.display='block';document.getElementById('priv').value=d.private_key;document.getElementById('pub').value=d.public_key;}</script></body></html>`
+	html := `<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`</head><body style="font-family:sans-serif;padding:20px;max-width:800px;margin:0 auto"><h2>🛠️ 密钥工具</h2><button onclick="gen()" style="padding:10px 20px;background:red;color:white;border:none;border-radius:5px;cursor:pointer">生成新密钥</button><div id="box" style="display:none;margin-top:20px"><h3>私钥</h3><textarea id="priv" style="width:100%;height:150px" onclick="this.select()"></textarea><h3>公钥</h3><textarea id="pub" style="width:100%;height:150px" onclick="this.select()"></textarea><h3>短激活码私钥 (Ed25519)</h3><textarea id="edpriv" style="width:100%;height:80px" onclick="this.select()"></textarea><h3>短激活码公钥 (Ed25519)</h3><textarea id="edpub" style="width:100%;height:80px" onclick="this.select()"></textarea></div><script>async function gen(){if(!confirm('确定生成吗？'))return;var res=await fetch('/setup',{method:'POST'});var d=await res.json();document.getElementById('box').style.display='block';document.getElementById('priv').value=d.private_key;document.getElementById('pub').value=d.public_key;document.getElementById('edpriv').value=d.ed25519_private_key;document.getElementById('edpub').value=d.ed25519_public_key;}</script></body></html>`
 	w.Write([]byte(html))
 }
 
 func handleMachines(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if token != SecurityToken { http.Error(w, "Forbidden", 403); return }
+	if authorize(w, r, PermMachinesRead, "") == nil { return }
 
-	mutex.Lock()
+	allMachines, err := store.ListMachines()
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	var machineList []MachineRecord
+	for _, m := range allMachines {
+		if m.DeletedAt == "" { machineList = append(machineList, m) }
+	}
 	rowsHtml := ""
 	count := 0
 	for i := len(machineList) - 1; i >= 0; i-- {
 		count++
 		rec := machineList[i]
-		rowsHtml += fmt.Sprintf(`<tr><td style="text-align:center;color:#888">%d</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td style="text-align:center"><button onclick="copyText('%s')" class="copy-btn">复制</button><button onclick="delMachine('%s')" class="del-btn">删除</button></td></tr>`, count, rec.MachineID, rec.LastSeen, rec.MachineID, rec.MachineID)
+		mid := html.EscapeString(rec.MachineID)
+		rowsHtml += fmt.Sprintf(`<tr><td style="text-align:center;color:#888">%d</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td style="text-align:center"><button data-mid="%s" onclick="copyText(this.dataset.mid)" class="copy-btn">复制</button><button data-mid="%s" onclick="delMachine(this.dataset.mid)" class="del-btn">删除</button></td></tr>`, count, mid, html.EscapeString(rec.LastSeen), mid, mid)
 	}
-	mutex.Unlock()
 
-	html := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>机器码管理</title>
+	body := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>机器码管理</title>
 	<style>body{font-family:-apple-system,sans-serif;max-width:900px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}tr:hover{background:#f9f9f9}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .del-btn:hover{background:#ff3b30;color:white}.copy-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px;margin-right:6px} .copy-btn:hover{background:#0071e3;color:white}</style></head><body>
-	<div class="card"><h2 style="display:flex;justify-content:space-between">💻 机器管理 (%d) <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2><table><thead><tr><th style="width:50px;text-align:center">#</th><th>机器码</th><th>最后生成时间</th><th style="width:110px;text-align:center">操作</th></tr></thead><tbody>%s</tbody></table></div>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">💻 机器管理 (%d) <span><a href="/trash" style="font-size:14px;color:#0071e3;text-decoration:none;margin-right:12px">回收站</a><a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></span></h2><table><thead><tr><th style="width:50px;text-align:center">#</th><th>机器码</th><th>最后生成时间</th><th style="width:110px;text-align:center">操作</th></tr></thead><tbody>%s</tbody></table></div>
 	<script>function copyText(t){navigator.clipboard.writeText(t).then(()=>alert("已复制"))}
-	async function delMachine(mid){if(!confirm('确定要删除该机器码记录吗？'))return;try {let res = await fetch('/api/machines/delete', {method: 'POST', headers: {'Content-Type': 'application/json'},body: JSON.stringify({token: '%s', machine_id: mid})});if(res.ok) location.reload(); else alert(await res.text());} catch(e){alert(e)}}</script></body></html>`, len(machineList), rowsHtml, token)
+	async function delMachine(mid){if(!confirm('确定要删除该机器码记录吗？(可在回收站恢复)'))return;try {let res = await fetch('/api/machines/delete', {method: 'POST', headers: {'Content-Type': 'application/json'},body: JSON.stringify({machine_id: mid})});if(res.ok) location.reload(); else alert(await res.text());} catch(e){alert(e)}}</script></body></html>`, len(machineList), rowsHtml)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(html))
+	w.Write([]byte(body))
 }
 
 func handleHistory(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if token != SecurityToken { http.Error(w, "Forbidden", 403); return }
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
 
 	pageStr := r.URL.Query().Get("page")
 	page := 1
 	if p, err := strconv.Atoi(pageStr); err == nil && p > 0 { page = p }
+	view, q := r.URL.Query().Get("view"), strings.TrimSpace(r.URL.Query().Get("q"))
+	if view != ViewArchive { view = ViewActive }
+	listURL := fmt.Sprintf("/history?view=%s&q=%s", view, url.QueryEscape(q))
 
-	mutex.Lock()
-	total := len(historyList)
 	startIndex := (page - 1) * PageSize
-	endIndex := startIndex + PageSize
-	if endIndex > total { endIndex = total }
-
-	var displayRows []HistoryRecord
-	for i := startIndex; i < endIndex; i++ {
-		realIndex := total - 1 - i
-		if realIndex >= 0 { displayRows = append(displayRows, historyList[realIndex]) }
-	}
-	mutex.Unlock()
+	displayRows, total, err := store.LicensePage(LicenseFilter{View: view, Query: q}, startIndex, PageSize)
+	if err != nil { http.Error(w, err.Error(), 500); return }
 
 	rowsHtml := ""
 	for i, rec := range displayRows {
 		rowNum := startIndex + i + 1
 		short := rec.LicenseCode
 		if len(short) > 10 { short = short[:10] + "..." }
-		rowsHtml += fmt.Sprintf(`<tr><td style="text-align:center;color:#888;font-weight:bold">%d</td><td>%s</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td onclick="navigator.clipboard.writeText('%s').then(()=>alert('已复制'))" style="cursor:pointer;color:blue" title="点击复制">%s</td></tr>`, rowNum, rec.GenerateTime, rec.MachineID, rec.ExpiryDate, rec.LicenseCode, short)
+		id := html.EscapeString(rec.ID)
+		actions := fmt.Sprintf(`<button data-id="%s" onclick="licAction('/api/revoke',this.dataset.id,'确定要吊销该激活码吗？')" class="del-btn">吊销</button>`, id)
+		if rec.Status == LicenseRevoked { actions = `<span style="color:#c00">已吊销</span>` }
+		actions += fmt.Sprintf(`<button data-id="%s" onclick="licAction('/api/delete',this.dataset.id,'确定要删除该记录吗？(可在回收站恢复)')" class="del-btn">删除</button>`, id)
+		rowsHtml += fmt.Sprintf(`<tr><td style="text-align:center;color:#888;font-weight:bold">%d</td><td>%s</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td data-code="%s" onclick="navigator.clipboard.writeText(this.dataset.code).then(()=>alert('已复制'))" style="cursor:pointer;color:blue" title="点击复制">%s</td><td>%s</td><td style="font-family:monospace;font-size:12px;color:#888" title="%s">%s</td><td style="text-align:center;white-space:nowrap">%s</td></tr>`, rowNum, html.EscapeString(rec.GenerateTime), html.EscapeString(rec.MachineID), html.EscapeString(rec.ExpiryDate), html.EscapeString(rec.LicenseCode), html.EscapeString(short), html.EscapeString(rec.IssuedBy), id, id, actions)
 	}
 
 	totalPages := int(math.Ceil(float64(total) / float64(PageSize)))
 	navHtml := `<div style="margin-top:20px;text-align:center;">`
-	if page > 1 { navHtml += fmt.Sprintf(`<a href="/history?token=%s&page=%d" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">上一页</a> `, token, page-1) }
+	if page > 1 { navHtml += fmt.Sprintf(`<a href="%s&page=%d" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">上一页</a> `, listURL, page-1) }
 	navHtml += fmt.Sprintf(`<span style="margin:0 10px">第 %d / %d 页 (共 %d 条)</span>`, page, totalPages, total)
-	if page < totalPages { navHtml += fmt.Sprintf(`<a href="/history?token=%s&page=%d" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">下一页</a>`, token, page+1) }
+	if page < totalPages { navHtml += fmt.Sprintf(`<a href="%s&page=%d" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">下一页</a>`, listURL, page+1) }
 	navHtml += `</div>`
 
-	html := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>历史记录</title>
-	<style>body{font-family:-apple-system,sans-serif;max-width:900px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}tr:hover{background:#f9f9f9}</style></head><body>
-	<div class="card"><h2 style="display:flex;justify-content:space-between">📜 历史记录 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2><table><thead><tr><th style="width:50px;text-align:center">序号</th><th>时间</th><th>机器码</th><th>到期</th><th>激活码</th></tr></thead><tbody>%s</tbody></table>%s</div></body></html>`, rowsHtml, navHtml)
+	body := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>历史记录</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:900px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}tr:hover{background:#f9f9f9}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px;margin-left:4px} .del-btn:hover{background:#ff3b30;color:white}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">📜 历史记录 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2>%s<table><thead><tr><th style="width:50px;text-align:center">序号</th><th>时间</th><th>机器码</th><th>到期</th><th>激活码</th><th>签发人</th><th>ID</th><th style="width:110px;text-align:center">操作</th></tr></thead><tbody>%s</tbody></table>%s</div>
+	<script>async function licAction(url,id,msg){if(!confirm(msg))return;try {let res = await fetch(url, {method: 'POST', headers: {'Content-Type': 'application/json'},body: JSON.stringify({id: id})});if(res.ok) location.reload(); else alert(await res.text());} catch(e){alert(e)}}</script></body></html>`, historyToolbar(view, q), rowsHtml, navHtml)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(html))
+	w.Write([]byte(body))
 }
 
 // 🔥 这里是处理生成的入口，也是发送通知的地方 (唯一的一个)
@@ -374,71 +601,81 @@ func handleAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" { http.Error(w, "405", 405); return }
 	var req GenerateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, err.Error(), 400); return }
-	if req.Token != SecurityToken { http.Error(w, "Token 错误", 403); return }
-
-	code, err := generateLicenseCore(req.MachineID, req.Expiry)
-	if err != nil { log.Printf("生成失败: %v", err); http.Error(w, err.Error(), 500); return }
+	p := authorize(w, r, PermGenerate, req.Token)
+	if p == nil { return }
+	if !generateOutputs[req.Output] { http.Error(w, "不支持的输出格式: "+req.Output, 400); return }
+	rec, ok := issueLicense(w, r, p, req)
+	if !ok { return }
+	w.Header().Set("X-License-ID", rec.ID)
+	writeGenerateOutput(w, r, req.Output, rec)
+}
 
-	saveData(req.MachineID, req.Expiry, code)
+// machineIDPattern 限制机器码字符集，机器码会原样显示在管理页面中
+var machineIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+const machineIDRule = "机器码只能包含字母、数字和 . _ : -，且不超过 128 个字符"
+
+// issueLicense 是单个签发的完整流程：策略、二次验证、配额、签发、保存、通知与审计，失败时已写入错误响应
+func issueLicense(w http.ResponseWriter, r *http.Request, p *Principal, req GenerateRequest) (HistoryRecord, bool) {
+	if req.MachineID == "" || req.Expiry == "" { http.Error(w, "机器码或日期为空", 400); return HistoryRecord{}, false }
+	if !machineIDPattern.MatchString(req.MachineID) { http.Error(w, machineIDRule, 400); return HistoryRecord{}, false }
+	format, err := resolveLicenseFormat(req.Format, req.Product)
+	if err != nil { http.Error(w, err.Error(), 400); return HistoryRecord{}, false }
+	expiryUTC, err := expiryTime(req.Expiry)
+	if err != nil { http.Error(w, err.Error(), 400); return HistoryRecord{}, false }
+	if err := issuePolicy(p, req.Product, expiryUTC); err != nil { denyOverPolicy(w, err); return HistoryRecord{}, false }
+	if longLicense(req.Expiry) && !stepUp(w, r, p) { return HistoryRecord{}, false }
+	charged := []int64{expiryUTC}
+	if err := chargeQuota(p.Name, charged, true); err != nil { denyOverQuota(w, r, p, err); return HistoryRecord{}, false }
+
+	rec, err := generateLicenseCore(LicenseParams{MachineID: req.MachineID, Expiry: req.Expiry, Features: req.Features, Format: format, Product: req.Product})
+	if err != nil { refundQuota(p.Name, charged); log.Printf("生成失败: %v", err); http.Error(w, err.Error(), 500); return HistoryRecord{}, false }
+	rec.Customer, rec.Source, rec.IssuedBy = req.Customer, licenseSource(req.Source), p.Name
+
+	// 没有记录的激活码无法吊销，保存失败时不交付
+	if err := store.AddLicenses([]HistoryRecord{rec}); err != nil {
+		refundQuota(p.Name, charged)
+		log.Printf("❌ 保存记录失败: %v", err)
+		http.Error(w, "保存记录失败，激活码未生效: "+err.Error(), 500)
+		return HistoryRecord{}, false
+	}
 	// 推送 Telegram 通知
-	sendTelegramNotification(req.MachineID, req.Expiry, req.Token)
-
-	w.Write([]byte(code))
+	sendTelegramNotification(req.MachineID, req.Expiry, p.Name)
+	audit(r, p, "license.generate", rec.ID, fmt.Sprintf("machine=%s expiry=%s product=%s", req.MachineID, req.Expiry, req.Product))
+	return rec, true
 }
 
 func handleDeleteHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
 	var req DeleteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
-	if req.Token != SecurityToken { http.Error(w, "Token Error", 403); return }
-	mutex.Lock(); defer mutex.Unlock()
-	total := len(historyList)
-	if req.No <= 0 || req.No > total { http.Error(w, "序号不存在", 404); return }
-	historyList = append(historyList[:total-req.No], historyList[total-req.No+1:]...)
-	if f, err := os.Create(historyFile); err == nil { json.NewEncoder(f).Encode(historyList); f.Close() }
-	w.Write([]byte(fmt.Sprintf("✅ 成功删除序号: %d", req.No)))
+	p := authorize(w, r, PermHistoryDelete, req.Token)
+	if p == nil { return }
+	if req.ID == "" { http.Error(w, "ID Empty", 400); return }
+	found, err := store.UpdateLicense(req.ID, func(rec *HistoryRecord) { if rec.DeletedAt == "" { rec.DeletedAt = trashTime() } })
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if !found { http.Error(w, "记录不存在", 404); return }
+	audit(r, p, "license.delete", req.ID, "")
+	w.Write([]byte(fmt.Sprintf("✅ 已移入回收站: %s", req.ID)))
 }
 
 func handleDeleteMachine(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
 	var req DeleteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
-	if req.Token != SecurityToken { http.Error(w, "Token Error", 403); return }
+	p := authorize(w, r, PermMachinesDelete, req.Token)
+	if p == nil { return }
 	if req.MachineID == "" { http.Error(w, "MachineID Empty", 400); return }
 
-	mutex.Lock(); defer mutex.Unlock()
-	newMachines := make([]MachineRecord, 0, len(machineList))
-	found := false
-	for _, m := range machineList {
-		if m.MachineID == req.MachineID { found = true; continue }
-		newMachines = append(newMachines, m)
-	}
+	found, err := store.UpdateMachine(req.MachineID, func(m *MachineRecord) { if m.DeletedAt == "" { m.DeletedAt = trashTime() } })
+	if err != nil { http.Error(w, err.Error(), 500); return }
 	if !found { http.Error(w, "机器码未找到", 404); return }
-	machineList = newMachines
-	if f, err := os.Create(machineFile); err == nil { json.NewEncoder(f).Encode(machineList); f.Close() }
-	w.Write([]byte("✅ 机器码已删除"))
+	audit(r, p, "machine.delete", req.MachineID, "")
+	w.Write([]byte("✅ 机器码已移入回收站"))
 }
 
-func saveData(mid, expiry, code string) {
-	mutex.Lock(); defer mutex.Unlock()
-	nowStr := time.Now().Format("2006-01-02 15:04:05")
-	rec := HistoryRecord{GenerateTime: nowStr, MachineID: mid, ExpiryDate: expiry, LicenseCode: code}
-	historyList = append(historyList, rec)
-	if f, err := os.Create(historyFile); err == nil { json.NewEncoder(f).Encode(historyList); f.Close() }
+func getEnv(k, def string) string { if v := os.Getenv(k); v != "" { return v }; return def }
 
-	found := false
-	for i, m := range machineList {
-		if m.MachineID == mid { machineList[i].LastSeen = nowStr; found = true; break }
-	}
-	if !found { machineList = append(machineList, MachineRecord{MachineID: mid, LastSeen: nowStr}) }
-	if f, err := os.Create(machineFile); err == nil { json.NewEncoder(f).Encode(machineList); f.Close() }
-}
-
-func safeLoadData() {
-	mutex.Lock(); defer mutex.Unlock()
-	log.Println(">>> 正在加载数据文件...")
-	if f, err := os.Open(historyFile); err == nil { json.NewDecoder(f).Decode(&historyList); f.Close() } else { log.Printf(">>> 提示: 无法读取历史文件: %v", err) }
-	if f, err := os.Open(machineFile); err == nil { json.NewDecoder(f).Decode(&machineList); f.Close() } else { log.Printf(">>> 提示: 无法读取机器码文件: %v", err) }
-}
+func getEnvInt(k string, def int) int { if v, err := strconv.Atoi(os.Getenv(k)); err == nil && v > 0 { return v }; return def }
 
-func getEnv(k, def string) string { if v := os.Getenv(k); v != "" { return v }; return def }
\ No newline at end of file
+func getEnvDuration(k string, def time.Duration) time.Duration { if v, err := time.ParseDuration(os.Getenv(k)); err == nil && v > 0 { return v }; return def }
\ No newline at end of file
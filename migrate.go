@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"license-server/client"
+)
+
+// ================= 迁移 / 导入导出 =================
+//
+// 命令行用法 (需先停止服务，避免与运行中的进程同时写入)：
+//
+//	server migrate -from json:. -to bolt:license.bolt   从一个存储迁移到另一个存储
+//	server export [-store sqlite:license.db] [-o backup.jsonl]
+//	server import [-store sqlite:license.db] backup.jsonl
+//
+// 存储写作 "类型:路径"，省略时使用 STORE_BACKEND / STORE_PATH。目标存储必须为空，-force 可跳过该检查。
+//
+// 导出格式为 JSON Lines (UTF-8，每行一个对象)，第一行为 header，最后一行为 footer：
+//
+//	{"type":"header","version":1,"exported_at":"2026-01-02T03:04:05Z","backend":"json"}
+//	{"type":"license","data":{...HistoryRecord...}}          按生成顺序 (旧 → 新)
+//	{"type":"machine","data":{...MachineRecord...}}          按首次出现顺序
+//	{"type":"doc","kind":"users","id":"alice","data":{...}}  其他实体
+//	{"type":"footer","licenses":2,"machines":1,"docs":1}
+//
+// 导入时校验 footer 中的数量，缺少 footer 说明文件不完整，拒绝导入。
+
+const exportVersion = 1
+
+type exportLine struct {
+	Type       string          `json:"type"`
+	Version    int             `json:"version,omitempty"`
+	ExportedAt string          `json:"exported_at,omitempty"`
+	Backend    string          `json:"backend,omitempty"`
+	Kind       string          `json:"kind,omitempty"`
+	ID         string          `json:"id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Licenses   int             `json:"licenses,omitempty"`
+	Machines   int             `json:"machines,omitempty"`
+	Docs       int             `json:"docs,omitempty"`
+}
+
+// storeData 是一个存储的完整内容，迁移和导入导出都经过它
+type storeData struct {
+	Licenses []HistoryRecord
+	Machines []MachineRecord
+	Docs     map[string][]Doc
+}
+
+func (d *storeData) docCount() int {
+	n := 0
+	for _, docs := range d.Docs { n += len(docs) }
+	return n
+}
+
+// runCommand 执行命令行子命令，返回进程退出码
+func runCommand(name string, args []string) int {
+	var err error
+	switch name {
+	case "migrate":
+		err = cmdMigrate(args)
+	case "export":
+		err = cmdExport(args)
+	case "import":
+		err = cmdImport(args)
+	case "backup":
+		err = cmdBackup(args)
+	case "restore":
+		err = cmdRestore(args)
+	case "user":
+		err = cmdUser(args)
+	case "audit":
+		err = cmdAudit(args)
+	case "openapi":
+		err = cmdOpenAPI(args)
+	default:
+		fmt.Fprintf(os.Stderr, "未知的命令: %s\n可用命令: migrate, export, import, backup, restore, user, audit, openapi\n", name)
+		return 2
+	}
+	if err != nil { log.Printf("❌ %s 失败: %v", name, err); return 1 }
+	return 0
+}
+
+func cmdMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "json:.", "源存储 (类型:路径)")
+	to := fs.String("to", "", "目标存储 (类型:路径)")
+	force := fs.Bool("force", false, "目标存储非空时仍然写入")
+	fs.Parse(args)
+	if *to == "" { return fmt.Errorf("缺少 -to 参数") }
+	if *from == *to { return fmt.Errorf("源存储和目标存储相同") }
+
+	src, err := openStoreSpec(*from)
+	if err != nil { return err }
+	defer src.Close()
+	data, err := readStoreData(src)
+	if err != nil { return fmt.Errorf("读取源存储失败: %v", err) }
+
+	dst, err := openStoreSpec(*to)
+	if err != nil { return err }
+	defer dst.Close()
+	return writeStoreData(dst, data, *force)
+}
+
+func cmdExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	spec := fs.String("store", "", "存储 (类型:路径)，默认取环境变量")
+	out := fs.String("o", "-", "输出文件，- 为标准输出")
+	fs.Parse(args)
+
+	st, err := openStoreSpec(*spec)
+	if err != nil { return err }
+	defer st.Close()
+	data, err := readStoreData(st)
+	if err != nil { return err }
+
+	if *out == "-" { return exportData(os.Stdout, data, storeBackendOf(*spec)) }
+	f, err := os.Create(*out)
+	if err != nil { return err }
+	if err := exportData(f, data, storeBackendOf(*spec)); err != nil { f.Close(); return err }
+	if err := f.Sync(); err != nil { f.Close(); return err }
+	if err := f.Close(); err != nil { return err }
+	log.Printf(">>> 已导出 %d 条记录, %d 台机器, %d 个文档到 %s", len(data.Licenses), len(data.Machines), data.docCount(), *out)
+	return nil
+}
+
+func cmdImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	spec := fs.String("store", "", "存储 (类型:路径)，默认取环境变量")
+	force := fs.Bool("force", false, "存储非空时仍然写入")
+	fs.Parse(args)
+	if fs.NArg() != 1 { return fmt.Errorf("用法: import [-store 类型:路径] [-force] 文件.jsonl") }
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil { return err }
+	defer f.Close()
+	data, err := parseExport(f)
+	if err != nil { return err }
+
+	st, err := openStoreSpec(*spec)
+	if err != nil { return err }
+	defer st.Close()
+	return writeStoreData(st, data, *force)
+}
+
+// openStoreSpec 打开 "类型:路径" 形式的存储，为空时使用 STORE_BACKEND / STORE_PATH
+func openStoreSpec(spec string) (Store, error) {
+	if spec == "" { return openStore(StoreBackend, StorePath) }
+	backend, path, _ := strings.Cut(spec, ":")
+	return openStore(backend, path)
+}
+
+func storeBackendOf(spec string) string {
+	if spec == "" { return StoreBackend }
+	backend, _, _ := strings.Cut(spec, ":")
+	return backend
+}
+
+func readStoreData(st Store) (*storeData, error) {
+	data := &storeData{Docs: map[string][]Doc{}}
+	var err error
+	if data.Licenses, err = st.ListLicenses(); err != nil { return nil, err }
+	if data.Machines, err = st.ListMachines(); err != nil { return nil, err }
+	for _, kind := range docKinds {
+		docs, err := st.ListDocs(kind)
+		if err != nil { return nil, err }
+		if len(docs) > 0 { data.Docs[kind] = docs }
+	}
+	return data, nil
+}
+
+// writeStoreData 规范化后写入目标存储。目标非空且未指定 force 时拒绝，避免重复导入产生两份记录。
+func writeStoreData(st Store, data *storeData, force bool) error {
+	if !force {
+		_, total, err := st.LicensePage(LicenseFilter{View: ViewAll}, 0, 1)
+		if err != nil { return err }
+		machines, err := st.ListMachines()
+		if err != nil { return err }
+		if total > 0 || len(machines) > 0 { return fmt.Errorf("目标存储已有 %d 条记录, %d 台机器；确认要合并请加 -force", total, len(machines)) }
+	}
+
+	warnings := 0
+	for i := range data.Licenses {
+		for _, w := range normalizeRecord(&data.Licenses[i], i) {
+			log.Printf("⚠️ 第 %d 条记录 (%s): %s", i+1, data.Licenses[i].MachineID, w)
+			warnings++
+		}
+	}
+	// 重复的 ID 在 SQLite 上违反唯一索引，在 bbolt 上会留下孤立的记录和索引，写入前统一拒绝
+	seen := map[string]int{}
+	for i, rec := range data.Licenses {
+		if j, ok := seen[rec.ID]; ok { return fmt.Errorf("第 %d 条与第 %d 条记录的 ID 重复: %s", i+1, j+1, rec.ID) }
+		seen[rec.ID] = i
+		if !force { continue }
+		existing, err := st.GetLicense(rec.ID)
+		if err != nil { return err }
+		if existing != nil { return fmt.Errorf("第 %d 条记录的 ID %s 在目标存储中已存在", i+1, rec.ID) }
+	}
+	if err := st.ImportData(data.Licenses, data.Machines); err != nil { return fmt.Errorf("写入记录失败: %v", err) }
+	for kind, docs := range data.Docs {
+		for _, d := range docs {
+			if err := st.PutDoc(kind, d.ID, d.Body); err != nil { return fmt.Errorf("写入文档 %s/%s 失败: %v", kind, d.ID, err) }
+		}
+	}
+	log.Printf(">>> ✅ 已写入 %d 条记录, %d 台机器, %d 个文档 (%d 条警告)", len(data.Licenses), len(data.Machines), data.docCount(), warnings)
+	return nil
+}
+
+// generateTimeLayouts 是历史数据中出现过的生成时间格式，均按服务器本地时区解释
+var generateTimeLayouts = []string{"2006-01-02 15:04:05", "2006/01/02 15:04:05", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// normalizeRecord 统一生成时间格式，并从激活码中解出 ID、格式、精确到期时间和密钥 ID；n 为记录序号，用于给旧记录补 ID。
+// 无法解析或校验失败的字段保持原样，只返回警告，不丢弃记录。
+func normalizeRecord(rec *HistoryRecord, n int) []string {
+	defer fillLegacyFields(rec, n)
+	var warnings []string
+	rec.MachineID = strings.TrimSpace(rec.MachineID)
+	rec.GenerateTime = strings.TrimSpace(rec.GenerateTime)
+
+	parsed := false
+	for _, layout := range generateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, rec.GenerateTime, time.Local); err == nil {
+			rec.GenerateTime = t.Local().Format("2006-01-02 15:04:05")
+			parsed = true
+			break
+		}
+	}
+	if !parsed { warnings = append(warnings, fmt.Sprintf("无法解析生成时间 %q", rec.GenerateTime)) }
+
+	code := extractLicenseCode(rec.LicenseCode)
+	if code == "" { return append(warnings, "激活码为空") }
+	if k, err := client.ParseShortKey(code); err == nil { code = k.String() } // 与签发时保存的写法一致，便于按原文查找
+	rec.LicenseCode = code
+	resp := verifyLicenseCode(code, rec.MachineID)
+	if resp.ExpiresAt == "" { return append(warnings, "激活码无法校验: "+resp.Error) }
+
+	if rec.ID == "" { rec.ID = resp.LicenseID }
+	if rec.Format == "" { rec.Format = resp.Format }
+	if rec.ExpiryDate != resp.ExpiryDate {
+		warnings = append(warnings, fmt.Sprintf("到期日期 %s 与激活码不符，已更正为 %s", rec.ExpiryDate, resp.ExpiryDate))
+		rec.ExpiryDate = resp.ExpiryDate
+	}
+	rec.ExpiresAt, rec.KeyID = resp.ExpiresAt, resp.KeyID
+	if rec.Product == "" { rec.Product = resp.Product }
+	return warnings
+}
+
+// exportData 按文件头注释中的格式写出全部数据
+func exportData(w io.Writer, data *storeData, backend string) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(exportLine{Type: "header", Version: exportVersion, ExportedAt: time.Now().UTC().Format(time.RFC3339), Backend: backend}); err != nil { return err }
+	for _, rec := range data.Licenses {
+		body, _ := json.Marshal(rec)
+		if err := enc.Encode(exportLine{Type: "license", Data: body}); err != nil { return err }
+	}
+	for _, m := range data.Machines {
+		body, _ := json.Marshal(m)
+		if err := enc.Encode(exportLine{Type: "machine", Data: body}); err != nil { return err }
+	}
+	for _, kind := range docKinds {
+		for _, d := range data.Docs[kind] {
+			if err := enc.Encode(exportLine{Type: "doc", Kind: kind, ID: d.ID, Data: d.Body}); err != nil { return err }
+		}
+	}
+	if err := enc.Encode(exportLine{Type: "footer", Licenses: len(data.Licenses), Machines: len(data.Machines), Docs: data.docCount()}); err != nil { return err }
+	return bw.Flush()
+}
+
+// parseExport 读取导出文件并校验 header / footer
+func parseExport(r io.Reader) (*storeData, error) {
+	data := &storeData{Docs: map[string][]Doc{}}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	lineNo, footer := 0, false
+	for sc.Scan() {
+		lineNo++
+		if len(strings.TrimSpace(sc.Text())) == 0 { continue }
+		if footer { return nil, fmt.Errorf("第 %d 行: footer 之后还有内容", lineNo) }
+		var l exportLine
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil { return nil, fmt.Errorf("第 %d 行格式错误: %v", lineNo, err) }
+		if lineNo == 1 && l.Type != "header" { return nil, fmt.Errorf("缺少 header，不是导出文件") }
+
+		var err error
+		switch l.Type {
+		case "header":
+			if lineNo != 1 { return nil, fmt.Errorf("第 %d 行: 重复的 header", lineNo) }
+			if l.Version > exportVersion { return nil, fmt.Errorf("导出文件版本 %d 高于当前支持的 %d", l.Version, exportVersion) }
+		case "license":
+			var rec HistoryRecord
+			err = json.Unmarshal(l.Data, &rec)
+			data.Licenses = append(data.Licenses, rec)
+		case "machine":
+			var m MachineRecord
+			err = json.Unmarshal(l.Data, &m)
+			data.Machines = append(data.Machines, m)
+		case "doc":
+			if l.Kind == "" || l.ID == "" || len(l.Data) == 0 { return nil, fmt.Errorf("第 %d 行: 文档缺少 kind / id / data", lineNo) }
+			data.Docs[l.Kind] = append(data.Docs[l.Kind], Doc{ID: l.ID, Body: l.Data})
+		case "footer":
+			if l.Licenses != len(data.Licenses) || l.Machines != len(data.Machines) || l.Docs != data.docCount() {
+				return nil, fmt.Errorf("数量与 footer 不符 (记录 %d/%d, 机器 %d/%d, 文档 %d/%d)", len(data.Licenses), l.Licenses, len(data.Machines), l.Machines, data.docCount(), l.Docs)
+			}
+			footer = true
+		default:
+			return nil, fmt.Errorf("第 %d 行: 未知类型 %q", lineNo, l.Type)
+		}
+		if err != nil { return nil, fmt.Errorf("第 %d 行数据错误: %v", lineNo, err) }
+	}
+	if err := sc.Err(); err != nil { return nil, err }
+	if !footer { return nil, fmt.Errorf("缺少 footer，文件可能不完整") }
+	return data, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 导入数据中有重复 ID，或 -force 合并时与目标存储已有记录重复，各后端都在写入前拒绝
+func TestWriteStoreDataRejectsDuplicateIDs(t *testing.T) {
+	for _, backend := range []string{"json", "sqlite", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			path := t.TempDir()
+			if backend != "json" { path = filepath.Join(path, "license."+backend) }
+			st, err := openStore(backend, path)
+			if err != nil { t.Fatal(err) }
+			defer st.Close()
+
+			rec := func(id, mid string) HistoryRecord {
+				return HistoryRecord{ID: id, MachineID: mid, GenerateTime: "2026-01-01 00:00:00", ExpiryDate: "2030-01-01", LicenseCode: "code-" + mid}
+			}
+			dup := &storeData{Licenses: []HistoryRecord{rec("ID-1", "M-1"), rec("ID-1", "M-2")}}
+			if err := writeStoreData(st, dup, false); err == nil || !strings.Contains(err.Error(), "ID 重复") { t.Fatalf("err = %v", err) }
+			if recs, _ := st.ListLicenses(); len(recs) != 0 { t.Fatalf("拒绝后不应写入任何记录: %d", len(recs)) }
+
+			if err := writeStoreData(st, &storeData{Licenses: []HistoryRecord{rec("ID-1", "M-1")}}, false); err != nil { t.Fatal(err) }
+			merge := &storeData{Licenses: []HistoryRecord{rec("ID-2", "M-2"), rec("ID-1", "M-3")}}
+			if err := writeStoreData(st, merge, true); err == nil || !strings.Contains(err.Error(), "已存在") { t.Fatalf("err = %v", err) }
+			if recs, _ := st.ListLicenses(); len(recs) != 1 { t.Fatalf("记录数 = %d, 期望 1", len(recs)) }
+		})
+	}
+}
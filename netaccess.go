@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ================= 来源 IP 与访问白名单 =================
+//
+// 只有直接连接方位于 TRUSTED_PROXIES 内时才采信 Forwarded / X-Forwarded-For，从右往左跳过可信代理，
+// 第一个不可信的地址即为客户端 IP；否则一律使用 TCP 连接的对端地址，防止伪造请求头绕过白名单和限流。
+// 路由分为三组，分别由 ALLOW_ADMIN、ALLOW_ISSUE、ALLOW_PUBLIC 指定允许的 CIDR (逗号分隔，留空不限制)：
+//   - admin：管理页面、登录及各类管理接口
+//   - issue：签发、查询、吊销激活码的接口 (含 /api/v2/licenses，供计费系统等调用)
+//   - public：在线校验和 JWKS
+// /health 不受限制，便于负载均衡探活。
+
+const (
+	RouteAdmin  = "admin"
+	RouteIssue  = "issue"
+	RoutePublic = "public"
+)
+
+var (
+	ListenHost     = getEnv("LISTEN_HOST", "0.0.0.0")
+	TrustedProxies = parseCIDRs("TRUSTED_PROXIES")
+	routeAllow     = map[string][]*net.IPNet{
+		RouteAdmin:  parseCIDRs("ALLOW_ADMIN"),
+		RouteIssue:  parseCIDRs("ALLOW_ISSUE"),
+		RoutePublic: parseCIDRs("ALLOW_PUBLIC"),
+	}
+)
+
+// parseCIDRs 解析环境变量中的 CIDR 列表，单个 IP 视为 /32 或 /128
+func parseCIDRs(env string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(os.Getenv(env), ",") {
+		if s = strings.TrimSpace(s); s == "" { continue }
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil { s += "/32" } else { s += "/128" }
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil { log.Printf(">>> ⚠️ %s 中的 %s 不是有效的 CIDR，已忽略", env, s); continue }
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) { return true }
+	}
+	return false
+}
+
+// routeGroup 返回路径所属的路由组，空字符串表示不受白名单限制
+func routeGroup(path string) string {
+	switch {
+	case path == "/health":
+		return ""
+	case path == "/api/verify" || path == "/.well-known/jwks.json":
+		return RoutePublic
+	case strings.HasPrefix(path, "/api/generate") || path == "/api/license" || path == "/api/revoke" || strings.HasPrefix(path, "/api/v2/licenses"):
+		return RouteIssue
+	default:
+		return RouteAdmin
+	}
+}
+
+// forwardedChain 按从左到右的顺序返回代理头中记录的地址，优先使用标准的 Forwarded
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	if fwd := r.Header.Values("Forwarded"); len(fwd) > 0 {
+		for _, elem := range strings.Split(strings.Join(fwd, ","), ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(k, "for") { continue }
+				v = strings.Trim(v, `"`)
+				// 形如 "[2001:db8::1]:4711" 或 "192.0.2.1:4711"
+				if strings.HasPrefix(v, "[") {
+					if end := strings.Index(v, "]"); end > 0 { v = v[1:end] }
+				} else if host, _, err := net.SplitHostPort(v); err == nil {
+					v = host
+				}
+				chain = append(chain, v)
+			}
+		}
+		return chain
+	}
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, v := range strings.Split(h, ",") {
+			if v = strings.TrimSpace(v); v != "" { chain = append(chain, v) }
+		}
+	}
+	return chain
+}
+
+// clientIP 返回请求的真实来源 IP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil { host = r.RemoteAddr }
+	peer := net.ParseIP(host)
+	if peer == nil || !ipInNets(peer, TrustedProxies) { return host }
+	chain := forwardedChain(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil { return host } // 无法解析 (如 "unknown" 或混淆标识) 时不再往前追溯
+		if !ipInNets(ip, TrustedProxies) { return ip.String() }
+		host = ip.String()
+	}
+	return host
+}
+
+// ipFilter 按路由组检查来源 IP 是否在白名单内
+func ipFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if group := routeGroup(r.URL.Path); group != "" {
+			if nets := routeAllow[group]; len(nets) > 0 {
+				ip := net.ParseIP(clientIP(r))
+				if ip == nil || !ipInNets(ip, nets) {
+					log.Printf("🚫 拒绝 %s 访问 %s (不在 %s 白名单内)", clientIP(r), r.URL.Path, group)
+					http.Error(w, "Forbidden", 403)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
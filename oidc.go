@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= OIDC 单点登录 =================
+//
+// 授权码 + PKCE (S256) 流程：/login/oidc 跳转到身份提供方，/login/oidc/callback 用授权码换取 ID Token，
+// 校验签名 (RS256 / ES256，公钥取自 jwks_uri)、iss、aud、exp 和 nonce 后建立登录会话。
+// 用户的角色每次登录时按 OIDC_ROLE_MAP 由组映射得到 (取权限最高的一个)，没有匹配的组且未设置
+// OIDC_DEFAULT_ROLE 时拒绝登录。首次登录自动创建没有密码的本地用户，不会接管同名的本地密码账号。
+// 本地调试可用 go run ./cmd/mock-oidc 启动模拟身份提供方。
+
+var (
+	OIDCIssuer        = strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	OIDCClientID      = os.Getenv("OIDC_CLIENT_ID")
+	OIDCClientSecret  = os.Getenv("OIDC_CLIENT_SECRET") // 公共客户端可留空，仅靠 PKCE
+	OIDCRedirectURL   = os.Getenv("OIDC_REDIRECT_URL")  // 默认按请求地址拼出 /login/oidc/callback
+	OIDCScopes        = getEnv("OIDC_SCOPES", "openid profile email")
+	OIDCUsernameClaim = getEnv("OIDC_USERNAME_CLAIM", "preferred_username")
+	OIDCGroupsClaim   = getEnv("OIDC_GROUPS_CLAIM", "groups")
+	OIDCRoleMap       = parseRoleMap(os.Getenv("OIDC_ROLE_MAP")) // 形如 "lic-admins=admin,lic-ops=issuer"
+	OIDCDefaultRole   = os.Getenv("OIDC_DEFAULT_ROLE")
+)
+
+const oidcStateCookie = "ls_oidc"
+
+// roleRank 用于多个组映射到不同角色时取权限最高的一个
+var roleRank = map[string]int{RoleViewer: 1, RoleSupport: 2, RoleIssuer: 3, RoleAdmin: 4}
+
+func oidcEnabled() bool { return OIDCIssuer != "" && OIDCClientID != "" }
+
+func parseRoleMap(s string) map[string]string {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok { continue }
+		if !validRole(strings.TrimSpace(role)) { log.Printf(">>> ⚠️ OIDC_ROLE_MAP 中的角色 %s 无效，已忽略", role); continue }
+		m[strings.TrimSpace(group)] = strings.TrimSpace(role)
+	}
+	return m
+}
+
+// mapGroupsToRole 返回组映射出的最高角色，没有匹配时返回默认角色 (可能为空)
+func mapGroupsToRole(groups []string) string {
+	role := ""
+	for _, g := range groups {
+		if r, ok := OIDCRoleMap[g]; ok && roleRank[r] > roleRank[role] { role = r }
+	}
+	if role == "" { role = OIDCDefaultRole }
+	return role
+}
+
+// ================= 提供方元数据与公钥 =================
+
+type oidcProvider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	Issuer                string `json:"issuer"`
+
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+var oidcState = struct {
+	sync.Mutex
+	provider *oidcProvider
+	pending  map[string]*oidcPending
+}{pending: map[string]*oidcPending{}}
+
+// oidcPending 是一次尚未完成的登录
+type oidcPending struct {
+	Verifier string
+	Nonce    string
+	Next     string
+	Reauth   bool
+	Created  time.Time
+}
+
+const (
+	oidcPendingTTL  = 10 * time.Minute
+	oidcMetadataTTL = time.Hour
+)
+
+var oidcHTTP = &http.Client{Timeout: 10 * time.Second}
+
+func fetchJSON(u string, v interface{}) error {
+	resp, err := oidcHTTP.Get(u)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 { return fmt.Errorf("%s 返回 %d", u, resp.StatusCode) }
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// loadProvider 读取 discovery 文档和 JWKS，缓存一小时；refresh 为 true 时强制刷新 (遇到未知 kid)
+func loadProvider(refresh bool) (*oidcProvider, error) {
+	oidcState.Lock()
+	p := oidcState.provider
+	oidcState.Unlock()
+	if p != nil && !refresh && time.Since(p.fetched) < oidcMetadataTTL { return p, nil }
+
+	np := &oidcProvider{}
+	if err := fetchJSON(OIDCIssuer+"/.well-known/openid-configuration", np); err != nil { return nil, fmt.Errorf("读取 OIDC 配置失败: %v", err) }
+	if strings.TrimSuffix(np.Issuer, "/") != OIDCIssuer { return nil, fmt.Errorf("OIDC issuer 不一致: %s", np.Issuer) }
+	var set struct {
+		Keys []struct {
+			Kty, Kid, Use, Alg, N, E, Crv, X, Y string
+		} `json:"keys"`
+	}
+	if err := fetchJSON(np.JWKSURI, &set); err != nil { return nil, fmt.Errorf("读取 OIDC 公钥失败: %v", err) }
+	np.keys = map[string]crypto.PublicKey{}
+	dec := base64.RawURLEncoding.DecodeString
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" { continue }
+		switch k.Kty {
+		case "RSA":
+			n, err1 := dec(k.N)
+			e, err2 := dec(k.E)
+			if err1 != nil || err2 != nil { continue }
+			np.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, err1 := dec(k.X)
+			y, err2 := dec(k.Y)
+			if err1 != nil || err2 != nil || k.Crv != "P-256" { continue }
+			np.keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	np.fetched = time.Now()
+	oidcState.Lock()
+	oidcState.provider = np
+	oidcState.Unlock()
+	return np, nil
+}
+
+// verifyIDToken 校验 ID Token 并返回其声明
+func verifyIDToken(raw, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 { return nil, errors.New("ID Token 格式错误") }
+	hb, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	cb, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, err3 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil { return nil, errors.New("ID Token 格式错误") }
+	var h struct{ Alg, Kid string }
+	if err := json.Unmarshal(hb, &h); err != nil { return nil, errors.New("ID Token 格式错误") }
+
+	p, err := loadProvider(false)
+	if err != nil { return nil, err }
+	key, ok := p.keys[h.Kid]
+	if !ok {
+		if p, err = loadProvider(true); err != nil { return nil, err }
+		if key, ok = p.keys[h.Kid]; !ok { return nil, fmt.Errorf("未知的签名公钥: %s", h.Kid) }
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if h.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) != nil { return nil, errors.New("ID Token 签名无效") }
+	case *ecdsa.PublicKey:
+		if h.Alg != "ES256" || len(sig) != 64 || !ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) { return nil, errors.New("ID Token 签名无效") }
+	default:
+		return nil, errors.New("不支持的签名公钥类型")
+	}
+
+	var c map[string]interface{}
+	if err := json.Unmarshal(cb, &c); err != nil { return nil, errors.New("ID Token 格式错误") }
+	if iss, _ := c["iss"].(string); strings.TrimSuffix(iss, "/") != OIDCIssuer { return nil, errors.New("ID Token issuer 不匹配") }
+	audOK := false
+	switch aud := c["aud"].(type) {
+	case string:
+		audOK = aud == OIDCClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == OIDCClientID { audOK = true }
+		}
+	}
+	if !audOK { return nil, errors.New("ID Token audience 不匹配") }
+	exp, _ := c["exp"].(float64)
+	if time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) { return nil, errors.New("ID Token 已过期") }
+	if n, _ := c["nonce"].(string); n != nonce { return nil, errors.New("ID Token nonce 不匹配") }
+	return c, nil
+}
+
+// ================= 登录流程 =================
+
+func oidcRedirectURL(r *http.Request) string {
+	if OIDCRedirectURL != "" { return OIDCRedirectURL }
+	scheme := "http"
+	if r.TLS != nil || SessionSecure { scheme = "https" }
+	return scheme + "://" + r.Host + "/login/oidc/callback"
+}
+
+// handleOIDCLogin 生成 state / nonce / PKCE 并跳转到身份提供方；reauth=1 时要求重新输入凭据 (用于二次验证)
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() { http.NotFound(w, r); return }
+	p, err := loadProvider(false)
+	if err != nil { log.Printf("❌ %v", err); http.Error(w, "单点登录暂不可用", 502); return }
+
+	state, verifier := randomToken(), randomToken()
+	pending := &oidcPending{Verifier: verifier, Nonce: randomToken(), Next: safeNext(r.URL.Query().Get("next")), Reauth: r.URL.Query().Get("reauth") == "1", Created: time.Now()}
+	oidcState.Lock()
+	for k, v := range oidcState.pending {
+		if time.Since(v.Created) > oidcPendingTTL { delete(oidcState.pending, k) }
+	}
+	oidcState.pending[state] = pending
+	oidcState.Unlock()
+	// state 同时写入 Cookie，回调时比对，防止他人把自己的授权码塞给受害者 (登录 CSRF)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: state, Path: "/login/oidc", MaxAge: int(oidcPendingTTL.Seconds()), HttpOnly: true, Secure: SessionSecure || r.TLS != nil, SameSite: http.SameSiteLaxMode})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {OIDCClientID},
+		"redirect_uri":          {oidcRedirectURL(r)},
+		"scope":                 {OIDCScopes},
+		"state":                 {state},
+		"nonce":                 {pending.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if pending.Reauth { q.Set("prompt", "login"); q.Set("max_age", "0") }
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") { sep = "&" }
+	http.Redirect(w, r, p.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// handleOIDCCallback 用授权码换取 ID Token，映射角色并建立会话
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() { http.NotFound(w, r); return }
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" { http.Error(w, "单点登录失败: "+e+" "+q.Get("error_description"), 401); return }
+	state := q.Get("state")
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || c.Value != state { http.Error(w, "登录状态无效，请重新登录", 400); return }
+	oidcState.Lock()
+	pending := oidcState.pending[state]
+	delete(oidcState.pending, state)
+	oidcState.Unlock()
+	if pending == nil || time.Since(pending.Created) > oidcPendingTTL { http.Error(w, "登录已超时，请重新登录", 400); return }
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1})
+
+	claims, err := exchangeCode(r, q.Get("code"), pending)
+	if err != nil { log.Printf("❌ OIDC 登录失败: %v", err); http.Error(w, "单点登录失败: "+err.Error(), 401); return }
+	u, err := provisionOIDCUser(claims)
+	if err != nil { audit(r, nil, "login.failed", "", "oidc: "+err.Error()); http.Error(w, err.Error(), 403); return }
+
+	id, s := newSession(u.Username)
+	s.StepUpAt = s.Created
+	setSessionCookies(w, r, id, s.CSRF, int(SessionMaxAge.Seconds()))
+	audit(r, &Principal{Name: u.Username, Role: u.Role}, "login", u.Username, "oidc")
+	http.Redirect(w, r, pending.Next, http.StatusSeeOther)
+}
+
+func exchangeCode(r *http.Request, code string, pending *oidcPending) (map[string]interface{}, error) {
+	p, err := loadProvider(false)
+	if err != nil { return nil, err }
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oidcRedirectURL(r)}, "client_id": {OIDCClientID}, "code_verifier": {pending.Verifier}}
+	req, _ := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if OIDCClientSecret != "" { req.SetBasicAuth(url.QueryEscape(OIDCClientID), url.QueryEscape(OIDCClientSecret)) }
+	resp, err := oidcHTTP.Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil { return nil, fmt.Errorf("令牌响应解析失败: %v", err) }
+	if resp.StatusCode != 200 || tok.IDToken == "" { return nil, fmt.Errorf("换取令牌失败: %d %s", resp.StatusCode, tok.Error) }
+	return verifyIDToken(tok.IDToken, pending.Nonce)
+}
+
+// provisionOIDCUser 按声明创建或更新本地用户，角色以身份提供方的组为准
+func provisionOIDCUser(claims map[string]interface{}) (*User, error) {
+	name, _ := claims[OIDCUsernameClaim].(string)
+	if name == "" { name, _ = claims["email"].(string) }
+	name = normalizeUsername(name)
+	if !usernamePattern.MatchString(name) { return nil, fmt.Errorf("无法从声明 %s 得到有效的用户名", OIDCUsernameClaim) }
+
+	var groups []string
+	switch g := claims[OIDCGroupsClaim].(type) {
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok { groups = append(groups, s) }
+		}
+	case string:
+		groups = strings.Fields(strings.ReplaceAll(g, ",", " "))
+	}
+	role := mapGroupsToRole(groups)
+	if role == "" { return nil, fmt.Errorf("用户 %s 不属于任何已授权的组", name) }
+
+	u, err := getUser(store, name)
+	if err != nil { return nil, err }
+	now := time.Now().UTC().Format(time.RFC3339)
+	if u == nil {
+		u = &User{Username: name, Role: role, CreatedAt: now, SSO: OIDCIssuer}
+	} else {
+		if u.SSO == "" { return nil, fmt.Errorf("用户名 %s 已被本地账号使用", name) }
+		if u.Disabled { return nil, fmt.Errorf("用户 %s 已停用", name) }
+		if u.Role == role { return u, nil }
+		if u.Role == RoleAdmin && !u.Disabled {
+			if n, err := activeAdmins(store); err != nil { return nil, err } else if n <= 1 { return nil, fmt.Errorf("不能降级最后一个管理员") }
+		}
+		u.Role, u.UpdatedAt = role, now
+	}
+	return u, store.PutDoc(userDocKind, u.Username, u)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ================= OpenAPI 文档 =================
+
+// openAPISpec 是手写的接口文档，修改路由时需同步更新，
+// 启动时和 `openapi check` 命令会核对两者是否一致
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "patch", "head", "options", "trace"}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" { http.Error(w, "Method Not Allowed", 405); return }
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+// openAPIRoute 判断路由是否属于公开接口 (页面和登录流程不写入文档)
+func openAPIRoute(path string) bool {
+	if path == "/api/v2/" { return false } // v2 子路由自行注册，这里只是入口
+	return strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/.well-known/") || path == "/health" || path == "/openapi.json"
+}
+
+// openAPIProblems 核对已注册的路由模式与文档，返回不一致之处。
+// 模式不带方法时接受任何方法，只要求文档中至少有一个操作
+func openAPIProblems(spec []byte, patterns []string) []string {
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil { return []string{"文档不是合法的 JSON: " + err.Error()} }
+
+	ops := map[string]map[string]bool{} // path -> 文档中的方法
+	for path, item := range doc.Paths {
+		ops[path] = map[string]bool{}
+		for _, m := range openAPIMethods {
+			if _, ok := item[m]; ok { ops[path][strings.ToUpper(m)] = true }
+		}
+	}
+
+	var problems []string
+	routes := map[string]map[string]bool{} // path -> 注册的方法，"" 表示不限
+	for _, pattern := range patterns {
+		method, path := "", pattern
+		if i := strings.IndexByte(pattern, ' '); i >= 0 { method, path = pattern[:i], strings.TrimSpace(pattern[i+1:]) }
+		if !openAPIRoute(path) { continue }
+		if routes[path] == nil { routes[path] = map[string]bool{} }
+		routes[path][method] = true
+
+		documented, ok := ops[path]
+		switch {
+		case !ok || len(documented) == 0:
+			problems = append(problems, fmt.Sprintf("路由 %s 未写入文档", pattern))
+		case method != "" && !documented[method]:
+			problems = append(problems, fmt.Sprintf("路由 %s 在文档中缺少 %s 操作", pattern, method))
+		}
+	}
+	for path, methods := range ops {
+		registered, ok := routes[path]
+		if !ok { problems = append(problems, fmt.Sprintf("文档中的 %s 没有对应的路由", path)); continue }
+		if registered[""] { continue }
+		for m := range methods {
+			if !registered[m] && !(m == "HEAD" && registered["GET"]) { problems = append(problems, fmt.Sprintf("文档中的 %s %s 没有对应的路由", m, path)) }
+		}
+	}
+
+	// 引用的 schema 必须存在
+	for _, name := range openAPIRefs(spec) {
+		if _, ok := doc.Components.Schemas[name]; !ok { problems = append(problems, fmt.Sprintf("文档引用了不存在的 schema: %s", name)) }
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func openAPIRefs(spec []byte) []string {
+	const prefix = `"#/components/schemas/`
+	seen := map[string]bool{}
+	var refs []string
+	for s := string(spec); ; {
+		i := strings.Index(s, prefix)
+		if i < 0 { break }
+		s = s[i+len(prefix):]
+		j := strings.IndexByte(s, '"')
+		if j < 0 { break }
+		if name := s[:j]; !seen[name] { seen[name] = true; refs = append(refs, name) }
+	}
+	return refs
+}
+
+// checkOpenAPI 在启动时提示文档与路由不一致，不阻止启动
+func checkOpenAPI(patterns []string) {
+	for _, p := range openAPIProblems(openAPISpec, patterns) { log.Printf("⚠️ OpenAPI: %s", p) }
+}
+
+// cmdOpenAPI 输出内置的文档；`openapi check` 核对文档与路由，不一致时返回错误 (用于 CI)
+func cmdOpenAPI(args []string) error {
+	if len(args) == 0 { _, err := os.Stdout.Write(openAPISpec); return err }
+	if args[0] != "check" { return fmt.Errorf("用法: openapi [check]") }
+	problems := openAPIProblems(openAPISpec, registerRoutes(http.NewServeMux()))
+	for _, p := range problems { log.Printf("❌ %s", p) }
+	if len(problems) > 0 { return fmt.Errorf("文档与路由不一致，发现 %d 处问题", len(problems)) }
+	log.Println(">>> ✅ OpenAPI 文档与路由一致")
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// 内置的 OpenAPI 文档必须与实际注册的路由一致
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	for _, p := range openAPIProblems(openAPISpec, registerRoutes(http.NewServeMux())) { t.Error(p) }
+}
+
+// 确认检查本身能发现漏写的接口
+func TestOpenAPIProblemsReportsMissingRoute(t *testing.T) {
+	patterns := append(registerRoutes(http.NewServeMux()), "/api/not-documented")
+	if len(openAPIProblems(openAPISpec, patterns)) == 0 { t.Fatal("未文档化的路由没有被报告") }
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// ================= 产品配置 =================
+
+// ProductConfig 描述某个产品的签发默认值，配置文件为 JSON 数组：
+//
+//	[{"name": "kiosk", "format": "short"}, {"name": "cloud", "format": "jwt_eddsa"}]
+type ProductConfig struct {
+	Name   string `json:"name"`
+	Format string `json:"format,omitempty"`
+}
+
+var (
+	productFile  = getEnv("PRODUCTS_FILE", "products.json")
+	productTable = map[string]ProductConfig{}
+)
+
+var licenseFormats = map[string]bool{"classic": true, "short": true, "jwt": true, "jwt_eddsa": true, "paseto": true}
+
+func loadProducts() {
+	data, err := os.ReadFile(productFile)
+	if err != nil { return }
+	var list []ProductConfig
+	if err := json.Unmarshal(data, &list); err != nil { log.Printf(">>> ⚠️ 产品配置解析失败: %v", err); return }
+	for _, p := range list {
+		if p.Format != "" && !licenseFormats[p.Format] { log.Printf(">>> ⚠️ 产品 %s 的格式 %s 不受支持，已忽略", p.Name, p.Format); p.Format = "" }
+		productTable[p.Name] = p
+	}
+	log.Printf(">>> 已加载 %d 个产品配置", len(productTable))
+}
+
+// resolveLicenseFormat 确定激活码格式：请求指定 > 产品默认 > classic
+func resolveLicenseFormat(format, product string) (string, error) {
+	if format == "" { format = productTable[product].Format }
+	if format == "" { format = "classic" }
+	if !licenseFormats[format] { return "", fmt.Errorf("不支持的激活码格式: %s", format) }
+	return format, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 签发配额 =================
+//
+// 每个签发方 (用户名或 key:<名称>) 按自然日、自然月 (北京时间) 分别限制签发数量和授权天数 (到期日距今的天数之和)，
+// 防止账号泄露后被大量签发。未单独设置的签发方使用 QUOTA_DAILY_LICENSES、QUOTA_DAILY_DAYS、
+// QUOTA_MONTHLY_LICENSES、QUOTA_MONTHLY_DAYS (0 表示不限)。单独设置保存为 quotas 文档，用量保存为 quota_usage 文档，
+// 跨日、跨月时自动清零。签发前先扣减配额，超出时返回 429；任一用量首次达到上限的 80% 时通过 Telegram 提醒。
+// 审批通过的申请计入申请人的用量，但不受配额限制。
+
+const (
+	quotaDocKind      = "quotas"
+	quotaUsageDocKind = "quota_usage"
+	quotaAlertRatio   = 0.8
+)
+
+var defaultQuota = Quota{
+	DailyLicenses:   getEnvInt("QUOTA_DAILY_LICENSES", 0),
+	DailyDays:       getEnvInt("QUOTA_DAILY_DAYS", 0),
+	MonthlyLicenses: getEnvInt("QUOTA_MONTHLY_LICENSES", 0),
+	MonthlyDays:     getEnvInt("QUOTA_MONTHLY_DAYS", 0),
+}
+
+// Quota 是一个签发方的配额上限，0 表示不限
+type Quota struct {
+	DailyLicenses   int    `json:"daily_licenses"`
+	DailyDays       int    `json:"daily_days"`
+	MonthlyLicenses int    `json:"monthly_licenses"`
+	MonthlyDays     int    `json:"monthly_days"`
+	UpdatedBy       string `json:"updated_by,omitempty"`
+	UpdatedAt       string `json:"updated_at,omitempty"`
+}
+
+// QuotaUsage 是一个签发方当日、当月的用量
+type QuotaUsage struct {
+	Day           string `json:"day"` // yyyy-mm-dd
+	DayLicenses   int    `json:"day_licenses"`
+	DayDays       int    `json:"day_days"`
+	Month         string `json:"month"` // yyyy-mm
+	MonthLicenses int    `json:"month_licenses"`
+	MonthDays     int    `json:"month_days"`
+}
+
+// rollOver 跨日、跨月时清零对应的用量
+func (u *QuotaUsage) rollOver(now time.Time) {
+	now = now.In(expiryLocation())
+	if day := now.Format("2006-01-02"); u.Day != day { u.Day, u.DayLicenses, u.DayDays = day, 0, 0 }
+	if month := now.Format("2006-01"); u.Month != month { u.Month, u.MonthLicenses, u.MonthDays = month, 0, 0 }
+}
+
+type quotaMetric struct {
+	Name        string
+	Used, Limit int
+}
+
+func quotaMetrics(q Quota, u QuotaUsage) []quotaMetric {
+	return []quotaMetric{
+		{"今日签发数", u.DayLicenses, q.DailyLicenses},
+		{"今日授权天数", u.DayDays, q.DailyDays},
+		{"本月签发数", u.MonthLicenses, q.MonthlyLicenses},
+		{"本月授权天数", u.MonthDays, q.MonthlyDays},
+	}
+}
+
+// QuotaError 表示签发会超出配额
+type QuotaError struct {
+	Operator string
+	Metric   quotaMetric
+	Need     int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("❌ 签发配额不足：%s已用 %d / 上限 %d，本次需要 %d", e.Metric.Name, e.Metric.Used, e.Metric.Limit, e.Need)
+}
+
+// quotaMu 串行化配额的检查与扣减
+var quotaMu sync.Mutex
+
+// licenseDays 返回到期时间距今的授权天数，不足一天按一天计
+func licenseDays(expiryUTC int64, now time.Time) int {
+	days := int(math.Ceil(time.Unix(expiryUTC, 0).Sub(now).Hours() / 24))
+	if days < 1 { days = 1 }
+	return days
+}
+
+// quotaFor 返回签发方的配额，custom 表示是否单独设置过
+func quotaFor(operator string) (q Quota, custom bool, err error) {
+	found, err := store.GetDoc(quotaDocKind, operator, &q)
+	if err != nil { return Quota{}, false, err }
+	if !found { return defaultQuota, false, nil }
+	return q, true, nil
+}
+
+func usageFor(operator string, now time.Time) (QuotaUsage, error) {
+	var u QuotaUsage
+	if _, err := store.GetDoc(quotaUsageDocKind, operator, &u); err != nil { return u, err }
+	u.rollOver(now)
+	return u, nil
+}
+
+// chargeQuota 为即将签发的激活码扣减配额；enforce 为 false 时只记录用量。
+// 超出配额时返回 *QuotaError 且不扣减。
+func chargeQuota(operator string, expiries []int64, enforce bool) error {
+	if len(expiries) == 0 { return nil }
+	now := time.Now()
+	days := 0
+	for _, exp := range expiries { days += licenseDays(exp, now) }
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	q, _, err := quotaFor(operator)
+	if err != nil { return err }
+	u, err := usageFor(operator, now)
+	if err != nil { return err }
+	before := quotaMetrics(q, u)
+	u.DayLicenses += len(expiries)
+	u.MonthLicenses += len(expiries)
+	u.DayDays += days
+	u.MonthDays += days
+	after := quotaMetrics(q, u)
+	if enforce {
+		for i, m := range after {
+			if m.Limit > 0 && m.Used > m.Limit { return &QuotaError{Operator: operator, Metric: before[i], Need: m.Used - before[i].Used} }
+		}
+	}
+	if err := store.PutDoc(quotaUsageDocKind, operator, &u); err != nil { return err }
+
+	for i, m := range after {
+		threshold := float64(m.Limit) * quotaAlertRatio
+		if m.Limit > 0 && float64(before[i].Used) < threshold && float64(m.Used) >= threshold {
+			log.Printf("⚠️ %s 的%s已用 %d / %d", operator, m.Name, m.Used, m.Limit)
+			sendTelegramMessage(fmt.Sprintf("⚠️ <b>签发配额即将用完</b>\n\n👤 <b>签发方:</b> %s\n📊 <b>%s:</b> %d / %d",
+				html.EscapeString(operator), m.Name, m.Used, m.Limit))
+		}
+	}
+	return nil
+}
+
+// refundQuota 退回已扣减但签发失败的配额
+func refundQuota(operator string, expiries []int64) {
+	if len(expiries) == 0 { return }
+	now := time.Now()
+	days := 0
+	for _, exp := range expiries { days += licenseDays(exp, now) }
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	u, err := usageFor(operator, now)
+	if err != nil { log.Printf("❌ 退回配额失败: %v", err); return }
+	u.DayLicenses, u.MonthLicenses = max(u.DayLicenses-len(expiries), 0), max(u.MonthLicenses-len(expiries), 0)
+	u.DayDays, u.MonthDays = max(u.DayDays-days, 0), max(u.MonthDays-days, 0)
+	if err := store.PutDoc(quotaUsageDocKind, operator, &u); err != nil { log.Printf("❌ 退回配额失败: %v", err) }
+}
+
+// denyOverQuota 写入 429 并记录审计
+func denyOverQuota(w http.ResponseWriter, r *http.Request, p *Principal, err error) {
+	var qe *QuotaError
+	if !errors.As(err, &qe) { http.Error(w, err.Error(), 500); return }
+	audit(r, p, "quota.exceeded", qe.Operator, qe.Error())
+	http.Error(w, qe.Error(), 429)
+}
+
+// ================= 配额管理 =================
+
+// OperatorQuota 是配额面板中的一行
+type OperatorQuota struct {
+	Operator string     `json:"operator"`
+	Quota    Quota      `json:"quota"`
+	Custom   bool       `json:"custom"`
+	Usage    QuotaUsage `json:"usage"`
+}
+
+// listOperatorQuotas 汇总所有用户、API Key 以及有配额记录的签发方
+func listOperatorQuotas() ([]OperatorQuota, error) {
+	names := map[string]bool{}
+	users, err := listUsers(store)
+	if err != nil { return nil, err }
+	for _, u := range users {
+		if !u.Disabled { names[u.Username] = true }
+	}
+	keys, err := listAPIKeys()
+	if err != nil { return nil, err }
+	for _, k := range keys {
+		if k.RevokedAt == "" && k.hasScope(ScopeGenerate) { names["key:"+k.Name] = true }
+	}
+	for _, kind := range []string{quotaDocKind, quotaUsageDocKind} {
+		docs, err := store.ListDocs(kind)
+		if err != nil { return nil, err }
+		for _, d := range docs { names[d.ID] = true }
+	}
+
+	now := time.Now()
+	list := make([]OperatorQuota, 0, len(names))
+	for name := range names {
+		q, custom, err := quotaFor(name)
+		if err != nil { return nil, err }
+		u, err := usageFor(name, now)
+		if err != nil { return nil, err }
+		list = append(list, OperatorQuota{Operator: name, Quota: q, Custom: custom, Usage: u})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Operator < list[j].Operator })
+	return list, nil
+}
+
+// QuotaRequest 是设置或恢复默认配额的请求体
+type QuotaRequest struct {
+	Token    string `json:"token,omitempty"`
+	Operator string `json:"operator"`
+	Quota
+}
+
+// handleQuotas GET /api/quotas 返回配额与用量 (无管理权限时只返回自己的)；
+// POST /api/quotas/set 单独设置；POST /api/quotas/reset 恢复默认
+func handleQuotas(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		p := authorize(w, r, PermGenerate, "")
+		if p == nil { return }
+		list, err := visibleQuotas(p)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req QuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p := authorize(w, r, PermQuotas, req.Token)
+	if p == nil { return }
+	req.Operator = strings.TrimSpace(req.Operator)
+	if req.Operator == "" { http.Error(w, "签发方不能为空", 400); return }
+
+	switch r.URL.Path {
+	case "/api/quotas/set":
+		q := req.Quota
+		if q.DailyLicenses < 0 || q.DailyDays < 0 || q.MonthlyLicenses < 0 || q.MonthlyDays < 0 { http.Error(w, "配额不能为负数", 400); return }
+		q.UpdatedBy, q.UpdatedAt = p.Name, time.Now().UTC().Format(time.RFC3339)
+		if err := store.PutDoc(quotaDocKind, req.Operator, &q); err != nil { http.Error(w, err.Error(), 500); return }
+		audit(r, p, "quota.set", req.Operator, fmt.Sprintf("daily=%d/%d天 monthly=%d/%d天", q.DailyLicenses, q.DailyDays, q.MonthlyLicenses, q.MonthlyDays))
+		w.Write([]byte("✅ 配额已保存"))
+	case "/api/quotas/reset":
+		if _, err := store.DeleteDoc(quotaDocKind, req.Operator); err != nil { http.Error(w, err.Error(), 500); return }
+		audit(r, p, "quota.reset", req.Operator, "")
+		w.Write([]byte("✅ 已恢复默认配额"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func visibleQuotas(p *Principal) ([]OperatorQuota, error) {
+	if p.Can(PermQuotas) { return listOperatorQuotas() }
+	q, custom, err := quotaFor(p.Name)
+	if err != nil { return nil, err }
+	u, err := usageFor(p.Name, time.Now())
+	if err != nil { return nil, err }
+	return []OperatorQuota{{Operator: p.Name, Quota: q, Custom: custom, Usage: u}}, nil
+}
+
+// handleQuotasPage 配额面板：管理员可查看并调整所有签发方，其他人只看到自己的用量
+func handleQuotasPage(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermGenerate, "")
+	if p == nil { return }
+	list, err := visibleQuotas(p)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	manage := p.Can(PermQuotas)
+
+	cell := func(m quotaMetric) string {
+		if m.Limit == 0 { return fmt.Sprintf(`%d <span style="color:#aaa">/ 不限</span>`, m.Used) }
+		pct := math.Min(100, float64(m.Used)*100/float64(m.Limit))
+		color := "#34c759"
+		if pct >= 100 { color = "#ff3b30" } else if pct >= quotaAlertRatio*100 { color = "#ff9500" }
+		return fmt.Sprintf(`%d / %d<div style="height:4px;background:#eee;border-radius:2px;margin-top:4px"><div style="height:4px;width:%.0f%%;background:%s;border-radius:2px"></div></div>`, m.Used, m.Limit, pct, color)
+	}
+	rowsHtml := ""
+	for _, oq := range list {
+		cells := ""
+		for _, m := range quotaMetrics(oq.Quota, oq.Usage) { cells += "<td>" + cell(m) + "</td>" }
+		source := `<span style="color:#888">默认</span>`
+		if oq.Custom { source = "单独设置" }
+		action := ""
+		if manage {
+			q := oq.Quota
+			op := html.EscapeString(oq.Operator)
+			action = fmt.Sprintf(`<button data-op="%s" onclick="editQuota(this.dataset.op,%d,%d,%d,%d)" class="ok-btn">调整</button>`, op, q.DailyLicenses, q.DailyDays, q.MonthlyLicenses, q.MonthlyDays)
+			if oq.Custom { action += fmt.Sprintf(` <button data-op="%s" onclick="resetQuota(this.dataset.op)" class="del-btn">恢复默认</button>`, op) }
+		}
+		rowsHtml += fmt.Sprintf(`<tr><td>%s</td>%s<td style="font-size:12px">%s</td><td style="text-align:center;white-space:nowrap">%s</td></tr>`, html.EscapeString(oq.Operator), cells, source, action)
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>签发配额</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:1000px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .del-btn:hover{background:#ff3b30;color:white}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .ok-btn:hover{background:#0071e3;color:white}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">📊 签发配额 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2>
+	<p style="color:#888;font-size:13px">授权天数为每个激活码到期日距签发时的天数之和；用量达到上限的 80%% 时推送提醒，达到上限后拒绝签发</p>
+	<table><thead><tr><th>签发方</th><th>今日签发数</th><th>今日授权天数</th><th>本月签发数</th><th>本月授权天数</th><th>配额</th><th></th></tr></thead><tbody>%s</tbody></table></div>
+	<script>async function post(url,body){let res=await fetch(url,{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify(body)});if(res.ok)location.reload();else alert(await res.text())}
+	function editQuota(op,dl,dd,ml,md){var v=prompt('依次填写 每日签发数, 每日授权天数, 每月签发数, 每月授权天数 (0 表示不限)',[dl,dd,ml,md].join(', '));if(v===null)return;
+	var n=v.split(',').map(s=>parseInt(s.trim())||0);post('/api/quotas/set',{operator:op,daily_licenses:n[0],daily_days:n[1],monthly_licenses:n[2],monthly_days:n[3]})}
+	function resetQuota(op){if(confirm('恢复为默认配额？'))post('/api/quotas/reset',{operator:op})}</script></body></html>`, rowsHtml)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 限流与防暴力破解 =================
+//
+// 认证失败按来源 IP 和账号 (用户名 / API Key / 旧版 Token) 分别计数，连续失败 AUTH_MAX_FAILURES 次后锁定，
+// 锁定时长从 AUTH_LOCKOUT_BASE 起每多失败一次翻倍，最长 AUTH_LOCKOUT_MAX；成功认证清零该账号的计数。
+// 锁定期间即使凭据正确也返回 429，管理员可在 /security 查看并解除。
+// 公开接口另有按 IP 的令牌桶限流：校验接口 RATE_LIMIT_VERIFY、登录 RATE_LIMIT_LOGIN (均为每分钟请求数)。
+// 所有状态只保存在内存中。
+
+var (
+	AuthMaxFailures = getEnvInt("AUTH_MAX_FAILURES", 5)
+	AuthLockoutBase = getEnvDuration("AUTH_LOCKOUT_BASE", time.Minute)
+	AuthLockoutMax  = getEnvDuration("AUTH_LOCKOUT_MAX", time.Hour)
+	RateLimitVerify = getEnvInt("RATE_LIMIT_VERIFY", 60)
+	RateLimitLogin  = getEnvInt("RATE_LIMIT_LOGIN", 20)
+)
+
+// ================= 认证失败锁定 =================
+
+type authFailure struct {
+	Count       int
+	Last        time.Time
+	LockedUntil time.Time
+}
+
+var authFailures = struct {
+	sync.Mutex
+	m map[string]*authFailure // key 为 "ip:<地址>" 或 "account:<账号>"
+}{m: map[string]*authFailure{}}
+
+// authLockedFor 返回 IP 或账号剩余的锁定时间，未锁定时为 0
+func authLockedFor(ip, account string) time.Duration {
+	now := time.Now()
+	authFailures.Lock()
+	defer authFailures.Unlock()
+	var wait time.Duration
+	for _, key := range failureKeys(ip, account) {
+		if f := authFailures.m[key]; f != nil && f.LockedUntil.After(now) && f.LockedUntil.Sub(now) > wait { wait = f.LockedUntil.Sub(now) }
+	}
+	return wait
+}
+
+func failureKeys(ip, account string) []string {
+	keys := []string{"ip:" + ip}
+	if account != "" { keys = append(keys, "account:"+account) }
+	return keys
+}
+
+// authFailed 记录一次认证失败，达到阈值后按指数退避锁定
+func authFailed(ip, account string) {
+	now := time.Now()
+	authFailures.Lock()
+	defer authFailures.Unlock()
+	for key, f := range authFailures.m {
+		// 长时间没有新的失败则遗忘
+		if now.Sub(f.Last) > AuthLockoutMax && now.After(f.LockedUntil) { delete(authFailures.m, key) }
+	}
+	for _, key := range failureKeys(ip, account) {
+		f := authFailures.m[key]
+		if f == nil { f = &authFailure{}; authFailures.m[key] = f }
+		f.Count++
+		f.Last = now
+		if over := f.Count - AuthMaxFailures; over >= 0 {
+			d := time.Duration(float64(AuthLockoutBase) * math.Pow(2, float64(min(over, 30))))
+			if d > AuthLockoutMax { d = AuthLockoutMax }
+			f.LockedUntil = now.Add(d)
+			if over == 0 { log.Printf("🚫 %s 认证连续失败 %d 次，锁定 %v", key, f.Count, d) }
+		}
+	}
+}
+
+// authSucceeded 清除账号的失败计数；IP 的计数保留，防止用一个有效账号掩护对其他账号的猜测
+func authSucceeded(account string) {
+	if account == "" { return }
+	authFailures.Lock()
+	delete(authFailures.m, "account:"+account)
+	authFailures.Unlock()
+}
+
+// presentedAccount 返回请求所携带凭据对应的账号，没有携带凭据时返回空
+func presentedAccount(r *http.Request, legacyToken string) string {
+	if key := apiKeyFromRequest(r); key != "" {
+		if id, _, ok := strings.Cut(strings.TrimPrefix(key, "lk_"), "_"); ok { return "key:" + id }
+		return "key:?"
+	}
+	if name, _, ok := r.BasicAuth(); ok { return normalizeUsername(name) }
+	if legacyToken != "" { return "token" }
+	return ""
+}
+
+// tooManyAttempts 写入 429 和 Retry-After
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, fmt.Sprintf("尝试次数过多，请 %d 秒后再试", int(math.Ceil(wait.Seconds()))), 429)
+}
+
+// ================= 公开接口限流 =================
+
+type bucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	name      string
+	perMinute int
+	buckets   map[string]*bucket
+	limited   map[string]time.Time // 最近一次被拒绝的时间，用于展示
+	swept     time.Time
+}
+
+var rateLimiters []*rateLimiter
+
+func newRateLimiter(name string, perMinute int) *rateLimiter {
+	l := &rateLimiter{name: name, perMinute: perMinute, buckets: map[string]*bucket{}, limited: map[string]time.Time{}}
+	rateLimiters = append(rateLimiters, l)
+	return l
+}
+
+// allow 令牌桶：容量与每分钟补充量均为 perMinute
+func (l *rateLimiter) allow(ip string) bool {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.Last) > time.Minute { delete(l.buckets, k) }
+		}
+		for k, t := range l.limited {
+			if now.Sub(t) > time.Minute { delete(l.limited, k) }
+		}
+		l.swept = now
+	}
+	b := l.buckets[ip]
+	if b == nil { b = &bucket{Tokens: float64(l.perMinute), Last: now}; l.buckets[ip] = b }
+	b.Tokens = math.Min(float64(l.perMinute), b.Tokens+now.Sub(b.Last).Minutes()*float64(l.perMinute))
+	b.Last = now
+	if b.Tokens < 1 { l.limited[ip] = now; return false }
+	b.Tokens--
+	return true
+}
+
+// limit 包装处理函数，超出限额时返回 429
+func (l *rateLimiter) limit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(clientIP(r)) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "请求过于频繁，请稍后再试", 429)
+			return
+		}
+		h(w, r)
+	}
+}
+
+var (
+	verifyLimiter = newRateLimiter("verify", RateLimitVerify)
+	loginLimiter  = newRateLimiter("login", RateLimitLogin)
+)
+
+// ================= 管理页面 =================
+
+// handleSecurity GET 显示当前被锁定和被限流的客户端；POST /api/security/unblock 解除锁定
+func handleSecurity(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/security/unblock" {
+		if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+		p := authorize(w, r, PermSecurity, "")
+		if p == nil { return }
+		key := r.FormValue("key")
+		authFailures.Lock()
+		_, found := authFailures.m[key]
+		delete(authFailures.m, key)
+		authFailures.Unlock()
+		if !found { http.Error(w, "未找到", 404); return }
+		audit(r, p, "security.unblock", key, "")
+		w.Write([]byte("✅ 已解除"))
+		return
+	}
+	if authorize(w, r, PermSecurity, "") == nil { return }
+
+	now := time.Now()
+	type row struct {
+		Key   string
+		Count int
+		Until time.Time
+	}
+	var rows []row
+	authFailures.Lock()
+	for k, f := range authFailures.m {
+		if f.LockedUntil.After(now) || f.Count > 0 { rows = append(rows, row{k, f.Count, f.LockedUntil}) }
+	}
+	authFailures.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Until.After(rows[j].Until) })
+
+	lockRows := ""
+	for _, x := range rows {
+		state := fmt.Sprintf(`<span style="color:#888">%d 次失败</span>`, x.Count)
+		if x.Until.After(now) { state = fmt.Sprintf(`<span style="color:#c00">锁定至 %s</span>`, x.Until.Format("15:04:05")) }
+		lockRows += fmt.Sprintf(`<tr><td style="font-family:monospace">%s</td><td>%d</td><td>%s</td><td style="text-align:center"><button data-key="%s" onclick="unblock(this.dataset.key)" class="ok-btn">解除</button></td></tr>`,
+			html.EscapeString(x.Key), x.Count, state, html.EscapeString(x.Key))
+	}
+	limitRows := ""
+	for _, l := range rateLimiters {
+		l.Lock()
+		for ip, t := range l.limited {
+			if now.Sub(t) < time.Minute { limitRows += fmt.Sprintf(`<tr><td>%s</td><td style="font-family:monospace">%s</td><td>%s</td><td>%d 次/分钟</td></tr>`, l.name, html.EscapeString(ip), t.Format("15:04:05"), l.perMinute) }
+		}
+		l.Unlock()
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>访问控制</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:900px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .ok-btn:hover{background:#0071e3;color:white}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🚫 访问控制 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2>
+	<p style="color:#888;font-size:13px">连续认证失败 %d 次后锁定 %v 起，每次翻倍，最长 %v</p>
+	<h3>认证失败</h3><table><thead><tr><th>来源 / 账号</th><th>失败次数</th><th>状态</th><th style="width:80px"></th></tr></thead><tbody>%s</tbody></table></div>
+	<div class="card"><h3>最近一分钟被限流</h3><table><thead><tr><th>接口</th><th>IP</th><th>最近拒绝</th><th>限额</th></tr></thead><tbody>%s</tbody></table></div>
+	<script>async function unblock(k){let res=await fetch('/api/security/unblock',{method:'POST',headers:{'Content-Type':'application/x-www-form-urlencoded'},body:'key='+encodeURIComponent(k)});if(res.ok)location.reload();else alert(await res.text())}</script></body></html>`,
+		AuthMaxFailures, AuthLockoutBase, AuthLockoutMax, lockRows, limitRows)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,80 @@
+package main
+
+import "net/http"
+
+// ================= 路由 =================
+
+// routeTable 在注册路由的同时记下路由模式，供 OpenAPI 文档核对
+type routeTable struct {
+	mux      *http.ServeMux
+	patterns []string
+}
+
+func (t *routeTable) handle(pattern string, h http.Handler) {
+	t.patterns = append(t.patterns, pattern)
+	t.mux.Handle(pattern, h)
+}
+
+func (t *routeTable) handleFunc(pattern string, h http.HandlerFunc) { t.handle(pattern, h) }
+
+// registerRoutes 把全部页面和接口注册到 mux，返回已注册的路由模式
+func registerRoutes(mux *http.ServeMux) []string {
+	t := &routeTable{mux: mux}
+	t.handleFunc("/", handleIndex)
+	t.handleFunc("/history", handleHistory)
+	t.handleFunc("/machines", handleMachines)
+	t.handleFunc("/trash", handleTrash)
+	t.handleFunc("/setup", handleSetup)
+	t.handleFunc("/api/generate", handleAPI)
+	t.handleFunc("/api/generate/batch", handleBatchGenerate)
+	t.handleFunc("/api/verify", verifyLimiter.limit(handleVerify))
+	t.handleFunc("/.well-known/jwks.json", handleJWKS)
+	t.handleFunc("/api/license", handleGetLicense)
+	t.handleFunc("/api/revoke", handleRevokeLicense)
+	t.handleFunc("/api/delete", handleDeleteHistory)
+	t.handleFunc("/api/machines/delete", handleDeleteMachine)
+	t.handleFunc("/api/restore", handleRestore)
+	t.handleFunc("/api/purge", handlePurge)
+	t.handleFunc("/api/backup", handleBackup)
+	t.handleFunc("/users", handleUsers)
+	t.handleFunc("/api/users/create", handleUserSave)
+	t.handleFunc("/api/users/update", handleUserSave)
+	t.handleFunc("/api/users/delete", handleUserDelete)
+	t.handleFunc("/api/password", handleChangePassword)
+	t.handleFunc("/api/me", handleMe)
+	t.handleFunc("/login", loginLimiter.limit(handleLogin))
+	t.handleFunc("/logout", handleLogout)
+	t.handleFunc("/login/2fa", handleLogin2FA)
+	t.handleFunc("/login/oidc", handleOIDCLogin)
+	t.handleFunc("/login/oidc/callback", handleOIDCCallback)
+	t.handleFunc("/2fa", handle2FA)
+	t.handleFunc("/api/2fa/setup", handle2FA)
+	t.handleFunc("/api/2fa/enable", handle2FA)
+	t.handleFunc("/api/2fa/recovery", handle2FA)
+	t.handleFunc("/api/2fa/disable", handle2FA)
+	t.handleFunc("/security", handleSecurity)
+	t.handleFunc("/api/security/unblock", handleSecurity)
+	t.handleFunc("/audit", handleAudit)
+	t.handleFunc("/keys", handleKeysPage)
+	t.handleFunc("/api/keys", handleAPIKeys)
+	t.handleFunc("/api/keys/create", handleAPIKeys)
+	t.handleFunc("/api/keys/revoke", handleAPIKeys)
+	t.handleFunc("/approvals", handleApprovalsPage)
+	t.handleFunc("/api/approvals", handleApprovals)
+	t.handleFunc("/api/approvals/submit", handleApprovals)
+	t.handleFunc("/api/approvals/decide", handleApprovals)
+	t.handleFunc("/api/approvals/result", handleApprovals)
+	t.handleFunc("/quotas", handleQuotasPage)
+	t.handleFunc("/api/quotas", handleQuotas)
+	t.handleFunc("/api/quotas/set", handleQuotas)
+	t.handleFunc("/api/quotas/reset", handleQuotas)
+	registerV2Routes(t)
+	t.handleFunc("/openapi.json", handleOpenAPI)
+
+	t.handleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte("OK"))
+	})
+
+	return t.patterns
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================= S3 兼容存储 =================
+//
+// 只实现备份需要的 PUT / GET / DELETE / ListObjectsV2，使用 path-style 地址 (endpoint/bucket/key)，
+// 请求按 AWS Signature V4 签名，可对接 AWS S3、MinIO、R2 等。
+
+type s3Client struct {
+	endpoint  string // 如 https://s3.amazonaws.com、http://127.0.0.1:9000
+	region    string
+	accessKey string
+	secretKey string
+	bucket    string
+	http      *http.Client
+}
+
+func (c *s3Client) objectURL(key string, query url.Values) string {
+	u := strings.TrimRight(c.endpoint, "/") + "/" + c.bucket
+	if key != "" { u += "/" + s3EscapePath(key) }
+	if len(query) > 0 { u += "?" + s3Query(query) }
+	return u
+}
+
+func (c *s3Client) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.objectURL(key, query), bytes.NewReader(body))
+	if err != nil { return nil, err }
+	c.sign(req, body, time.Now().UTC())
+	resp, err := c.http.Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil { return nil, err }
+	if resp.StatusCode/100 != 2 { return nil, fmt.Errorf("S3 %s %s 返回 %d: %s", method, key, resp.StatusCode, strings.TrimSpace(string(data))) }
+	return data, nil
+}
+
+func (c *s3Client) Put(key string, data []byte) error { _, err := c.do("PUT", key, nil, data); return err }
+func (c *s3Client) Get(key string) ([]byte, error)   { return c.do("GET", key, nil, nil) }
+func (c *s3Client) Delete(key string) error          { _, err := c.do("DELETE", key, nil, nil); return err }
+
+// List 返回指定前缀下的全部对象键，自动处理分页
+func (c *s3Client) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" { q.Set("continuation-token", token) }
+		data, err := c.do("GET", "", q, nil)
+		if err != nil { return nil, err }
+		var res struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(data, &res); err != nil { return nil, fmt.Errorf("解析 S3 列表失败: %v", err) }
+		for _, o := range res.Contents { keys = append(keys, o.Key) }
+		if !res.IsTruncated || res.NextContinuationToken == "" { return keys, nil }
+		token = res.NextContinuationToken
+	}
+}
+
+// sign 按 SigV4 为请求添加 x-amz-date / x-amz-content-sha256 / Authorization
+func (c *s3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + req.URL.Host + "\n" + "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{req.Method, s3EscapePath(req.URL.Path), s3Query(req.URL.Query()), canonicalHeaders, strings.Join(signed, ";"), payloadHash}, "\n")
+
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	for _, part := range []string{c.region, "s3", "aws4_request"} { key = hmacSHA256(key, part) }
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKey, scope, strings.Join(signed, ";"), signature))
+}
+
+func sha256Hex(b []byte) string { h := sha256.Sum256(b); return hex.EncodeToString(h[:]) }
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3Escape 按 SigV4 要求编码：只保留 A-Z a-z 0-9 - _ . ~，其余一律 %XX
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts { parts[i] = s3Escape(parts[i]) }
+	return strings.Join(parts, "/")
+}
+
+// s3Query 生成按键排序的规范查询串，签名和实际请求共用，保证两者一致
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q { keys = append(keys, k) }
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] { parts = append(parts, s3Escape(k)+"="+s3Escape(v)) }
+	}
+	return strings.Join(parts, "&")
+}
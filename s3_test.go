@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 是内存中的 S3 兼容服务：校验 SigV4 签名，支持 PUT / GET / DELETE 和分页的 ListObjectsV2
+type fakeS3 struct {
+	t         *testing.T
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	pageSize  int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	signed  int // 签名校验通过的请求数
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, bucket: "backups", region: "eu-test-1", accessKey: "AKTEST", secretKey: "secret/key+1", pageSize: 2, objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) client(endpoint, secretKey string) *s3Client {
+	return &s3Client{endpoint: endpoint, region: f.region, accessKey: f.accessKey, secretKey: secretKey, bucket: f.bucket, http: http.DefaultClient}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if msg := f.checkSignature(r, body); msg != "" {
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", msg)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket { w.WriteHeader(404); return }
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signed++
+	switch {
+	case r.Method == "PUT" && key != "":
+		f.objects[key] = body
+	case r.Method == "GET" && key == "":
+		f.list(w, r.URL.Query())
+	case r.Method == "GET":
+		data, ok := f.objects[key]
+		if !ok { w.WriteHeader(404); return }
+		w.Write(data)
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	if q.Get("list-type") != "2" { w.WriteHeader(400); return }
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, q.Get("prefix")) { keys = append(keys, k) }
+	}
+	sort.Strings(keys)
+	start := 0
+	if token := q.Get("continuation-token"); token != "" { start = sort.SearchStrings(keys, token) }
+	type content struct{ Key string }
+	res := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	end := start + f.pageSize
+	if end < len(keys) { res.IsTruncated, res.NextContinuationToken = true, keys[end] } else { end = len(keys) }
+	for _, k := range keys[start:end] { res.Contents = append(res.Contents, content{k}) }
+	xml.NewEncoder(w).Encode(res)
+}
+
+// checkSignature 按收到的原始请求独立计算 SigV4 签名，与 Authorization 头比对
+func (f *fakeS3) checkSignature(r *http.Request, body []byte) string {
+	amzDate, payloadHash := r.Header.Get("x-amz-date"), r.Header.Get("x-amz-content-sha256")
+	if len(amzDate) != 16 { return "缺少 x-amz-date" }
+	if payloadHash != sha256Hex(body) { return "x-amz-content-sha256 与请求体不符" }
+
+	scope := amzDate[:8] + "/" + f.region + "/s3/aws4_request"
+	prefix := "AWS4-HMAC-SHA256 Credential=" + f.accessKey + "/" + scope + ", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) { return "Authorization 格式不符: " + auth }
+
+	// 规范查询串：按键排序，空格编码为 %20
+	q := r.URL.Query()
+	names := make([]string, 0, len(q))
+	for k := range q { names = append(names, k) }
+	sort.Strings(names)
+	var pairs []string
+	for _, k := range names {
+		for _, v := range q[k] { pairs = append(pairs, strings.ReplaceAll(url.QueryEscape(k)+"="+url.QueryEscape(v), "+", "%20")) }
+	}
+	headers := "host:" + r.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	canonical := strings.Join([]string{r.Method, r.URL.EscapedPath(), strings.Join(pairs, "&"), headers, "host;x-amz-content-sha256;x-amz-date", payloadHash}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{amzDate[:8], f.region, "s3", "aws4_request"} { key = hmacSHA256(key, part) }
+	if want := hex.EncodeToString(hmacSHA256(key, stringToSign)); strings.TrimPrefix(auth, prefix) != want { return "签名不符" }
+	return ""
+}
+
+func TestS3TargetRoundTrip(t *testing.T) {
+	f, srv := newFakeS3(t)
+	target := s3Target{client: f.client(srv.URL, f.secretKey), prefix: "nightly db/"}
+
+	names := []string{"a.txt", "b c.txt", "d~e+f.txt", "g.txt", "h.txt"}
+	for _, n := range names {
+		if err := target.Put(n, []byte("data "+n)); err != nil { t.Fatalf("上传 %s: %v", n, err) }
+	}
+	if err := target.client.Put("nightly db/sub/x.txt", []byte("x")); err != nil { t.Fatal(err) }
+	if err := target.client.Put("other/y.txt", []byte("y")); err != nil { t.Fatal(err) }
+	if _, ok := f.objects["nightly db/b c.txt"]; !ok { t.Fatalf("对象键编码错误: %v", f.objects) }
+
+	got, err := target.Get("b c.txt")
+	if err != nil || string(got) != "data b c.txt" { t.Fatalf("取回: %q %v", got, err) }
+
+	// 每页 2 个对象，需要跟随 continuation-token；子目录和其他前缀不应出现
+	listed, err := target.List()
+	if err != nil { t.Fatal(err) }
+	sort.Strings(listed)
+	if strings.Join(listed, ",") != strings.Join(names, ",") { t.Fatalf("列表 = %v, 期望 %v", listed, names) }
+
+	if err := target.Delete("a.txt"); err != nil { t.Fatal(err) }
+	if _, err := target.Get("a.txt"); err == nil { t.Fatal("删除后仍能取回") }
+}
+
+func TestS3RejectsBadSignature(t *testing.T) {
+	f, srv := newFakeS3(t)
+	c := f.client(srv.URL, "wrong-secret")
+	err := c.Put("k", []byte("v"))
+	if err == nil || !strings.Contains(err.Error(), "403") { t.Fatalf("错误的密钥应被拒绝, 得到 %v", err) }
+	if len(f.objects) != 0 || f.signed != 0 { t.Fatal("签名错误的请求不应生效") }
+}
+
+func TestRunBackupToS3PrunesOldGenerations(t *testing.T) {
+	f, srv := newFakeS3(t)
+	target := s3Target{client: f.client(srv.URL, f.secretKey), prefix: "prod/"}
+
+	st, err := openStore("json", t.TempDir())
+	if err != nil { t.Fatal(err) }
+	defer st.Close()
+	if err := st.AddLicenses([]HistoryRecord{{ID: newLicenseID(), MachineID: "M-1", ExpiryDate: "2030-01-01", LicenseCode: "code"}}); err != nil { t.Fatal(err) }
+
+	// 预置三代旧备份，其中两代带密钥备份
+	for _, stamp := range []string{"20200101-000000", "20200102-000000", "20200103-000000"} {
+		f.objects["prod/"+backupDataName(stamp)] = []byte("old")
+	}
+	f.objects["prod/"+backupKeyRingName("20200101-000000")] = []byte("old")
+	f.objects["prod/"+backupKeyRingName("20200103-000000")] = []byte("old")
+
+	oldKeep, oldPass := BackupKeep, BackupPassphrase
+	BackupKeep, BackupPassphrase = 2, ""
+	defer func() { BackupKeep, BackupPassphrase = oldKeep, oldPass }()
+
+	name, err := runBackup(st, target)
+	if err != nil { t.Fatal(err) }
+	data, err := decodeBackup(f.objects["prod/"+name])
+	if err != nil || len(data.Licenses) != 1 { t.Fatalf("上传的备份无法解析: %v", err) }
+
+	var keys []string
+	for k := range f.objects { keys = append(keys, k) }
+	sort.Strings(keys)
+	want := []string{"prod/" + backupDataName("20200103-000000"), "prod/" + name, "prod/" + backupKeyRingName("20200103-000000")}
+	sort.Strings(want)
+	if strings.Join(keys, ",") != strings.Join(want, ",") { t.Fatalf("保留的对象 = %v, 期望 %v", keys, want) }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= 登录会话 =================
+//
+// 浏览器通过 /login 登录后获得 HttpOnly、SameSite=Strict 的会话 Cookie，会话只保存在内存中，重启后需重新登录。
+// 每个会话带一个 CSRF Token，通过非 HttpOnly 的 ls_csrf Cookie 交给页面脚本；以会话身份发起的非 GET 请求
+// 必须在 X-CSRF-Token 请求头 (或表单字段 csrf_token) 中带上它。API Key 和 Basic 认证不依赖 Cookie，无需 CSRF。
+// 启用了两步验证的用户密码通过后先得到一个待验证 (Pending) 会话，只能用于 /login/2fa。
+
+const (
+	sessionCookie = "ls_session"
+	csrfCookie    = "ls_csrf"
+
+	maxSecondFactorAttempts = 5 // 待验证会话允许输错验证码的次数
+)
+
+var (
+	SessionIdleTimeout = getEnvDuration("SESSION_IDLE_TIMEOUT", 30*time.Minute) // 无操作超时
+	SessionMaxAge      = getEnvDuration("SESSION_MAX_AGE", 12*time.Hour)        // 绝对超时
+	SessionSecure      = os.Getenv("SESSION_COOKIE_SECURE") == "true"          // 经 HTTPS 反向代理部署时开启
+)
+
+type session struct {
+	Username string
+	CSRF     string
+	Created  time.Time
+	LastSeen time.Time
+	Pending  bool      // 密码已通过，等待第二因素
+	Attempts int       // 第二因素输错次数
+	StepUpAt time.Time // 最近一次验证第二因素 (或重新输入密码) 的时间
+}
+
+var sessions = struct {
+	sync.Mutex
+	m map[string]*session
+}{m: map[string]*session{}}
+
+// pageScript 注入到每个页面：为非 GET 的 fetch 请求自动附带 CSRF Token；遇到需要二次验证的 401 时提示输入并重试一次
+const pageScript = `<script>(function(){var f=window.fetch;window.fetch=async function(u,o){o=o||{};var m=(o.method||'GET').toUpperCase();if(m=='GET'||m=='HEAD')return f(u,o);
+var t=(document.cookie.match(/(?:^|; )ls_csrf=([^;]*)/)||[])[1]||'';o.headers=Object.assign({},o.headers,{'X-CSRF-Token':t});var res=await f(u,o);var k=res.headers.get('X-Step-Up-Required');
+if(res.status==401&&k=='sso'){if(confirm('该操作需要重新验证身份，是否现在重新登录？'))location='/login/oidc?reauth=1&next='+encodeURIComponent(location.pathname+location.search);return res}
+if(res.status==401&&k){var c=prompt(k=='totp'?'该操作需要再次验证：请输入两步验证码或恢复码':'该操作需要再次验证：请输入当前密码');if(!c)return res;o.headers['X-Step-Up']=encodeURIComponent(c);res=await f(u,o)}return res}})()</script>`
+
+func randomToken() string { b := make([]byte, 32); rand.Read(b); return base64.RawURLEncoding.EncodeToString(b) }
+
+func (s *session) expired(now time.Time) bool {
+	return now.Sub(s.LastSeen) > SessionIdleTimeout || now.Sub(s.Created) > SessionMaxAge
+}
+
+// newSession 创建会话并顺带清理已过期的会话
+func newSession(username string) (string, *session) {
+	now := time.Now()
+	id := randomToken()
+	s := &session{Username: username, CSRF: randomToken(), Created: now, LastSeen: now}
+	sessions.Lock()
+	for k, v := range sessions.m {
+		if v.expired(now) { delete(sessions.m, k) }
+	}
+	sessions.m[id] = s
+	sessions.Unlock()
+	return id, s
+}
+
+// lookupSession 查找未过期的会话并刷新最后活动时间
+func lookupSession(r *http.Request) *session {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" { return nil }
+	now := time.Now()
+	sessions.Lock()
+	defer sessions.Unlock()
+	s := sessions.m[c.Value]
+	if s == nil { return nil }
+	if s.expired(now) { delete(sessions.m, c.Value); return nil }
+	s.LastSeen = now
+	return s
+}
+
+// dropSessions 注销某个用户的全部会话 (keep 除外)，用于修改、重置密码
+func dropSessions(username string, keep *session) {
+	sessions.Lock()
+	for k, v := range sessions.m {
+		if v.Username == username && v != keep { delete(sessions.m, k) }
+	}
+	sessions.Unlock()
+}
+
+// checkCSRF 校验以会话身份发起的修改类请求
+func checkCSRF(r *http.Request, s *session) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" { token = r.PostFormValue("csrf_token") }
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRF)) == 1
+}
+
+func setSessionCookies(w http.ResponseWriter, r *http.Request, id, csrf string, maxAge int) {
+	secure := SessionSecure || r.TLS != nil
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", MaxAge: maxAge, HttpOnly: true, Secure: secure, SameSite: http.SameSiteStrictMode})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: csrf, Path: "/", MaxAge: maxAge, Secure: secure, SameSite: http.SameSiteStrictMode})
+}
+
+// loginRedirect 未登录的浏览器页面请求跳转到登录页，返回 false 表示应按接口返回 401
+func loginRedirect(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "GET" || !strings.Contains(r.Header.Get("Accept"), "text/html") { return false }
+	http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	return true
+}
+
+// safeNext 只允许跳回本站路径，防止开放重定向
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") { return "/" }
+	return next
+}
+
+// handleLogin GET 显示登录页，POST 校验用户名密码并建立会话
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	msg := ""
+	if r.Method == "POST" {
+		// 登录表单没有会话可绑定 CSRF Token，改为校验来源，防止被第三方页面提交
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host { http.Error(w, "来源不合法", 403); return }
+		}
+		username, ip := normalizeUsername(r.PostFormValue("username")), clientIP(r)
+		if wait := authLockedFor(ip, username); wait > 0 { tooManyAttempts(w, wait); return }
+		u, err := getUser(store, username)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if u != nil && !u.Disabled && checkPasswordCached(u, r.PostFormValue("password")) {
+			id, s := newSession(u.Username)
+			if u.TOTPSecret != "" {
+				s.Pending = true
+				setSessionCookies(w, r, id, s.CSRF, int(SessionMaxAge.Seconds()))
+				http.Redirect(w, r, "/login/2fa?next="+url.QueryEscape(next), http.StatusSeeOther)
+				return
+			}
+			setSessionCookies(w, r, id, s.CSRF, int(SessionMaxAge.Seconds()))
+			authSucceeded(u.Username)
+			audit(r, &Principal{Name: u.Username, Role: u.Role}, "login", u.Username, "password")
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		authFailed(ip, username)
+		audit(r, nil, "login.failed", username, "")
+		msg = "用户名或密码错误"
+		w.WriteHeader(401)
+	}
+
+	sso := ""
+	if oidcEnabled() { sso = fmt.Sprintf(`<p style="text-align:center;margin-top:20px"><a href="/login/oidc?next=%s" style="color:#0071e3;text-decoration:none">使用公司账号登录 →</a></p>`, url.QueryEscape(next)) }
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>登录</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:360px;margin:80px auto;padding:20px;background:#f5f5f7}.card{background:white;padding:30px;border-radius:12px;box-shadow:0 4px 12px rgba(0,0,0,0.1)}input{width:100%%;padding:10px;margin:5px 0 15px;box-sizing:border-box;border:1px solid #ccc;border-radius:6px}button{width:100%%;padding:12px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}.err{color:#c00;font-size:14px}</style></head><body>
+	<div class="card"><h2>🔐 登录</h2><form method="POST" action="/login"><input type="hidden" name="next" value="%s">
+	<label>用户名</label><input name="username" autocomplete="username" autofocus><label>密码</label><input name="password" type="password" autocomplete="current-password">
+	<p class="err">%s</p><button type="submit">登录</button></form>%s</div></body></html>`, html.EscapeString(next), msg, sso)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// handleLogout 注销当前会话，需要 CSRF Token
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	c, err := r.Cookie(sessionCookie)
+	if err == nil {
+		if s := lookupSession(r); s != nil {
+			if !checkCSRF(r, s) { http.Error(w, "CSRF 校验失败", 403); return }
+			sessions.Lock()
+			delete(sessions.m, c.Value)
+			sessions.Unlock()
+			audit(r, &Principal{Name: s.Username}, "logout", s.Username, "")
+		}
+	}
+	setSessionCookies(w, r, "", "", -1)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
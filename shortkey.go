@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// ================= 短激活码签发 =================
+
+// loadShortKeySigner 读取短激活码使用的 Ed25519 私钥：优先本地 ed25519.pem，其次环境变量 ED25519_PRIVATE_KEY
+func loadShortKeySigner() (ed25519.PrivateKey, error) {
+	rawKey, err := os.ReadFile("ed25519.pem")
+	if err != nil { rawKey = []byte(os.Getenv("ED25519_PRIVATE_KEY")) }
+	if len(rawKey) == 0 { return nil, fmt.Errorf("❌ 未找到 Ed25519 私钥，请先在 /setup 生成") }
+
+	block, _ := pem.Decode(rawKey)
+	if block == nil { return nil, fmt.Errorf("Ed25519 私钥解析失败") }
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil { return nil, fmt.Errorf("Ed25519 私钥格式错误: %v", err) }
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok { return nil, fmt.Errorf("不是 Ed25519 私钥") }
+	return priv, nil
+}
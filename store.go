@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ================= 存储层 =================
+
+// Store 是持久化接口。激活码记录与机器码有专门的方法，
+// 其他实体 (用户、API Key 等) 统一以 kind + id 的 JSON 文档保存，新增实体不需要改动各个实现。
+type Store interface {
+	// AddLicenses 在同一事务中追加记录 (GenerateTime 为空时填入当前时间)，并刷新对应机器码的最后生成时间，
+	// 机器码在回收站中时一并恢复
+	AddLicenses(recs []HistoryRecord) error
+	// ListLicenses 返回全部记录，按生成顺序 (旧 → 新)
+	ListLicenses() ([]HistoryRecord, error)
+	// LicensePage 按新 → 旧分页，同时返回符合条件的总数
+	LicensePage(f LicenseFilter, offset, limit int) ([]HistoryRecord, int, error)
+	// GetLicense 按 ID 查找，不存在时返回 nil
+	GetLicense(id string) (*HistoryRecord, error)
+	// DeleteLicense 物理删除记录，页面上的删除只是移入回收站，见 trash.go
+	DeleteLicense(id string) (bool, error)
+	// UpdateLicense 在同一事务中读出记录、交给 fn 修改后写回 (ID 不可修改)
+	UpdateLicense(id string, fn func(*HistoryRecord)) (bool, error)
+	// LicensesByMachine 返回某台机器的全部记录 (旧 → 新)
+	LicensesByMachine(machineID string) ([]HistoryRecord, error)
+	// LicensesByCode 按激活码原文查找 (旧 → 新)，用于校验不含 ID 的短激活码和旧版标准激活码
+	LicensesByCode(code string) ([]HistoryRecord, error)
+	// LicensesExpiringBetween 返回到期日期在 [from, to] 内的记录 (yyyy-mm-dd，按到期日期排序)
+	LicensesExpiringBetween(from, to string) ([]HistoryRecord, error)
+
+	// ListMachines 返回全部机器码 (含回收站中的)，按首次出现顺序
+	ListMachines() ([]MachineRecord, error)
+	DeleteMachine(machineID string) (bool, error)
+	UpdateMachine(machineID string, fn func(*MachineRecord)) (bool, error)
+
+	PutDoc(kind, id string, v interface{}) error
+	GetDoc(kind, id string, v interface{}) (bool, error)
+	// ListDocs 按 id 升序返回某类文档
+	ListDocs(kind string) ([]Doc, error)
+	DeleteDoc(kind, id string) (bool, error)
+
+	// ImportData 写入迁移/导入的数据：记录原样追加 (不补时间)，机器码按给定内容覆盖，不根据记录自动生成
+	ImportData(recs []HistoryRecord, machines []MachineRecord) error
+
+	// Backup 把某一时刻的一致性快照写入 w，格式见 backupFileName
+	Backup(w io.Writer) error
+	Close() error
+}
+
+// Doc 是以文档形式保存的实体，Body 为原始 JSON
+type Doc struct {
+	ID   string          `json:"id"`
+	Body json.RawMessage `json:"body"`
+}
+
+// 历史记录的视图：当前 (未删除、未归档) / 归档 / 回收站 / 全部
+const (
+	ViewActive  = "active"
+	ViewArchive = "archive"
+	ViewTrash   = "trash"
+	ViewAll     = "all"
+)
+
+// LicenseFilter 是分页查询条件。View 为空等同 ViewActive；Query 不为空时按机器码、客户、产品、ID 做不区分大小写的包含匹配
+type LicenseFilter struct {
+	View  string
+	Query string
+}
+
+// licenseView 返回记录所在的视图，回收站优先于归档
+func licenseView(rec HistoryRecord) string {
+	if rec.DeletedAt != "" { return ViewTrash }
+	if rec.ArchivedAt != "" { return ViewArchive }
+	return ViewActive
+}
+
+func (f LicenseFilter) match(rec HistoryRecord) bool {
+	view := f.View
+	if view == "" { view = ViewActive }
+	if view != ViewAll && view != licenseView(rec) { return false }
+	if f.Query == "" { return true }
+	q := strings.ToLower(f.Query)
+	for _, field := range []string{rec.MachineID, rec.Customer, rec.Product, rec.ID} {
+		if strings.Contains(strings.ToLower(field), q) { return true }
+	}
+	return false
+}
+
+var (
+	StoreBackend = getEnv("STORE_BACKEND", "json")
+	StorePath    = getEnv("STORE_PATH", "")
+)
+
+var store Store
+
+// docKinds 登记所有以文档形式保存的实体类型，备份、导出时据此遍历
+var docKinds = []string{userDocKind, apiKeyDocKind, approvalDocKind, quotaDocKind, quotaUsageDocKind}
+
+// openStore 根据 STORE_BACKEND 打开存储：json (默认，数据在工作目录) / sqlite (STORE_PATH，默认 license.db) /
+// bolt (STORE_PATH，默认 license.bolt)
+func openStore(backend, path string) (Store, error) {
+	if path == "" { path = defaultStorePath(backend) }
+	switch backend {
+	case "", "json":
+		return openJSONStore(path)
+	case "sqlite":
+		return openSQLiteStore(path)
+	case "bolt":
+		return openBoltStore(path)
+	}
+	return nil, fmt.Errorf("未知的存储类型: %s", backend)
+}
+
+func defaultStorePath(backend string) string {
+	switch backend {
+	case "sqlite":
+		return "license.db"
+	case "bolt":
+		return "license.bolt"
+	}
+	return "."
+}
+
+// backupFileName 返回备份文件名：json 后端为 zip 包，sqlite/bolt 为可直接替换使用的数据库文件
+func backupFileName(backend string) string {
+	stamp := time.Now().Format("20060102-150405")
+	switch backend {
+	case "sqlite":
+		return "backup-" + stamp + ".db"
+	case "bolt":
+		return "backup-" + stamp + ".bolt"
+	}
+	return "backup-" + stamp + ".zip"
+}
+
+// handleBackup 在线备份：流式返回当前存储的一致性快照
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, PermBackup, "")
+	if p == nil { return }
+	audit(r, p, "backup.download", "", "")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, backupFileName(StoreBackend)))
+	if err := store.Backup(w); err != nil { log.Printf("❌ 在线备份失败: %v", err) }
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ================= bbolt 存储 =================
+//
+// 单文件嵌入式 KV，适合 Docker/Procfile 这类不想额外部署数据库的场景。桶结构：
+//
+//	licenses            seq(8 字节大端) → HistoryRecord JSON
+//	machines            机器码 → boltMachine JSON
+//	idx_license_machine 机器码 \x00 seq → 空
+//	idx_license_expiry  到期日期 \x00 seq → 空
+//	idx_license_id      激活码 ID → seq
+//	idx_license_code    激活码原文 \x00 seq → 空 (确定性签名的短激活码可能重复)
+//	docs                子桶 <kind>：id → 文档 JSON
+//	meta                count_<视图> → 该视图的记录数 (避免分页时遍历整个桶计数)
+
+var (
+	bktLicenses   = []byte("licenses")
+	bktMachines   = []byte("machines")
+	bktIdxMachine = []byte("idx_license_machine")
+	bktIdxExpiry  = []byte("idx_license_expiry")
+	bktIdxID      = []byte("idx_license_id")
+	bktIdxCode    = []byte("idx_license_code")
+	bktDocs       = []byte("docs")
+	bktMeta       = []byte("meta")
+)
+
+var licenseViews = []string{ViewActive, ViewArchive, ViewTrash}
+
+// boltMachine 额外保存首次出现的序号，用于保持与其他实现一致的排序
+type boltMachine struct {
+	MachineRecord
+	Order uint64 `json:"order"`
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil { return nil, err }
+	err = db.Update(func(tx *bolt.Tx) error {
+		newCodeIndex := tx.Bucket(bktIdxCode) == nil
+		for _, name := range [][]byte{bktLicenses, bktMachines, bktIdxMachine, bktIdxExpiry, bktIdxID, bktIdxCode, bktDocs, bktMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil { return err }
+		}
+		if err := backfillLicenseIDs(tx); err != nil { return err }
+		if newCodeIndex {
+			if err := buildCodeIndex(tx); err != nil { return err }
+		}
+		if tx.Bucket(bktMeta).Get(viewCountKey(ViewActive)) == nil { return recountLicenses(tx) }
+		return nil
+	})
+	if err != nil { db.Close(); return nil, err }
+	log.Printf(">>> 已打开 bbolt 数据库: %s", path)
+	return &boltStore{db: db}, nil
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+func indexKey(value string, seq []byte) []byte {
+	return append(append([]byte(value), 0), seq...)
+}
+
+func viewCountKey(view string) []byte { return []byte("count_" + view) }
+
+func viewCount(tx *bolt.Tx, view string) int {
+	if v := tx.Bucket(bktMeta).Get(viewCountKey(view)); v != nil { return int(binary.BigEndian.Uint64(v)) }
+	return 0
+}
+
+// addViewCount 调整某个视图的记录数，与记录本身在同一事务中更新
+func addViewCount(tx *bolt.Tx, view string, delta int) error {
+	return tx.Bucket(bktMeta).Put(viewCountKey(view), seqKey(uint64(viewCount(tx, view)+delta)))
+}
+
+// recountLicenses 遍历全部记录重建各视图的计数
+func recountLicenses(tx *bolt.Tx) error {
+	counts := map[string]int{}
+	err := tx.Bucket(bktLicenses).ForEach(func(k, v []byte) error {
+		var rec HistoryRecord
+		if err := json.Unmarshal(v, &rec); err != nil { return err }
+		counts[licenseView(rec)]++
+		return nil
+	})
+	if err != nil { return err }
+	mb := tx.Bucket(bktMeta)
+	for _, view := range licenseViews {
+		if err := mb.Put(viewCountKey(view), seqKey(uint64(counts[view]))); err != nil { return err }
+	}
+	return nil
+}
+
+// backfillLicenseIDs 为旧版本写入的记录补齐 ID、状态和 ID 索引
+func backfillLicenseIDs(tx *bolt.Tx) error {
+	lb, ib := tx.Bucket(bktLicenses), tx.Bucket(bktIdxID)
+	updates := map[string]HistoryRecord{}
+	err := lb.ForEach(func(k, v []byte) error {
+		var rec HistoryRecord
+		if err := json.Unmarshal(v, &rec); err != nil { return err }
+		if fillLegacyFields(&rec, int(binary.BigEndian.Uint64(k))) || ib.Get([]byte(rec.ID)) == nil { updates[string(k)] = rec }
+		return nil
+	})
+	if err != nil || len(updates) == 0 { return err }
+	for k, rec := range updates {
+		body, err := json.Marshal(rec)
+		if err != nil { return err }
+		if err := lb.Put([]byte(k), body); err != nil { return err }
+		if err := ib.Put([]byte(rec.ID), []byte(k)); err != nil { return err }
+	}
+	log.Printf(">>> 已为 %d 条旧记录补充 ID", len(updates))
+	return nil
+}
+
+// buildCodeIndex 为旧版本写入的记录建立激活码索引
+func buildCodeIndex(tx *bolt.Tx) error {
+	ib := tx.Bucket(bktIdxCode)
+	return tx.Bucket(bktLicenses).ForEach(func(k, v []byte) error {
+		var rec HistoryRecord
+		if err := json.Unmarshal(v, &rec); err != nil { return err }
+		return ib.Put(indexKey(rec.LicenseCode, k), nil)
+	})
+}
+
+// putLicense 追加一条记录并写入各索引
+func putLicense(tx *bolt.Tx, rec HistoryRecord) error {
+	lb := tx.Bucket(bktLicenses)
+	seq, err := lb.NextSequence()
+	if err != nil { return err }
+	body, err := json.Marshal(rec)
+	if err != nil { return err }
+	key := seqKey(seq)
+	if err := lb.Put(key, body); err != nil { return err }
+	if err := tx.Bucket(bktIdxMachine).Put(indexKey(rec.MachineID, key), nil); err != nil { return err }
+	if err := tx.Bucket(bktIdxID).Put([]byte(rec.ID), key); err != nil { return err }
+	if err := tx.Bucket(bktIdxExpiry).Put(indexKey(rec.ExpiryDate, key), nil); err != nil { return err }
+	if err := tx.Bucket(bktIdxCode).Put(indexKey(rec.LicenseCode, key), nil); err != nil { return err }
+	return addViewCount(tx, licenseView(rec), 1)
+}
+
+// putMachine 写入机器码，已存在时保留原来的排序序号
+func putMachine(tx *bolt.Tx, rec MachineRecord) error {
+	mb := tx.Bucket(bktMachines)
+	m := boltMachine{MachineRecord: rec}
+	if old := mb.Get([]byte(rec.MachineID)); old != nil {
+		var prev boltMachine
+		if err := json.Unmarshal(old, &prev); err != nil { return err }
+		m.Order = prev.Order
+	} else {
+		var err error
+		if m.Order, err = mb.NextSequence(); err != nil { return err }
+	}
+	body, err := json.Marshal(m)
+	if err != nil { return err }
+	return mb.Put([]byte(rec.MachineID), body)
+}
+
+func (s *boltStore) AddLicenses(recs []HistoryRecord) error {
+	nowStr := time.Now().Format("2006-01-02 15:04:05")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range recs {
+			if rec.GenerateTime == "" { rec.GenerateTime = nowStr }
+			if err := putLicense(tx, rec); err != nil { return err }
+			if err := putMachine(tx, MachineRecord{MachineID: rec.MachineID, LastSeen: rec.GenerateTime}); err != nil { return err }
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) ImportData(recs []HistoryRecord, machines []MachineRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range recs {
+			if err := putLicense(tx, rec); err != nil { return err }
+		}
+		for _, m := range machines {
+			if err := putMachine(tx, m); err != nil { return err }
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) ListLicenses() ([]HistoryRecord, error) {
+	var out []HistoryRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bktLicenses).ForEach(func(k, v []byte) error {
+			var rec HistoryRecord
+			if err := json.Unmarshal(v, &rec); err != nil { return err }
+			out = append(out, rec)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// LicensePage 没有搜索词时总数取自计数，取满一页即停；有搜索词时需要遍历全部记录统计总数
+func (s *boltStore) LicensePage(f LicenseFilter, offset, limit int) ([]HistoryRecord, int, error) {
+	var out []HistoryRecord
+	total := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bktLicenses).Cursor()
+		matched := 0
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if f.Query == "" && len(out) >= limit { break }
+			var rec HistoryRecord
+			if err := json.Unmarshal(v, &rec); err != nil { return err }
+			if !f.match(rec) { continue }
+			if matched >= offset && len(out) < limit { out = append(out, rec) }
+			matched++
+		}
+		if f.Query != "" { total = matched; return nil }
+		for _, view := range licenseViews {
+			if f.View == view || f.View == ViewAll || f.View == "" && view == ViewActive { total += viewCount(tx, view) }
+		}
+		return nil
+	})
+	return out, total, err
+}
+
+// getLicense 通过 ID 索引取出记录及其序号，不存在时 key 为 nil
+func getLicense(tx *bolt.Tx, id string) (key []byte, rec HistoryRecord, err error) {
+	if id == "" { return nil, rec, nil }
+	key = tx.Bucket(bktIdxID).Get([]byte(id))
+	if key == nil { return nil, rec, nil }
+	v := tx.Bucket(bktLicenses).Get(key)
+	if v == nil { return nil, rec, nil }
+	return append([]byte(nil), key...), rec, json.Unmarshal(v, &rec)
+}
+
+func (s *boltStore) GetLicense(id string) (*HistoryRecord, error) {
+	var out *HistoryRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, rec, err := getLicense(tx, id)
+		if key != nil { out = &rec }
+		return err
+	})
+	return out, err
+}
+
+func (s *boltStore) DeleteLicense(id string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key, rec, err := getLicense(tx, id)
+		if key == nil || err != nil { return err }
+		if err := tx.Bucket(bktLicenses).Delete(key); err != nil { return err }
+		if err := tx.Bucket(bktIdxMachine).Delete(indexKey(rec.MachineID, key)); err != nil { return err }
+		if err := tx.Bucket(bktIdxExpiry).Delete(indexKey(rec.ExpiryDate, key)); err != nil { return err }
+		if err := tx.Bucket(bktIdxCode).Delete(indexKey(rec.LicenseCode, key)); err != nil { return err }
+		if err := tx.Bucket(bktIdxID).Delete([]byte(id)); err != nil { return err }
+		found = true
+		return addViewCount(tx, licenseView(rec), -1)
+	})
+	return found, err
+}
+
+// UpdateLicense 写回修改后的记录，机器码、到期日期、激活码或所在视图变化时同步更新索引和计数
+func (s *boltStore) UpdateLicense(id string, fn func(*HistoryRecord)) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key, old, err := getLicense(tx, id)
+		if key == nil || err != nil { return err }
+		rec := old
+		fn(&rec)
+		rec.ID = id
+		body, err := json.Marshal(rec)
+		if err != nil { return err }
+		if err := tx.Bucket(bktLicenses).Put(key, body); err != nil { return err }
+		found = true
+		for _, idx := range []struct {
+			bucket   []byte
+			old, new string
+		}{{bktIdxMachine, old.MachineID, rec.MachineID}, {bktIdxExpiry, old.ExpiryDate, rec.ExpiryDate}, {bktIdxCode, old.LicenseCode, rec.LicenseCode}} {
+			if idx.old == idx.new { continue }
+			if err := tx.Bucket(idx.bucket).Delete(indexKey(idx.old, key)); err != nil { return err }
+			if err := tx.Bucket(idx.bucket).Put(indexKey(idx.new, key), nil); err != nil { return err }
+		}
+		if from, to := licenseView(old), licenseView(rec); from != to {
+			if err := addViewCount(tx, from, -1); err != nil { return err }
+			return addViewCount(tx, to, 1)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// lookupIndex 在索引桶中按 [from, to] 前缀范围取出对应的激活码记录
+func (s *boltStore) lookupIndex(bucket []byte, from, to string) ([]HistoryRecord, error) {
+	var out []HistoryRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		lb := tx.Bucket(bktLicenses)
+		c := tx.Bucket(bucket).Cursor()
+		start := append([]byte(from), 0)
+		for k, _ := c.Seek(start); k != nil; k, _ = c.Next() {
+			// 键的末尾固定是 \x00 + 8 字节序号，序号本身可能含 0，不能按分隔符查找
+			if len(k) < 9 { continue }
+			if string(k[:len(k)-9]) > to { break }
+			v := lb.Get(k[len(k)-8:])
+			if v == nil { continue }
+			var rec HistoryRecord
+			if err := json.Unmarshal(v, &rec); err != nil { return err }
+			out = append(out, rec)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *boltStore) LicensesByMachine(machineID string) ([]HistoryRecord, error) {
+	return s.lookupIndex(bktIdxMachine, machineID, machineID)
+}
+
+func (s *boltStore) LicensesByCode(code string) ([]HistoryRecord, error) {
+	return s.lookupIndex(bktIdxCode, code, code)
+}
+
+func (s *boltStore) LicensesExpiringBetween(from, to string) ([]HistoryRecord, error) {
+	return s.lookupIndex(bktIdxExpiry, from, to)
+}
+
+func (s *boltStore) ListMachines() ([]MachineRecord, error) {
+	var list []boltMachine
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bktMachines).ForEach(func(k, v []byte) error {
+			var m boltMachine
+			if err := json.Unmarshal(v, &m); err != nil { return err }
+			list = append(list, m)
+			return nil
+		})
+	})
+	if err != nil { return nil, err }
+	sort.Slice(list, func(i, j int) bool { return list[i].Order < list[j].Order })
+	out := make([]MachineRecord, len(list))
+	for i, m := range list { out[i] = m.MachineRecord }
+	return out, nil
+}
+
+func (s *boltStore) DeleteMachine(machineID string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktMachines)
+		if b.Get([]byte(machineID)) == nil { return nil }
+		found = true
+		return b.Delete([]byte(machineID))
+	})
+	return found, err
+}
+
+func (s *boltStore) UpdateMachine(machineID string, fn func(*MachineRecord)) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktMachines)
+		v := b.Get([]byte(machineID))
+		if v == nil { return nil }
+		var m boltMachine
+		if err := json.Unmarshal(v, &m); err != nil { return err }
+		fn(&m.MachineRecord)
+		m.MachineID = machineID
+		body, err := json.Marshal(m)
+		if err != nil { return err }
+		found = true
+		return b.Put([]byte(machineID), body)
+	})
+	return found, err
+}
+
+func (s *boltStore) PutDoc(kind, id string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil { return err }
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bktDocs).CreateBucketIfNotExists([]byte(kind))
+		if err != nil { return err }
+		return b.Put([]byte(id), body)
+	})
+}
+
+func (s *boltStore) GetDoc(kind, id string, v interface{}) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktDocs).Bucket([]byte(kind))
+		if b == nil { return nil }
+		body := b.Get([]byte(id))
+		if body == nil { return nil }
+		found = true
+		return json.Unmarshal(body, v)
+	})
+	return found, err
+}
+
+func (s *boltStore) ListDocs(kind string) ([]Doc, error) {
+	var out []Doc
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktDocs).Bucket([]byte(kind))
+		if b == nil { return nil }
+		return b.ForEach(func(k, v []byte) error {
+			out = append(out, Doc{ID: string(k), Body: append(json.RawMessage(nil), v...)})
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *boltStore) DeleteDoc(kind, id string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktDocs).Bucket([]byte(kind))
+		if b == nil || b.Get([]byte(id)) == nil { return nil }
+		found = true
+		return b.Delete([]byte(id))
+	})
+	return found, err
+}
+
+// Backup 在只读事务中输出整个数据库文件，期间写入不受影响，得到的是事务开始时刻的一致性快照
+func (s *boltStore) Backup(w io.Writer) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+func (s *boltStore) Close() error {
+	if err := s.db.Close(); err != nil { return fmt.Errorf("关闭 bbolt 失败: %v", err) }
+	return nil
+}
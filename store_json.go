@@ -0,0 +1,523 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================= JSON 文件存储 =================
+//
+// 数据常驻内存。每次修改先以一行 JSON 追加到 journal.jsonl 并 fsync，成功后再改内存；
+// 快照文件 (history.json、machines.json、<kind>.json) 只在压缩时重写：
+//
+//	1. 把有变化的快照写成 *.tmp 并 fsync
+//	2. 原子写入 compact.json，记录本次快照覆盖到的日志序号和文件列表
+//	3. 把 *.tmp 重命名为正式文件
+//	4. 清空日志，删除 compact.json
+//
+// 启动时若发现 compact.json，说明上次压缩中途中断：补做第 3 步，并跳过序号不大于它的日志。
+
+const (
+	journalFile = "journal.jsonl"
+	compactFile = "compact.json"
+)
+
+var (
+	JournalCompactEvery    = getEnvInt("JOURNAL_COMPACT_EVERY", 500)
+	JournalCompactInterval = getEnvDuration("JOURNAL_COMPACT_INTERVAL", 10*time.Minute)
+)
+
+// journalEntry 是一条修改记录，重放时必须得到与首次执行完全相同的结果
+type journalEntry struct {
+	Seq       int64           `json:"seq"`
+	Op        string          `json:"op"`
+	Licenses  []HistoryRecord `json:"licenses,omitempty"`
+	Machines  []MachineRecord `json:"machines,omitempty"`
+	MachineID string          `json:"machine_id,omitempty"`
+	Kind      string          `json:"kind,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+}
+
+type compactState struct {
+	Seq   int64    `json:"seq"`
+	Files []string `json:"files"`
+}
+
+type jsonStore struct {
+	mu          sync.Mutex
+	dir         string
+	historyList []HistoryRecord
+	machineList []MachineRecord
+	docs        map[string]map[string]json.RawMessage
+
+	journal     *os.File
+	journalSize int64
+	seq         int64
+	pending     int             // 上次压缩后新增的日志条数
+	dirty       map[string]bool // 压缩时需要重写的快照文件
+	stop        chan struct{}
+}
+
+func openJSONStore(dir string) (*jsonStore, error) {
+	s := &jsonStore{dir: dir, docs: map[string]map[string]json.RawMessage{}, dirty: map[string]bool{}, stop: make(chan struct{})}
+	log.Println(">>> 正在加载数据文件...")
+
+	var cs compactState
+	interrupted, err := readJSONFile(s.file(compactFile), &cs)
+	if err != nil { return nil, err }
+	if interrupted {
+		for _, name := range cs.Files {
+			if _, err := os.Stat(s.file(name + ".tmp")); err == nil {
+				if err := os.Rename(s.file(name+".tmp"), s.file(name)); err != nil { return nil, err }
+			}
+		}
+		log.Printf(">>> ⚠️ 检测到上次日志压缩未完成 (序号 %d)，已补齐快照文件", cs.Seq)
+	}
+
+	if _, err := readJSONFile(s.file(historyFile), &s.historyList); err != nil { return nil, err }
+	if _, err := readJSONFile(s.file(machineFile), &s.machineList); err != nil { return nil, err }
+
+	replayed, torn, err := s.replay(cs.Seq)
+	if err != nil { return nil, err }
+	backfilled := 0
+	for i := range s.historyList {
+		if fillLegacyFields(&s.historyList[i], i) { backfilled++ }
+	}
+	if backfilled > 0 {
+		s.dirty[historyFile] = true
+		log.Printf(">>> 已为 %d 条旧记录补充 ID", backfilled)
+	}
+	if s.journal, err = os.OpenFile(s.file(journalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil { return nil, err }
+	if info, err := s.journal.Stat(); err == nil { s.journalSize = info.Size() }
+
+	if replayed > 0 || torn || interrupted || backfilled > 0 {
+		if replayed > 0 || torn || interrupted { log.Printf(">>> 已从日志恢复 %d 条修改", replayed) }
+		if err := s.compactLocked(); err != nil { s.journal.Close(); return nil, fmt.Errorf("日志压缩失败: %v", err) }
+	}
+	go s.compactLoop()
+	log.Printf(">>> 数据加载完成: %d 条记录, %d 台机器", len(s.historyList), len(s.machineList))
+	return s, nil
+}
+
+func (s *jsonStore) file(name string) string { return filepath.Join(s.dir, name) }
+
+// replay 重放序号大于 afterSeq 的日志。末尾缺少换行的残缺记录是追加时崩溃造成的，
+// 该修改从未向调用方确认成功，直接丢弃；中间出现的损坏记录则视为数据损坏，拒绝启动。
+func (s *jsonStore) replay(afterSeq int64) (replayed int, torn bool, err error) {
+	s.seq = afterSeq
+	data, err := os.ReadFile(s.file(journalFile))
+	if os.IsNotExist(err) { return 0, false, nil }
+	if err != nil { return 0, false, err }
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 { continue }
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if i == len(lines)-1 {
+				log.Printf(">>> ⚠️ 日志末尾有一条不完整的记录 (%d 字节)，已丢弃", len(line))
+				return replayed, true, nil
+			}
+			return 0, false, fmt.Errorf("日志 %s 第 %d 行已损坏: %v", journalFile, i+1, err)
+		}
+		if e.Seq <= afterSeq { continue }
+		if s.seq != afterSeq && e.Seq != s.seq+1 { return 0, false, fmt.Errorf("日志 %s 第 %d 行序号不连续 (期望 %d，实际 %d)", journalFile, i+1, s.seq+1, e.Seq) }
+		if err := s.apply(e); err != nil { return 0, false, fmt.Errorf("重放日志第 %d 行失败: %v", i+1, err) }
+		s.seq = e.Seq
+		replayed++
+	}
+	return replayed, false, nil
+}
+
+// apply 把一条日志作用到内存，并标记需要重写的快照
+func (s *jsonStore) apply(e journalEntry) error {
+	switch e.Op {
+	case "add_licenses":
+		s.historyList = append(s.historyList, e.Licenses...)
+		for _, rec := range e.Licenses {
+			found := false
+			for i, m := range s.machineList {
+				if m.MachineID == rec.MachineID { s.machineList[i].LastSeen, s.machineList[i].DeletedAt = rec.GenerateTime, ""; found = true; break }
+			}
+			if !found { s.machineList = append(s.machineList, MachineRecord{MachineID: rec.MachineID, LastSeen: rec.GenerateTime}) }
+		}
+		s.dirty[historyFile], s.dirty[machineFile] = true, true
+	case "import":
+		s.historyList = append(s.historyList, e.Licenses...)
+		for _, m := range e.Machines {
+			found := false
+			for i := range s.machineList {
+				if s.machineList[i].MachineID == m.MachineID { s.machineList[i] = m; found = true; break }
+			}
+			if !found { s.machineList = append(s.machineList, m) }
+		}
+		s.dirty[historyFile], s.dirty[machineFile] = true, true
+	case "delete_license":
+		i := s.licenseIndex(e.ID)
+		if i < 0 { return fmt.Errorf("记录 %s 不存在", e.ID) }
+		s.historyList = append(s.historyList[:i], s.historyList[i+1:]...)
+		s.dirty[historyFile] = true
+	case "put_license":
+		if len(e.Licenses) != 1 { return fmt.Errorf("put_license 需要一条记录") }
+		i := s.licenseIndex(e.Licenses[0].ID)
+		if i < 0 { return fmt.Errorf("记录 %s 不存在", e.Licenses[0].ID) }
+		s.historyList[i] = e.Licenses[0]
+		s.dirty[historyFile] = true
+	case "put_machine":
+		if len(e.Machines) != 1 { return fmt.Errorf("put_machine 需要一条机器码") }
+		i := s.machineIndex(e.Machines[0].MachineID)
+		if i < 0 { return fmt.Errorf("机器码 %s 不存在", e.Machines[0].MachineID) }
+		s.machineList[i] = e.Machines[0]
+		s.dirty[machineFile] = true
+	case "delete_machine":
+		newMachines := make([]MachineRecord, 0, len(s.machineList))
+		for _, m := range s.machineList {
+			if m.MachineID != e.MachineID { newMachines = append(newMachines, m) }
+		}
+		s.machineList = newMachines
+		s.dirty[machineFile] = true
+	case "put_doc", "delete_doc":
+		docs, err := s.kindDocs(e.Kind)
+		if err != nil { return err }
+		if e.Op == "put_doc" { docs[e.ID] = e.Body } else { delete(docs, e.ID) }
+		s.dirty[e.Kind+".json"] = true
+	default:
+		return fmt.Errorf("未知的日志操作: %s", e.Op)
+	}
+	return nil
+}
+
+// commit 先把修改写入日志并落盘，再应用到内存。写日志或应用失败时截掉这条日志，内存保持不变
+// (apply 在修改内存之前完成全部检查)，避免重放时每次启动都在这条日志上失败。
+func (s *jsonStore) commit(e journalEntry) error {
+	e.Seq = s.seq + 1
+	line, err := json.Marshal(e)
+	if err != nil { return err }
+	line = append(line, '\n')
+	if _, err := s.journal.Write(line); err != nil {
+		s.journal.Truncate(s.journalSize)
+		return fmt.Errorf("写入日志失败: %v", err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		s.journal.Truncate(s.journalSize)
+		return fmt.Errorf("日志落盘失败: %v", err)
+	}
+	if err := s.apply(e); err != nil {
+		if terr := s.journal.Truncate(s.journalSize); terr != nil { return fmt.Errorf("%v (撤销日志失败: %v)", err, terr) }
+		s.journal.Sync()
+		return err
+	}
+	s.journalSize += int64(len(line))
+	s.seq = e.Seq
+
+	s.pending++
+	if s.pending >= JournalCompactEvery {
+		if err := s.compactLocked(); err != nil { log.Printf("❌ 日志压缩失败: %v", err) }
+	}
+	return nil
+}
+
+// compactLocked 把有变化的快照写盘并清空日志，步骤见文件头注释
+func (s *jsonStore) compactLocked() error {
+	files := make([]string, 0, len(s.dirty))
+	for name := range s.dirty { files = append(files, name) }
+	sort.Strings(files)
+
+	for _, name := range files {
+		data, err := json.Marshal(s.snapshot(name))
+		if err != nil { return err }
+		if err := writeFileSynced(s.file(name+".tmp"), data); err != nil { return err }
+	}
+	state, _ := json.Marshal(compactState{Seq: s.seq, Files: files})
+	if err := writeFileAtomic(s.file(compactFile), state); err != nil { return err }
+	for _, name := range files {
+		if err := os.Rename(s.file(name+".tmp"), s.file(name)); err != nil { return err }
+	}
+	syncDir(s.dir)
+
+	if err := s.journal.Truncate(0); err != nil { return err }
+	if err := s.journal.Sync(); err != nil { return err }
+	s.journalSize = 0
+	if err := os.Remove(s.file(compactFile)); err != nil { return err }
+	syncDir(s.dir)
+
+	s.dirty = map[string]bool{}
+	s.pending = 0
+	return nil
+}
+
+func (s *jsonStore) snapshot(name string) interface{} {
+	switch name {
+	case historyFile:
+		return s.historyList
+	case machineFile:
+		return s.machineList
+	}
+	return s.docs[strings.TrimSuffix(name, ".json")]
+}
+
+func (s *jsonStore) compactLoop() {
+	ticker := time.NewTicker(JournalCompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.pending > 0 {
+				if err := s.compactLocked(); err != nil { log.Printf("❌ 定时日志压缩失败: %v", err) }
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *jsonStore) AddLicenses(recs []HistoryRecord) error {
+	s.mu.Lock(); defer s.mu.Unlock()
+	nowStr := time.Now().Format("2006-01-02 15:04:05")
+	batch := make([]HistoryRecord, len(recs))
+	for i, rec := range recs {
+		if rec.GenerateTime == "" { rec.GenerateTime = nowStr }
+		batch[i] = rec
+	}
+	return s.commit(journalEntry{Op: "add_licenses", Licenses: batch})
+}
+
+func (s *jsonStore) ListLicenses() ([]HistoryRecord, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	return append([]HistoryRecord(nil), s.historyList...), nil
+}
+
+func (s *jsonStore) LicensePage(f LicenseFilter, offset, limit int) ([]HistoryRecord, int, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	total := 0
+	var rows []HistoryRecord
+	for i := len(s.historyList) - 1; i >= 0; i-- {
+		if !f.match(s.historyList[i]) { continue }
+		if total >= offset && len(rows) < limit { rows = append(rows, s.historyList[i]) }
+		total++
+	}
+	return rows, total, nil
+}
+
+// licenseIndex 返回记录在列表中的位置，不存在时为 -1
+func (s *jsonStore) licenseIndex(id string) int {
+	for i := range s.historyList {
+		if s.historyList[i].ID == id { return i }
+	}
+	return -1
+}
+
+func (s *jsonStore) GetLicense(id string) (*HistoryRecord, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	i := s.licenseIndex(id)
+	if i < 0 { return nil, nil }
+	rec := s.historyList[i]
+	return &rec, nil
+}
+
+func (s *jsonStore) DeleteLicense(id string) (bool, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	if id == "" || s.licenseIndex(id) < 0 { return false, nil }
+	return true, s.commit(journalEntry{Op: "delete_license", ID: id})
+}
+
+func (s *jsonStore) UpdateLicense(id string, fn func(*HistoryRecord)) (bool, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	i := s.licenseIndex(id)
+	if id == "" || i < 0 { return false, nil }
+	rec := s.historyList[i]
+	fn(&rec)
+	rec.ID = id
+	return true, s.commit(journalEntry{Op: "put_license", Licenses: []HistoryRecord{rec}})
+}
+
+func (s *jsonStore) LicensesByMachine(machineID string) ([]HistoryRecord, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	var out []HistoryRecord
+	for _, rec := range s.historyList {
+		if rec.MachineID == machineID { out = append(out, rec) }
+	}
+	return out, nil
+}
+
+func (s *jsonStore) LicensesByCode(code string) ([]HistoryRecord, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	var out []HistoryRecord
+	for _, rec := range s.historyList {
+		if rec.LicenseCode == code { out = append(out, rec) }
+	}
+	return out, nil
+}
+
+func (s *jsonStore) LicensesExpiringBetween(from, to string) ([]HistoryRecord, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	var out []HistoryRecord
+	for _, rec := range s.historyList {
+		if rec.ExpiryDate >= from && rec.ExpiryDate <= to { out = append(out, rec) }
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ExpiryDate < out[j].ExpiryDate })
+	return out, nil
+}
+
+func (s *jsonStore) ListMachines() ([]MachineRecord, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	return append([]MachineRecord(nil), s.machineList...), nil
+}
+
+// machineIndex 返回机器码在列表中的位置，不存在时为 -1
+func (s *jsonStore) machineIndex(machineID string) int {
+	for i := range s.machineList {
+		if s.machineList[i].MachineID == machineID { return i }
+	}
+	return -1
+}
+
+func (s *jsonStore) DeleteMachine(machineID string) (bool, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	if s.machineIndex(machineID) < 0 { return false, nil }
+	return true, s.commit(journalEntry{Op: "delete_machine", MachineID: machineID})
+}
+
+func (s *jsonStore) UpdateMachine(machineID string, fn func(*MachineRecord)) (bool, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	i := s.machineIndex(machineID)
+	if i < 0 { return false, nil }
+	m := s.machineList[i]
+	fn(&m)
+	m.MachineID = machineID
+	return true, s.commit(journalEntry{Op: "put_machine", Machines: []MachineRecord{m}})
+}
+
+// kindDocs 返回某类文档，首次访问时从 <kind>.json 加载
+func (s *jsonStore) kindDocs(kind string) (map[string]json.RawMessage, error) {
+	docs, ok := s.docs[kind]
+	if !ok {
+		docs = map[string]json.RawMessage{}
+		if _, err := readJSONFile(s.file(kind+".json"), &docs); err != nil { return nil, err }
+		s.docs[kind] = docs
+	}
+	return docs, nil
+}
+
+func (s *jsonStore) PutDoc(kind, id string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil { return err }
+	s.mu.Lock(); defer s.mu.Unlock()
+	if _, err := s.kindDocs(kind); err != nil { return err }
+	return s.commit(journalEntry{Op: "put_doc", Kind: kind, ID: id, Body: body})
+}
+
+func (s *jsonStore) GetDoc(kind, id string, v interface{}) (bool, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	docs, err := s.kindDocs(kind)
+	if err != nil { return false, err }
+	body, ok := docs[id]
+	if !ok { return false, nil }
+	return true, json.Unmarshal(body, v)
+}
+
+func (s *jsonStore) ListDocs(kind string) ([]Doc, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	docs, err := s.kindDocs(kind)
+	if err != nil { return nil, err }
+	ids := make([]string, 0, len(docs))
+	for id := range docs { ids = append(ids, id) }
+	sort.Strings(ids)
+	out := make([]Doc, 0, len(ids))
+	for _, id := range ids { out = append(out, Doc{ID: id, Body: docs[id]}) }
+	return out, nil
+}
+
+func (s *jsonStore) DeleteDoc(kind, id string) (bool, error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	docs, err := s.kindDocs(kind)
+	if err != nil { return false, err }
+	if _, ok := docs[id]; !ok { return false, nil }
+	return true, s.commit(journalEntry{Op: "delete_doc", Kind: kind, ID: id})
+}
+
+func (s *jsonStore) ImportData(recs []HistoryRecord, machines []MachineRecord) error {
+	s.mu.Lock(); defer s.mu.Unlock()
+	return s.commit(journalEntry{Op: "import", Licenses: recs, Machines: machines})
+}
+
+// Backup 在锁内把内存中的全部数据打包成 zip，解压到数据目录即可恢复
+func (s *jsonStore) Backup(w io.Writer) error {
+	s.mu.Lock(); defer s.mu.Unlock()
+	names := []string{historyFile, machineFile}
+	for _, kind := range docKinds {
+		if _, err := s.kindDocs(kind); err != nil { return err }
+		names = append(names, kind+".json")
+	}
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := zw.Create(name)
+		if err != nil { return err }
+		if err := json.NewEncoder(f).Encode(s.snapshot(name)); err != nil { return err }
+	}
+	return zw.Close()
+}
+
+// Close 停止定时压缩，把剩余日志合并进快照后关闭
+func (s *jsonStore) Close() error {
+	close(s.stop)
+	s.mu.Lock(); defer s.mu.Unlock()
+	var err error
+	if s.pending > 0 { err = s.compactLocked() }
+	if cerr := s.journal.Close(); err == nil { err = cerr }
+	return err
+}
+
+// ================= 文件工具 =================
+
+// readJSONFile 读取 JSON 文件。文件不存在返回 false；内容为空或无法解析视为损坏并返回错误，避免带着残缺数据启动后覆盖原文件。
+func readJSONFile(path string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) { return false, nil }
+	if err != nil { return false, fmt.Errorf("无法读取 %s: %v", path, err) }
+	if len(bytes.TrimSpace(data)) == 0 { return false, fmt.Errorf("数据文件 %s 为空，可能在写入时中断，请从备份恢复或移走该文件后重启", path) }
+	if err := json.Unmarshal(data, v); err != nil { return false, fmt.Errorf("数据文件 %s 已损坏: %v，请从备份恢复或移走该文件后重启", path, err) }
+	return true, nil
+}
+
+// writeJSONFile 原子写入 JSON 文件
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil { return err }
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic 先写同目录临时文件并 fsync，再 rename 覆盖，保证读到的要么是旧文件、要么是完整的新文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := writeFileSynced(tmp, data); err != nil { os.Remove(tmp); return err }
+	if err := os.Rename(tmp, path); err != nil { os.Remove(tmp); return err }
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+func writeFileSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil { return err }
+	if _, err := f.Write(data); err != nil { f.Close(); return err }
+	if err := f.Sync(); err != nil { f.Close(); return err }
+	return f.Close()
+}
+
+// syncDir 让 rename 本身落盘；部分平台不支持对目录 fsync，忽略错误
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil { d.Sync(); d.Close() }
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"testing"
+)
+
+// crash 模拟进程崩溃：不压缩、不关闭时落盘，只释放后台协程和文件句柄
+func crash(s *jsonStore) {
+	close(s.stop)
+	s.journal.Close()
+}
+
+func openTestJSONStore(t *testing.T, dir string) *jsonStore {
+	s, err := openJSONStore(dir)
+	if err != nil { t.Fatalf("打开存储: %v", err) }
+	return s
+}
+
+// seedJSONStore 写入三条记录、吊销一条并保存一个文档，返回记录 ID
+func seedJSONStore(t *testing.T, s *jsonStore) []string {
+	var ids []string
+	for _, mid := range []string{"M-1", "M-2", "M-3"} {
+		rec := HistoryRecord{ID: newLicenseID(), MachineID: mid, ExpiryDate: "2030-01-01", LicenseCode: "code-" + mid, Status: LicenseActive}
+		if err := s.AddLicenses([]HistoryRecord{rec}); err != nil { t.Fatal(err) }
+		ids = append(ids, rec.ID)
+	}
+	if _, err := s.UpdateLicense(ids[1], func(r *HistoryRecord) { r.Status = LicenseRevoked }); err != nil { t.Fatal(err) }
+	if err := s.PutDoc(quotaDocKind, "alice", map[string]int{"daily_licenses": 3}); err != nil { t.Fatal(err) }
+	return ids
+}
+
+// checkSeeded 确认 seedJSONStore 的修改全部存在且没有被重复应用
+func checkSeeded(t *testing.T, s *jsonStore, ids []string) {
+	t.Helper()
+	recs, _ := s.ListLicenses()
+	if len(recs) != len(ids) { t.Fatalf("记录数 = %d, 期望 %d", len(recs), len(ids)) }
+	for i, rec := range recs {
+		want := LicenseActive
+		if i == 1 { want = LicenseRevoked }
+		if rec.ID != ids[i] || rec.Status != want { t.Fatalf("第 %d 条记录: %+v", i, rec) }
+	}
+	machines, _ := s.ListMachines()
+	if len(machines) != 3 { t.Fatalf("机器码数 = %d", len(machines)) }
+	var q map[string]int
+	if found, err := s.GetDoc(quotaDocKind, "alice", &q); !found || err != nil || q["daily_licenses"] != 3 { t.Fatalf("文档: %v %v %v", found, err, q) }
+}
+
+func TestJSONStoreReplayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJSONStore(t, dir)
+	ids := seedJSONStore(t, s)
+	crash(s)
+	if _, err := os.Stat(s.file(historyFile)); !os.IsNotExist(err) { t.Fatal("崩溃前不应写出快照，本用例需要从日志恢复") }
+
+	s = openTestJSONStore(t, dir)
+	checkSeeded(t, s, ids)
+	if err := s.Close(); err != nil { t.Fatal(err) }
+
+	// 打开时已压缩，再次打开只读快照
+	s = openTestJSONStore(t, dir)
+	defer s.Close()
+	checkSeeded(t, s, ids)
+}
+
+func TestJSONStoreTornJournalTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJSONStore(t, dir)
+	ids := seedJSONStore(t, s)
+	crash(s)
+
+	f, err := os.OpenFile(s.file(journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil { t.Fatal(err) }
+	f.Write([]byte(`{"seq":6,"op":"add_licenses","licenses":[{"id":"01J`))
+	f.Close()
+
+	s = openTestJSONStore(t, dir)
+	checkSeeded(t, s, ids)
+	// 残缺记录丢弃后，新的修改正常追加
+	if err := s.AddLicenses([]HistoryRecord{{ID: newLicenseID(), MachineID: "M-4", LicenseCode: "code-M-4"}}); err != nil { t.Fatal(err) }
+	crash(s)
+
+	s = openTestJSONStore(t, dir)
+	defer s.Close()
+	if recs, _ := s.ListLicenses(); len(recs) != 4 { t.Fatalf("记录数 = %d, 期望 4", len(recs)) }
+}
+
+// 压缩写完 compact.json 后崩溃：快照可能已部分改名，日志尚未清空。重启后不能重复应用日志
+func TestJSONStoreInterruptedCompaction(t *testing.T) {
+	for _, renamed := range []int{0, 1, 3} {
+		dir := t.TempDir()
+		s := openTestJSONStore(t, dir)
+		ids := seedJSONStore(t, s)
+
+		// 按 compactLocked 的步骤执行到第 2 步，再完成前 renamed 个改名
+		var files []string
+		for name := range s.dirty { files = append(files, name) }
+		sort.Strings(files)
+		if len(files) != 3 { t.Fatalf("待写快照: %v", files) }
+		for _, name := range files {
+			data, _ := json.Marshal(s.snapshot(name))
+			if err := writeFileSynced(s.file(name+".tmp"), data); err != nil { t.Fatal(err) }
+		}
+		state, _ := json.Marshal(compactState{Seq: s.seq, Files: files})
+		if err := writeFileAtomic(s.file(compactFile), state); err != nil { t.Fatal(err) }
+		for _, name := range files[:renamed] {
+			if err := os.Rename(s.file(name+".tmp"), s.file(name)); err != nil { t.Fatal(err) }
+		}
+		crash(s)
+
+		s = openTestJSONStore(t, dir)
+		checkSeeded(t, s, ids)
+		if _, err := os.Stat(s.file(compactFile)); !os.IsNotExist(err) { t.Fatalf("已改名 %d 个: compact.json 未清理", renamed) }
+		if info, _ := os.Stat(s.file(journalFile)); info.Size() != 0 { t.Fatalf("已改名 %d 个: 日志未清空", renamed) }
+		s.Close()
+	}
+}
+
+// 应用失败的修改不能留在日志里，否则每次启动重放都会失败
+func TestJSONStoreApplyErrorLeavesNoJournalEntry(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJSONStore(t, dir)
+	ids := seedJSONStore(t, s)
+	s.mu.Lock()
+	err := s.commit(journalEntry{Op: "delete_license", ID: "NOT-FOUND"})
+	s.mu.Unlock()
+	if err == nil { t.Fatal("删除不存在的记录应当失败") }
+	if err := s.AddLicenses([]HistoryRecord{{ID: newLicenseID(), MachineID: "M-1", LicenseCode: "code-M-1b"}}); err != nil { t.Fatal(err) }
+	crash(s)
+
+	s = openTestJSONStore(t, dir)
+	defer s.Close()
+	if recs, _ := s.ListLicenses(); len(recs) != len(ids)+1 { t.Fatalf("记录数 = %d", len(recs)) }
+}
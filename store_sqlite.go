@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ================= SQLite 存储 =================
+
+// sqliteMigrations 按顺序执行，已执行的版本记录在 schema_migrations 表中。只能追加，不能修改已发布的条目。
+var sqliteMigrations = []string{
+	// 1: 初始结构
+	`CREATE TABLE licenses (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		generate_time TEXT NOT NULL,
+		machine_id    TEXT NOT NULL,
+		expiry_date   TEXT NOT NULL,
+		license_code  TEXT NOT NULL,
+		customer      TEXT NOT NULL DEFAULT '',
+		product       TEXT NOT NULL DEFAULT '',
+		format        TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX idx_licenses_machine ON licenses(machine_id);
+	CREATE INDEX idx_licenses_expiry ON licenses(expiry_date);
+	CREATE TABLE machines (
+		machine_id TEXT PRIMARY KEY,
+		last_seen  TEXT NOT NULL
+	);
+	CREATE TABLE docs (
+		kind TEXT NOT NULL,
+		id   TEXT NOT NULL,
+		body TEXT NOT NULL,
+		PRIMARY KEY (kind, id)
+	);`,
+	// 2: 精确到期时间与签名密钥 ID
+	`ALTER TABLE licenses ADD COLUMN expires_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE licenses ADD COLUMN key_id TEXT NOT NULL DEFAULT '';`,
+	// 3: 稳定 ID、签发者、状态、来源 (旧记录的 ID 在打开时补齐)
+	`ALTER TABLE licenses ADD COLUMN license_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE licenses ADD COLUMN issuer TEXT NOT NULL DEFAULT '';
+	ALTER TABLE licenses ADD COLUMN status TEXT NOT NULL DEFAULT '';
+	ALTER TABLE licenses ADD COLUMN source TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX idx_licenses_license_id ON licenses(license_id) WHERE license_id <> '';`,
+	// 4: 回收站与归档
+	`ALTER TABLE licenses ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE licenses ADD COLUMN archived_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE machines ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_licenses_view ON licenses(deleted_at, archived_at);`,
+	// 5: 签发用户
+	`ALTER TABLE licenses ADD COLUMN issued_by TEXT NOT NULL DEFAULT '';`,
+	// 6: 按激活码原文查找
+	`CREATE INDEX idx_licenses_code ON licenses(license_code);`,
+}
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil { return nil, err }
+	db.SetMaxOpenConns(1) // SQLite 单写者，串行化可避免 SQLITE_BUSY
+	s := &sqliteStore{db: db}
+	if err := s.migrate(); err != nil { db.Close(); return nil, fmt.Errorf("数据库迁移失败: %v", err) }
+	if err := s.backfillLicenseIDs(); err != nil { db.Close(); return nil, fmt.Errorf("补充记录 ID 失败: %v", err) }
+	log.Printf(">>> 已打开 SQLite 数据库: %s", path)
+	return s, nil
+}
+
+func (s *sqliteStore) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`); err != nil { return err }
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil { return err }
+	for v := current + 1; v <= len(sqliteMigrations); v++ {
+		tx, err := s.db.Begin()
+		if err != nil { return err }
+		if _, err := tx.Exec(sqliteMigrations[v-1]); err != nil { tx.Rollback(); return fmt.Errorf("版本 %d: %v", v, err) }
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, v, time.Now().Format(time.RFC3339)); err != nil { tx.Rollback(); return err }
+		if err := tx.Commit(); err != nil { return err }
+		log.Printf(">>> 数据库已迁移到版本 %d", v)
+	}
+	return nil
+}
+
+const (
+	licenseColumns = `generate_time, machine_id, expiry_date, license_code, customer, product, format, expires_at, key_id, license_id, issuer, status, source, deleted_at, archived_at, issued_by`
+	insertLicense  = `INSERT INTO licenses (` + licenseColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	updateLicense  = `UPDATE licenses SET (` + licenseColumns + `) = (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) WHERE license_id = ?`
+	upsertMachine  = `INSERT INTO machines (machine_id, last_seen, deleted_at) VALUES (?, ?, ?) ON CONFLICT(machine_id) DO UPDATE SET last_seen = excluded.last_seen, deleted_at = excluded.deleted_at`
+)
+
+func licenseArgs(rec HistoryRecord) []interface{} {
+	return []interface{}{rec.GenerateTime, rec.MachineID, rec.ExpiryDate, rec.LicenseCode, rec.Customer, rec.Product, rec.Format, rec.ExpiresAt, rec.KeyID, rec.ID, rec.Issuer, rec.Status, rec.Source, rec.DeletedAt, rec.ArchivedAt, rec.IssuedBy}
+}
+
+// licenseDest 返回与 licenseColumns 顺序一致的扫描目标
+func licenseDest(r *HistoryRecord) []interface{} {
+	return []interface{}{&r.GenerateTime, &r.MachineID, &r.ExpiryDate, &r.LicenseCode, &r.Customer, &r.Product, &r.Format, &r.ExpiresAt, &r.KeyID, &r.ID, &r.Issuer, &r.Status, &r.Source, &r.DeletedAt, &r.ArchivedAt, &r.IssuedBy}
+}
+
+// licenseWhere 把 LicenseFilter 翻译成 WHERE 子句，语义与 LicenseFilter.match 一致
+func licenseWhere(f LicenseFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	switch f.View {
+	case "", ViewActive:
+		conds = append(conds, `deleted_at = '' AND archived_at = ''`)
+	case ViewArchive:
+		conds = append(conds, `deleted_at = '' AND archived_at <> ''`)
+	case ViewTrash:
+		conds = append(conds, `deleted_at <> ''`)
+	}
+	if f.Query != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Query) + "%"
+		conds = append(conds, `(machine_id LIKE ? ESCAPE '\' OR customer LIKE ? ESCAPE '\' OR product LIKE ? ESCAPE '\' OR license_id LIKE ? ESCAPE '\')`)
+		args = append(args, like, like, like, like)
+	}
+	if len(conds) == 0 { return "", nil }
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// backfillLicenseIDs 为迁移前的旧记录补齐 ID 和状态
+func (s *sqliteStore) backfillLicenseIDs() error {
+	rows, err := s.db.Query(`SELECT id, ` + licenseColumns + ` FROM licenses WHERE license_id = '' OR status = ''`)
+	if err != nil { return err }
+	type legacy struct {
+		rowid int
+		rec   HistoryRecord
+	}
+	var list []legacy
+	for rows.Next() {
+		var l legacy
+		r := &l.rec
+		if err := rows.Scan(append([]interface{}{&l.rowid}, licenseDest(r)...)...); err != nil { rows.Close(); return err }
+		list = append(list, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { return err }
+	if len(list) == 0 { return nil }
+
+	tx, err := s.db.Begin()
+	if err != nil { return err }
+	defer tx.Rollback()
+	for _, l := range list {
+		fillLegacyFields(&l.rec, l.rowid)
+		if _, err := tx.Exec(`UPDATE licenses SET license_id = ?, status = ? WHERE id = ?`, l.rec.ID, l.rec.Status, l.rowid); err != nil { return err }
+	}
+	log.Printf(">>> 已为 %d 条旧记录补充 ID", len(list))
+	return tx.Commit()
+}
+
+func (s *sqliteStore) AddLicenses(recs []HistoryRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil { return err }
+	defer tx.Rollback()
+	nowStr := time.Now().Format("2006-01-02 15:04:05")
+	for _, rec := range recs {
+		if rec.GenerateTime == "" { rec.GenerateTime = nowStr }
+		if _, err := tx.Exec(insertLicense, licenseArgs(rec)...); err != nil { return err }
+		if _, err := tx.Exec(upsertMachine, rec.MachineID, rec.GenerateTime, ""); err != nil { return err }
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) ImportData(recs []HistoryRecord, machines []MachineRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil { return err }
+	defer tx.Rollback()
+	for _, rec := range recs {
+		if _, err := tx.Exec(insertLicense, licenseArgs(rec)...); err != nil { return err }
+	}
+	for _, m := range machines {
+		if _, err := tx.Exec(upsertMachine, m.MachineID, m.LastSeen, m.DeletedAt); err != nil { return err }
+	}
+	return tx.Commit()
+}
+
+func scanLicenses(rows *sql.Rows) ([]HistoryRecord, error) {
+	defer rows.Close()
+	var out []HistoryRecord
+	for rows.Next() {
+		var r HistoryRecord
+		if err := rows.Scan(licenseDest(&r)...); err != nil { return nil, err }
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) ListLicenses() ([]HistoryRecord, error) {
+	rows, err := s.db.Query(`SELECT ` + licenseColumns + ` FROM licenses ORDER BY id`)
+	if err != nil { return nil, err }
+	return scanLicenses(rows)
+}
+
+func (s *sqliteStore) LicensePage(f LicenseFilter, offset, limit int) ([]HistoryRecord, int, error) {
+	where, args := licenseWhere(f)
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM licenses`+where, args...).Scan(&total); err != nil { return nil, 0, err }
+	rows, err := s.db.Query(`SELECT `+licenseColumns+` FROM licenses`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil { return nil, 0, err }
+	recs, err := scanLicenses(rows)
+	return recs, total, err
+}
+
+func (s *sqliteStore) GetLicense(id string) (*HistoryRecord, error) {
+	var r HistoryRecord
+	err := s.db.QueryRow(`SELECT `+licenseColumns+` FROM licenses WHERE license_id = ?`, id).Scan(licenseDest(&r)...)
+	if err == sql.ErrNoRows { return nil, nil }
+	if err != nil { return nil, err }
+	return &r, nil
+}
+
+func (s *sqliteStore) DeleteLicense(id string) (bool, error) {
+	if id == "" { return false, nil }
+	res, err := s.db.Exec(`DELETE FROM licenses WHERE license_id = ?`, id)
+	if err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *sqliteStore) UpdateLicense(id string, fn func(*HistoryRecord)) (bool, error) {
+	if id == "" { return false, nil }
+	tx, err := s.db.Begin()
+	if err != nil { return false, err }
+	defer tx.Rollback()
+	var r HistoryRecord
+	err = tx.QueryRow(`SELECT `+licenseColumns+` FROM licenses WHERE license_id = ?`, id).Scan(licenseDest(&r)...)
+	if err == sql.ErrNoRows { return false, nil }
+	if err != nil { return false, err }
+	fn(&r)
+	r.ID = id
+	if _, err := tx.Exec(updateLicense, append(licenseArgs(r), id)...); err != nil { return false, err }
+	return true, tx.Commit()
+}
+
+func (s *sqliteStore) LicensesByMachine(machineID string) ([]HistoryRecord, error) {
+	rows, err := s.db.Query(`SELECT `+licenseColumns+` FROM licenses WHERE machine_id = ? ORDER BY id`, machineID)
+	if err != nil { return nil, err }
+	return scanLicenses(rows)
+}
+
+func (s *sqliteStore) LicensesByCode(code string) ([]HistoryRecord, error) {
+	rows, err := s.db.Query(`SELECT `+licenseColumns+` FROM licenses WHERE license_code = ? ORDER BY id`, code)
+	if err != nil { return nil, err }
+	return scanLicenses(rows)
+}
+
+func (s *sqliteStore) LicensesExpiringBetween(from, to string) ([]HistoryRecord, error) {
+	rows, err := s.db.Query(`SELECT `+licenseColumns+` FROM licenses WHERE expiry_date BETWEEN ? AND ? ORDER BY expiry_date, id`, from, to)
+	if err != nil { return nil, err }
+	return scanLicenses(rows)
+}
+
+func (s *sqliteStore) ListMachines() ([]MachineRecord, error) {
+	rows, err := s.db.Query(`SELECT machine_id, last_seen, deleted_at FROM machines ORDER BY rowid`)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	var out []MachineRecord
+	for rows.Next() {
+		var m MachineRecord
+		if err := rows.Scan(&m.MachineID, &m.LastSeen, &m.DeletedAt); err != nil { return nil, err }
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) DeleteMachine(machineID string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM machines WHERE machine_id = ?`, machineID)
+	if err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *sqliteStore) UpdateMachine(machineID string, fn func(*MachineRecord)) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil { return false, err }
+	defer tx.Rollback()
+	m := MachineRecord{MachineID: machineID}
+	err = tx.QueryRow(`SELECT last_seen, deleted_at FROM machines WHERE machine_id = ?`, machineID).Scan(&m.LastSeen, &m.DeletedAt)
+	if err == sql.ErrNoRows { return false, nil }
+	if err != nil { return false, err }
+	fn(&m)
+	if _, err := tx.Exec(`UPDATE machines SET last_seen = ?, deleted_at = ? WHERE machine_id = ?`, m.LastSeen, m.DeletedAt, machineID); err != nil { return false, err }
+	return true, tx.Commit()
+}
+
+func (s *sqliteStore) PutDoc(kind, id string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil { return err }
+	_, err = s.db.Exec(`INSERT INTO docs (kind, id, body) VALUES (?, ?, ?) ON CONFLICT(kind, id) DO UPDATE SET body = excluded.body`, kind, id, string(body))
+	return err
+}
+
+func (s *sqliteStore) GetDoc(kind, id string, v interface{}) (bool, error) {
+	var body string
+	err := s.db.QueryRow(`SELECT body FROM docs WHERE kind = ? AND id = ?`, kind, id).Scan(&body)
+	if err == sql.ErrNoRows { return false, nil }
+	if err != nil { return false, err }
+	return true, json.Unmarshal([]byte(body), v)
+}
+
+func (s *sqliteStore) ListDocs(kind string) ([]Doc, error) {
+	rows, err := s.db.Query(`SELECT id, body FROM docs WHERE kind = ? ORDER BY id`, kind)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	var out []Doc
+	for rows.Next() {
+		var id, body string
+		if err := rows.Scan(&id, &body); err != nil { return nil, err }
+		out = append(out, Doc{ID: id, Body: json.RawMessage(body)})
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) DeleteDoc(kind, id string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM docs WHERE kind = ? AND id = ?`, kind, id)
+	if err != nil { return false, err }
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Backup 用 VACUUM INTO 生成一致性副本再流式输出，不阻塞其他读写太久
+func (s *sqliteStore) Backup(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "license-backup-")
+	if err != nil { return err }
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "backup.db")
+	if _, err := s.db.Exec(`VACUUM INTO ?`, tmp); err != nil { return err }
+	f, err := os.Open(tmp)
+	if err != nil { return err }
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (s *sqliteStore) Close() error { return s.db.Close() }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"time"
+
+	"license-server/client"
+)
+
+// ================= JWT / PASETO =================
+
+var TokenIssuer = getEnv("LICENSE_ISSUER", "license-server")
+
+func licenseClaims(p LicenseParams, expiryUTC int64) client.Claims {
+	return client.Claims{ID: p.ID, Issuer: TokenIssuer, Subject: p.MachineID, IssuedAt: time.Now().Unix(), ExpiresAt: expiryUTC, Features: p.Features, Product: p.Product}
+}
+
+// verificationKeys 返回当前所有可用私钥对应的公钥，用于校验令牌和发布 JWKS
+func verificationKeys() []crypto.PublicKey {
+	var keys []crypto.PublicKey
+	if k, err := loadPrivateKey(); err == nil { keys = append(keys, &k.PublicKey) }
+	if k, err := loadShortKeySigner(); err == nil { keys = append(keys, k.Public()) }
+	return keys
+}
+
+// handleJWKS 发布 JWK Set，第三方 JWT / PASETO 库可据此按 kid 取公钥
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks := []map[string]string{}
+	for _, key := range verificationKeys() {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, map[string]string{"kty": "RSA", "use": "sig", "alg": "RS256", "kid": client.KeyID(k), "n": enc(k.N.Bytes()), "e": enc(big.NewInt(int64(k.E)).Bytes())})
+		case ed25519.PublicKey:
+			jwks = append(jwks, map[string]string{"kty": "OKP", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "kid": client.KeyID(k), "x": enc(k)})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": jwks})
+}
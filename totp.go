@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ================= 两步验证 (TOTP) =================
+//
+// RFC 6238：HMAC-SHA1、30 秒步长、6 位数字，允许前后各一个步长的时钟误差，同一步长的验证码只能用一次。
+// 用户可自行在 /2fa 绑定；TOTP_REQUIRED_ROLES 中的角色必须绑定，未绑定时登录后只能访问绑定页面。
+// 启用后用户无法再用 HTTP Basic 认证，脚本请改用 API Key。
+// 危险操作 (生成密钥、清空回收站、签发超过 STEP_UP_LICENSE_DAYS 天的激活码) 要求会话在 STEP_UP_WINDOW
+// 内验证过第二因素；未绑定 TOTP 的用户以当前密码代替，单点登录用户则需重新经身份提供方登录。
+
+const (
+	totpStep   = 30
+	totpDigits = 6
+	totpIssuer = "License Server"
+
+	recoveryCodeCount = 10
+)
+
+var (
+	TOTPRequiredRoles = strings.Split(os.Getenv("TOTP_REQUIRED_ROLES"), ",")
+	StepUpWindow      = getEnvDuration("STEP_UP_WINDOW", 5*time.Minute)
+	StepUpLicenseDays = getEnvInt("STEP_UP_LICENSE_DAYS", 14)
+)
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpRequired 判断角色是否强制两步验证
+func totpRequired(role string) bool {
+	for _, r := range TOTPRequiredRoles {
+		if strings.TrimSpace(r) == role { return true }
+	}
+	return false
+}
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", v%1000000)
+}
+
+// matchTOTP 返回与验证码匹配且晚于 lastStep 的时间步，不匹配时返回 0
+func matchTOTP(secretB32, code string, lastStep int64, now time.Time) int64 {
+	secret, err := base32NoPad.DecodeString(strings.ToUpper(secretB32))
+	if err != nil || len(code) != totpDigits { return 0 }
+	cur := now.Unix() / totpStep
+	for step := cur - 1; step <= cur+1; step++ {
+		if step <= lastStep { continue }
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 { return step }
+	}
+	return 0
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+// newRecoveryCodes 生成一组恢复码，返回明文 (只显示一次) 和哈希
+func newRecoveryCodes() (plain, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		rand.Read(b)
+		s := strings.ToLower(base32NoPad.EncodeToString(b))[:10]
+		plain = append(plain, s[:5]+"-"+s[5:])
+		hashes = append(hashes, hashRecoveryCode(s))
+	}
+	return plain, hashes
+}
+
+// verifySecondFactor 校验 TOTP 验证码或恢复码 (恢复码用后作废)，成功时保存用户
+func verifySecondFactor(u *User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if u.TOTPSecret == "" || code == "" { return false, nil }
+	if step := matchTOTP(u.TOTPSecret, code, u.TOTPLastStep, time.Now()); step > 0 {
+		u.TOTPLastStep = step
+		return true, store.PutDoc(userDocKind, u.Username, u)
+	}
+	h := hashRecoveryCode(code)
+	for i, rc := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(h)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			log.Printf("⚠️ 用户 %s 使用了恢复码，剩余 %d 个", u.Username, len(u.RecoveryCodes))
+			return true, store.PutDoc(userDocKind, u.Username, u)
+		}
+	}
+	return false, nil
+}
+
+// ================= 二次验证 (step-up) =================
+
+// longLicense 判断有效期是否长到需要二次验证，无法解析的日期交给后续校验处理
+func longLicense(expiry string) bool {
+	exp, err := parseExpiry(expiry)
+	return err == nil && time.Until(time.Unix(exp, 0)) > time.Duration(StepUpLicenseDays)*24*time.Hour
+}
+
+// stepUp 要求会话在 StepUpWindow 内验证过身份；请求可在 X-Step-Up 头中 (URL 编码) 附带验证码或密码。
+// 失败时写入 401 并带 X-Step-Up-Required 头 (totp / password / sso)，页面脚本据此提示并重试或跳转重新登录。
+// API Key、Basic 认证与旧版 Token 不经过会话，不做二次验证。
+func stepUp(w http.ResponseWriter, r *http.Request, p *Principal) bool {
+	s := p.session
+	if s == nil { return true }
+	sessions.Lock()
+	fresh := time.Since(s.StepUpAt) < StepUpWindow
+	sessions.Unlock()
+	if fresh { return true }
+
+	u, err := getUser(store, p.Name)
+	if err != nil || u == nil { http.Error(w, "用户不存在", 500); return false }
+	kind := "password"
+	if u.TOTPSecret != "" { kind = "totp" } else if u.SSO != "" { kind = "sso" }
+	if code, err := url.QueryUnescape(r.Header.Get("X-Step-Up")); err == nil && code != "" {
+		ip := clientIP(r)
+		if wait := authLockedFor(ip, u.Username); wait > 0 { tooManyAttempts(w, wait); return false }
+		ok := false
+		switch kind {
+		case "totp":
+			if ok, err = verifySecondFactor(u, code); err != nil { http.Error(w, err.Error(), 500); return false }
+		case "password":
+			ok = checkPasswordCached(u, code)
+		}
+		if ok {
+			sessions.Lock()
+			s.StepUpAt = time.Now()
+			sessions.Unlock()
+			return true
+		}
+		if kind != "sso" { authFailed(ip, u.Username) }
+	}
+	w.Header().Set("X-Step-Up-Required", kind)
+	http.Error(w, "该操作需要再次验证身份", 401)
+	return false
+}
+
+// ================= 登录第二步 =================
+
+// handleLogin2FA 密码通过后的第二步：输入验证码或恢复码
+func handleLogin2FA(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	c, err := r.Cookie(sessionCookie)
+	s := lookupSession(r)
+	if err != nil || s == nil || !s.Pending { http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusSeeOther); return }
+	msg := ""
+	if r.Method == "POST" {
+		if !checkCSRF(r, s) { http.Error(w, "CSRF 校验失败", 403); return }
+		ip := clientIP(r)
+		if wait := authLockedFor(ip, s.Username); wait > 0 { tooManyAttempts(w, wait); return }
+		u, err := getUser(store, s.Username)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		ok := false
+		if u != nil && !u.Disabled {
+			if ok, err = verifySecondFactor(u, r.PostFormValue("code")); err != nil { http.Error(w, err.Error(), 500); return }
+		}
+		if ok {
+			// 通过后换发新的会话 ID
+			sessions.Lock()
+			delete(sessions.m, c.Value)
+			sessions.Unlock()
+			id, ns := newSession(u.Username)
+			ns.StepUpAt = ns.Created
+			setSessionCookies(w, r, id, ns.CSRF, int(SessionMaxAge.Seconds()))
+			authSucceeded(u.Username)
+			audit(r, &Principal{Name: u.Username, Role: u.Role}, "login", u.Username, "password+totp")
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		authFailed(ip, s.Username)
+		audit(r, nil, "login.failed", s.Username, "totp")
+		sessions.Lock()
+		s.Attempts++
+		if s.Attempts >= maxSecondFactorAttempts { delete(sessions.m, c.Value) }
+		sessions.Unlock()
+		if s.Attempts >= maxSecondFactorAttempts { http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusSeeOther); return }
+		msg = "验证码错误"
+		w.WriteHeader(401)
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1.0"><title>两步验证</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:360px;margin:80px auto;padding:20px;background:#f5f5f7}.card{background:white;padding:30px;border-radius:12px;box-shadow:0 4px 12px rgba(0,0,0,0.1)}input{width:100%%;padding:10px;margin:5px 0 15px;box-sizing:border-box;border:1px solid #ccc;border-radius:6px}button{width:100%%;padding:12px;background:#0071e3;color:white;border:none;border-radius:6px;cursor:pointer}.err{color:#c00;font-size:14px}.tip{color:#888;font-size:12px}</style></head><body>
+	<div class="card"><h2>🛡️ 两步验证</h2><form method="POST" action="/login/2fa"><input type="hidden" name="next" value="%s"><input type="hidden" name="csrf_token" value="%s">
+	<label>验证码</label><input name="code" autocomplete="one-time-code" inputmode="numeric" autofocus><p class="tip">输入身份验证器中的 6 位数字，或一个恢复码</p>
+	<p class="err">%s</p><button type="submit">验证</button></form></div></body></html>`, html.EscapeString(next), s.CSRF, msg)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// ================= 绑定与管理 =================
+
+type totpRequest struct {
+	Code string `json:"code"`
+}
+
+// handle2FA GET 显示绑定状态页面；POST /api/2fa/setup 生成待确认密钥，/api/2fa/enable 确认启用并返回恢复码，
+// /api/2fa/recovery 重新生成恢复码，/api/2fa/disable 关闭。后三者需要当前验证码。
+func handle2FA(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, "", "")
+	if p == nil { return }
+	if p.Key != nil || p.Name == "token" { http.Error(w, "请使用用户账号登录", 400); return }
+	u, err := getUser(store, p.Name)
+	if err != nil || u == nil { http.Error(w, "用户不存在", 500); return }
+
+	if r.Method == "GET" && r.URL.Path == "/2fa" { render2FAPage(w, u); return }
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req totpRequest
+	if r.URL.Path != "/api/2fa/setup" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	ip := clientIP(r)
+	if r.URL.Path != "/api/2fa/setup" {
+		if wait := authLockedFor(ip, u.Username); wait > 0 { tooManyAttempts(w, wait); return }
+	}
+
+	switch r.URL.Path {
+	case "/api/2fa/setup":
+		if u.TOTPSecret != "" { http.Error(w, "已启用两步验证", 409); return }
+		b := make([]byte, 20)
+		rand.Read(b)
+		u.TOTPPending = base32NoPad.EncodeToString(b)
+		if err := store.PutDoc(userDocKind, u.Username, u); err != nil { http.Error(w, err.Error(), 500); return }
+		uri := "otpauth://totp/" + url.PathEscape(totpIssuer+":"+u.Username) + "?" + url.Values{"secret": {u.TOTPPending}, "issuer": {totpIssuer}, "digits": {"6"}, "period": {"30"}}.Encode()
+		png, err := renderQRPNG(uri, 220)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		json.NewEncoder(w).Encode(map[string]string{"secret": u.TOTPPending, "uri": uri, "qr": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)})
+	case "/api/2fa/enable":
+		if u.TOTPPending == "" { http.Error(w, "请先生成密钥", 400); return }
+		step := matchTOTP(u.TOTPPending, strings.TrimSpace(req.Code), 0, time.Now())
+		if step == 0 { authFailed(ip, u.Username); http.Error(w, "验证码错误", 400); return }
+		plain, hashes := newRecoveryCodes()
+		u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, u.RecoveryCodes = u.TOTPPending, "", step, hashes
+		if err := store.PutDoc(userDocKind, u.Username, u); err != nil { http.Error(w, err.Error(), 500); return }
+		dropSessions(u.Username, p.session)
+		audit(r, p, "2fa.enable", u.Username, "")
+		json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": plain})
+	case "/api/2fa/recovery", "/api/2fa/disable":
+		ok, err := verifySecondFactor(u, req.Code)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if !ok { authFailed(ip, u.Username); http.Error(w, "验证码错误", 400); return }
+		if r.URL.Path == "/api/2fa/recovery" {
+			plain, hashes := newRecoveryCodes()
+			u.RecoveryCodes = hashes
+			if err := store.PutDoc(userDocKind, u.Username, u); err != nil { http.Error(w, err.Error(), 500); return }
+			audit(r, p, "2fa.recovery", u.Username, "")
+			json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": plain})
+			return
+		}
+		if totpRequired(u.Role) { http.Error(w, "你的角色要求必须启用两步验证", 403); return }
+		u.TOTPSecret, u.TOTPLastStep, u.RecoveryCodes = "", 0, nil
+		if err := store.PutDoc(userDocKind, u.Username, u); err != nil { http.Error(w, err.Error(), 500); return }
+		audit(r, p, "2fa.disable", u.Username, "")
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func render2FAPage(w http.ResponseWriter, u *User) {
+	status := `<p>状态：<b style="color:#c77700">未启用</b></p><button onclick="setup()" class="ok-btn">开始绑定</button>`
+	if totpRequired(u.Role) { status += `<p style="color:#c00;font-size:14px">你的角色要求启用两步验证，完成绑定后才能使用其他功能。</p>` }
+	if u.TOTPSecret != "" {
+		status = fmt.Sprintf(`<p>状态：<b style="color:green">已启用</b>，剩余恢复码 %d 个</p><button onclick="act('/api/2fa/recovery')" class="ok-btn">重新生成恢复码</button> <button onclick="act('/api/2fa/disable')" class="del-btn">关闭两步验证</button>`, len(u.RecoveryCodes))
+	}
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>两步验证</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:600px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1)}input{padding:8px;border:1px solid #ccc;border-radius:4px}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:6px 10px;border-radius:4px;cursor:pointer}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:6px 10px;border-radius:4px;cursor:pointer}#enroll,#codes{display:none;margin-top:15px}#codes pre{background:#eef6ff;padding:10px;border-radius:6px}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🛡️ 两步验证 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2>%s
+	<div id="enroll"><p>用身份验证器 App 扫描二维码，或手动输入密钥：<code id="secret"></code></p><img id="qr" width="220" height="220"><p><input id="code" placeholder="6 位验证码" inputmode="numeric"> <button onclick="enable()" class="ok-btn">确认启用</button></p></div>
+	<div id="codes"><p>请妥善保存以下恢复码，每个只能使用一次，且只显示这一次：</p><pre id="codelist"></pre><a href="/2fa">完成</a></div></div>
+	<script>async function post(url,body){let res=await fetch(url,{method:'POST',headers:{'Content-Type':'application/json'},body:JSON.stringify(body||{})});if(!res.ok)throw new Error(await res.text());return res.json()}
+	function showCodes(d){document.getElementById('codes').style.display='block';document.getElementById('codelist').innerText=d.recovery_codes.join('\n')}
+	async function setup(){try{var d=await post('/api/2fa/setup');document.getElementById('enroll').style.display='block';document.getElementById('secret').innerText=d.secret;document.getElementById('qr').src=d.qr}catch(e){alert(e.message)}}
+	async function enable(){try{document.getElementById('enroll').style.display='none';showCodes(await post('/api/2fa/enable',{code:document.getElementById('code').value}))}catch(e){document.getElementById('enroll').style.display='block';alert(e.message)}}
+	async function act(url){var c=prompt('请输入验证码或恢复码');if(!c)return;try{var d=await post(url,{code:c});if(d.recovery_codes)showCodes(d);else location.reload()}catch(e){alert(e.message)}}</script></body></html>`, status)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ================= 回收站与归档 =================
+//
+// 页面上删除记录或机器码只是标记 DeletedAt 移入回收站，可随时恢复；在回收站中超过 TRASH_RETENTION_DAYS 天后
+// 由后台任务彻底删除。设置 ARCHIVE_AFTER_DAYS 后，到期超过该天数的记录会被归档：不再出现在历史记录首页，
+// 但仍可在归档视图中搜索，在线校验不受影响。
+
+var (
+	TrashRetentionDays  = getEnvInt("TRASH_RETENTION_DAYS", 30)
+	ArchiveAfterDays    = getEnvInt("ARCHIVE_AFTER_DAYS", 0) // 0 表示不自动归档
+	MaintenanceInterval = getEnvDuration("MAINTENANCE_INTERVAL", time.Hour)
+)
+
+func trashTime() string { return time.Now().UTC().Format(time.RFC3339) }
+
+// deletedBefore 判断回收站中的条目是否已超过保留期，时间无法解析时保留
+func deletedBefore(deletedAt string, cutoff time.Time) bool {
+	t, err := time.Parse(time.RFC3339, deletedAt)
+	return err == nil && t.Before(cutoff)
+}
+
+// purgeTrash 彻底删除在回收站中超过保留期的记录和机器码，返回删除的条数
+func purgeTrash(now time.Time) (int, error) { return purgeDeletedBefore(now.AddDate(0, 0, -TrashRetentionDays)) }
+
+// purgeDeletedBefore 彻底删除 cutoff 之前移入回收站的记录和机器码
+func purgeDeletedBefore(cutoff time.Time) (int, error) {
+	recs, _, err := store.LicensePage(LicenseFilter{View: ViewTrash}, 0, math.MaxInt32)
+	if err != nil { return 0, err }
+	n := 0
+	for _, rec := range recs {
+		if !deletedBefore(rec.DeletedAt, cutoff) { continue }
+		if _, err := store.DeleteLicense(rec.ID); err != nil { return n, err }
+		n++
+	}
+	machines, err := store.ListMachines()
+	if err != nil { return n, err }
+	for _, m := range machines {
+		if !deletedBefore(m.DeletedAt, cutoff) { continue }
+		if _, err := store.DeleteMachine(m.MachineID); err != nil { return n, err }
+		n++
+	}
+	return n, nil
+}
+
+// archiveExpired 归档到期超过 ArchiveAfterDays 天的记录，回收站中的不处理
+func archiveExpired(now time.Time) (int, error) {
+	if ArchiveAfterDays == 0 { return 0, nil }
+	to := now.AddDate(0, 0, -ArchiveAfterDays-1).Format("2006-01-02")
+	recs, err := store.LicensesExpiringBetween("0000-01-01", to)
+	if err != nil { return 0, err }
+	stamp := now.UTC().Format(time.RFC3339)
+	n := 0
+	for _, rec := range recs {
+		if rec.DeletedAt != "" || rec.ArchivedAt != "" { continue }
+		if _, err := store.UpdateLicense(rec.ID, func(r *HistoryRecord) { if r.ArchivedAt == "" { r.ArchivedAt = stamp } }); err != nil { return n, err }
+		n++
+	}
+	return n, nil
+}
+
+func runMaintenance(now time.Time) {
+	if n, err := purgeTrash(now); err != nil {
+		log.Printf("❌ 清理回收站失败: %v", err)
+	} else if n > 0 {
+		log.Printf(">>> 已彻底删除回收站中超过 %d 天的 %d 项", TrashRetentionDays, n)
+	}
+	if n, err := archiveExpired(now); err != nil {
+		log.Printf("❌ 归档过期记录失败: %v", err)
+	} else if n > 0 {
+		log.Printf(">>> 已归档 %d 条到期超过 %d 天的记录", n, ArchiveAfterDays)
+	}
+	if _, err := purgeIdempotency(now); err != nil { log.Printf("❌ 清理幂等记录失败: %v", err) }
+}
+
+// startMaintenance 启动时执行一次，之后每隔 MAINTENANCE_INTERVAL 清理回收站、归档过期记录并删除过期的幂等记录，返回停止函数
+func startMaintenance() func() {
+	if ArchiveAfterDays > 0 { log.Printf("✅ 自动归档已启用 (到期 %d 天后)", ArchiveAfterDays) }
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(MaintenanceInterval)
+		defer ticker.Stop()
+		for {
+			runMaintenance(time.Now())
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(stop); wg.Wait() }
+}
+
+// findMachine 按机器码查找 (含回收站中的)，不存在时返回 nil
+func findMachine(machineID string) (*MachineRecord, error) {
+	machines, err := store.ListMachines()
+	if err != nil { return nil, err }
+	for _, m := range machines {
+		if m.MachineID == machineID { return &m, nil }
+	}
+	return nil, nil
+}
+
+// handleRestore 从回收站恢复记录 (id) 或机器码 (machine_id)，归档过的记录恢复后回到归档视图
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	perm := PermHistoryDelete
+	if req.ID == "" { perm = PermMachinesDelete }
+	p := authorize(w, r, perm, req.Token)
+	if p == nil { return }
+
+	var found bool
+	var err error
+	switch {
+	case req.ID != "":
+		found, err = store.UpdateLicense(req.ID, func(rec *HistoryRecord) { rec.DeletedAt = "" })
+	case req.MachineID != "":
+		found, err = store.UpdateMachine(req.MachineID, func(m *MachineRecord) { m.DeletedAt = "" })
+	default:
+		http.Error(w, "ID Empty", 400); return
+	}
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if !found { http.Error(w, "记录不存在", 404); return }
+	audit(r, p, "trash.restore", req.ID+req.MachineID, "")
+	w.Write([]byte("✅ 已恢复"))
+}
+
+// handlePurge 彻底删除回收站中的记录或机器码，不在回收站中的拒绝，避免一步误删
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p := authorize(w, r, PermHistoryPurge, req.Token)
+	if p == nil { return }
+
+	switch {
+	case req.All:
+		if !stepUp(w, r, p) { return }
+		n, err := purgeDeletedBefore(time.Now().Add(time.Second))
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		audit(r, p, "trash.empty", "", fmt.Sprintf("%d 条", n))
+		fmt.Fprintf(w, "✅ 已清空回收站，共 %d 条", n)
+		return
+	case req.ID != "":
+		rec, err := store.GetLicense(req.ID)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if rec == nil { http.Error(w, "记录不存在", 404); return }
+		if rec.DeletedAt == "" { http.Error(w, "只能彻底删除回收站中的记录，请先删除", 409); return }
+		if _, err := store.DeleteLicense(req.ID); err != nil { http.Error(w, err.Error(), 500); return }
+	case req.MachineID != "":
+		m, err := findMachine(req.MachineID)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if m == nil { http.Error(w, "机器码未找到", 404); return }
+		if m.DeletedAt == "" { http.Error(w, "只能彻底删除回收站中的机器码，请先删除", 409); return }
+		if _, err := store.DeleteMachine(req.MachineID); err != nil { http.Error(w, err.Error(), 500); return }
+	default:
+		http.Error(w, "ID Empty", 400); return
+	}
+	audit(r, p, "trash.purge", req.ID+req.MachineID, "")
+	w.Write([]byte("✅ 已彻底删除"))
+}
+
+// historyToolbar 返回历史记录页的视图切换与搜索框
+func historyToolbar(view, q string) string {
+	tab := func(v, label string) string {
+		style := "color:#0071e3"
+		if v == view { style = "color:#333;font-weight:bold" }
+		return fmt.Sprintf(`<a href="/history?view=%s" style="margin-right:14px;text-decoration:none;%s">%s</a>`, v, style, label)
+	}
+	return fmt.Sprintf(`<div style="display:flex;justify-content:space-between;align-items:center;font-size:14px"><div>%s%s<a href="/trash" style="text-decoration:none;color:#0071e3">回收站</a></div><form method="get" action="/history" style="margin:0"><input type="hidden" name="view" value="%s"><input name="q" value="%s" placeholder="机器码 / 客户 / 产品 / ID" style="padding:5px 8px;border:1px solid #ddd;border-radius:4px;width:200px"> <button style="padding:5px 12px;background:#0071e3;color:white;border:none;border-radius:4px;cursor:pointer">搜索</button></form></div>`,
+		tab(ViewActive, "当前"), tab(ViewArchive, "归档"), view, html.EscapeString(q))
+}
+
+// handleTrash 回收站页面：分页列出已删除的记录，以及全部已删除的机器码
+func handleTrash(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermHistoryRead, "") == nil { return }
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 { page = p }
+
+	startIndex := (page - 1) * PageSize
+	recs, total, err := store.LicensePage(LicenseFilter{View: ViewTrash}, startIndex, PageSize)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	machines, err := store.ListMachines()
+	if err != nil { http.Error(w, err.Error(), 500); return }
+
+	licRows := ""
+	for i, rec := range recs {
+		short := rec.LicenseCode
+		if len(short) > 10 { short = short[:10] + "..." }
+		id := html.EscapeString(rec.ID)
+		licRows += fmt.Sprintf(`<tr><td style="text-align:center;color:#888">%d</td><td>%s</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td title="%s">%s</td><td style="font-family:monospace;font-size:12px;color:#888">%s</td><td style="text-align:center;white-space:nowrap"><button data-id="%s" onclick="trashAction('/api/restore',{id:this.dataset.id},'确定要恢复该记录吗？')" class="ok-btn">恢复</button><button data-id="%s" onclick="trashAction('/api/purge',{id:this.dataset.id},'彻底删除后无法恢复，确定吗？')" class="del-btn">彻底删除</button></td></tr>`,
+			startIndex+i+1, html.EscapeString(rec.DeletedAt), html.EscapeString(rec.MachineID), html.EscapeString(rec.ExpiryDate), html.EscapeString(rec.LicenseCode), html.EscapeString(short), id, id, id)
+	}
+	machineRows, machineCount := "", 0
+	for i := len(machines) - 1; i >= 0; i-- {
+		m := machines[i]
+		if m.DeletedAt == "" { continue }
+		machineCount++
+		mid := html.EscapeString(m.MachineID)
+		machineRows += fmt.Sprintf(`<tr><td style="text-align:center;color:#888">%d</td><td>%s</td><td style="font-family:monospace;color:#0071e3">%s</td><td>%s</td><td style="text-align:center;white-space:nowrap"><button data-mid="%s" onclick="trashAction('/api/restore',{machine_id:this.dataset.mid},'确定要恢复该机器码吗？')" class="ok-btn">恢复</button><button data-mid="%s" onclick="trashAction('/api/purge',{machine_id:this.dataset.mid},'彻底删除后无法恢复，确定吗？')" class="del-btn">彻底删除</button></td></tr>`,
+			machineCount, html.EscapeString(m.DeletedAt), mid, html.EscapeString(m.LastSeen), mid, mid)
+	}
+
+	totalPages := int(math.Ceil(float64(total) / float64(PageSize)))
+	navHtml := `<div style="margin-top:20px;text-align:center;">`
+	if page > 1 { navHtml += fmt.Sprintf(`<a href="/trash?page=%d" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">上一页</a> `, page-1) }
+	navHtml += fmt.Sprintf(`<span style="margin:0 10px">第 %d / %d 页 (共 %d 条)</span>`, page, totalPages, total)
+	if page < totalPages { navHtml += fmt.Sprintf(`<a href="/trash?page=%d" style="text-decoration:none;padding:5px 15px;background:#0071e3;color:white;border-radius:4px;font-size:14px">下一页</a>`, page+1) }
+	navHtml += `</div>`
+
+	body := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>回收站</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:900px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}tr:hover{background:#f9f9f9}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px;margin-left:4px} .del-btn:hover{background:#ff3b30;color:white}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px} .ok-btn:hover{background:#0071e3;color:white}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">🗑️ 回收站 <span><a href="/history" style="font-size:14px;color:#0071e3;text-decoration:none;margin-right:12px">历史记录</a><a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></span></h2><p style="color:#888;font-size:13px">回收站中的条目在删除 %d 天后自动彻底清除 <button onclick="trashAction('/api/purge',{all:true},'确定清空回收站吗？所有条目将被彻底删除且不可恢复')" class="del-btn" style="margin-left:8px">清空回收站</button></p>
+	<h3>激活码记录</h3><table><thead><tr><th style="width:50px;text-align:center">#</th><th>删除时间</th><th>机器码</th><th>到期</th><th>激活码</th><th>ID</th><th style="width:150px;text-align:center">操作</th></tr></thead><tbody>%s</tbody></table>%s</div>
+	<div class="card"><h3>机器码 (%d)</h3><table><thead><tr><th style="width:50px;text-align:center">#</th><th>删除时间</th><th>机器码</th><th>最后生成时间</th><th style="width:150px;text-align:center">操作</th></tr></thead><tbody>%s</tbody></table></div>
+	<script>async function trashAction(url,body,msg){if(!confirm(msg))return;try {let res = await fetch(url, {method: 'POST', headers: {'Content-Type': 'application/json'},body: JSON.stringify(body)});if(res.ok) location.reload(); else alert(await res.text());} catch(e){alert(e)}}</script></body></html>`,
+		TrashRetentionDays, licRows, navHtml, machineCount, machineRows)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(body))
+}
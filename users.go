@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ================= 用户与权限 =================
+//
+// 用户保存为 users 文档 (id 为用户名)，密码用 argon2id 哈希，也接受从其他系统导入的 bcrypt 哈希。
+// 每个用户有一个角色，角色决定可执行的操作；admin 拥有全部权限。
+// 旧版共享的 SECURITY_TOKEN 仅为兼容已有 API 客户端保留：未设置时关闭，设置后以管理员身份生效。
+
+const userDocKind = "users"
+
+// 角色
+const (
+	RoleAdmin   = "admin"
+	RoleIssuer  = "issuer"
+	RoleSupport = "support"
+	RoleViewer  = "viewer"
+)
+
+// 权限
+const (
+	PermGenerate       = "license.generate"
+	PermRevoke         = "license.revoke"
+	PermHistoryRead    = "history.read"
+	PermHistoryDelete  = "history.delete" // 移入回收站、从回收站恢复
+	PermHistoryPurge   = "history.purge"  // 彻底删除
+	PermMachinesRead   = "machines.read"
+	PermMachinesDelete = "machines.delete"
+	PermBackup         = "backup"
+	PermSetup          = "setup"
+	PermUsers          = "users.manage"
+	PermKeys           = "keys.manage"
+	PermVerify         = "license.verify" // 仅在 VERIFY_REQUIRE_AUTH 开启时检查
+	PermSecurity       = "security.manage"
+	PermAudit          = "audit.read"
+	PermApprove        = "license.approve" // 审批超出策略的签发申请
+	PermQuotas         = "quotas.manage"
+)
+
+// rolePermissions 列出除 admin 外各角色的权限
+var rolePermissions = map[string][]string{
+	RoleIssuer:  {PermGenerate, PermVerify, PermHistoryRead, PermMachinesRead},
+	RoleSupport: {PermRevoke, PermVerify, PermHistoryRead, PermHistoryDelete, PermMachinesRead, PermMachinesDelete},
+	RoleViewer:  {PermVerify, PermHistoryRead, PermMachinesRead},
+}
+
+var roleNames = map[string]string{RoleAdmin: "管理员", RoleIssuer: "签发员", RoleSupport: "客服", RoleViewer: "只读"}
+
+var (
+	SecurityToken = os.Getenv("SECURITY_TOKEN")
+	AdminUsername = getEnv("ADMIN_USERNAME", "admin")
+)
+
+// argon2id 参数 (RFC 9106 推荐的低内存配置)
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+
+	minPasswordLen = 8
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._@-]{0,63}$`)
+
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+	Disabled     bool   `json:"disabled,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
+
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // base32，不为空表示已启用两步验证
+	TOTPPending   string   `json:"totp_pending,omitempty"`   // 绑定中、尚未确认的密钥
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"` // 最近一次使用的时间步，防止验证码重放
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // 恢复码的 SHA-256
+
+	SSO string `json:"sso,omitempty"` // 由单点登录创建时为身份提供方的 issuer，这类用户没有本地密码
+}
+
+// Principal 是通过鉴权的调用方
+type Principal struct {
+	Name string  // 用户名；API Key 为 "key:<名称>"；旧版共享 Token 为 "token"
+	Role string  // API Key 为 "apikey"
+	Key  *APIKey // 通过 API Key 鉴权时不为空，权限只取决于其操作范围
+
+	session *session // 通过登录会话鉴权时不为空，修改类请求需校验 CSRF
+
+	mustEnrollTOTP bool // 角色要求两步验证但尚未绑定，只能访问绑定相关页面
+}
+
+// Can 判断是否拥有某项权限，perm 为空表示只要求已登录
+func (p *Principal) Can(perm string) bool {
+	if p.Key != nil {
+		for _, scope := range p.Key.Scopes {
+			if apiKeyScopes[scope] == perm { return true }
+		}
+		return false
+	}
+	if perm == "" || p.Role == RoleAdmin { return true }
+	for _, v := range rolePermissions[p.Role] {
+		if v == perm { return true }
+	}
+	return false
+}
+
+func validRole(role string) bool { _, ok := roleNames[role]; return ok }
+
+// ================= 密码哈希 =================
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil { return "", err }
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// checkPassword 校验 argon2id (PHC 格式) 或 bcrypt 哈希
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$2") { return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil }
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" { return false }
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version { return false }
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil { return false }
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil { return false }
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil { return false }
+	got := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// passwordCache 缓存最近校验通过的凭据，避免每个请求都做一次 argon2 运算。
+// 键包含当前密码哈希，改密码后旧缓存自然失效。
+var passwordCache = struct {
+	sync.Mutex
+	m map[[32]byte]time.Time
+}{m: map[[32]byte]time.Time{}}
+
+const passwordCacheTTL = 5 * time.Minute
+
+func checkPasswordCached(u *User, password string) bool {
+	key := sha256.Sum256([]byte(u.Username + "\x00" + password + "\x00" + u.PasswordHash))
+	now := time.Now()
+	passwordCache.Lock()
+	exp, ok := passwordCache.m[key]
+	passwordCache.Unlock()
+	if ok && now.Before(exp) { return true }
+	if !checkPassword(u.PasswordHash, password) { return false }
+	passwordCache.Lock()
+	for k, v := range passwordCache.m {
+		if now.After(v) { delete(passwordCache.m, k) }
+	}
+	passwordCache.m[key] = now.Add(passwordCacheTTL)
+	passwordCache.Unlock()
+	return true
+}
+
+// ================= 用户存取 =================
+
+func normalizeUsername(name string) string { return strings.ToLower(strings.TrimSpace(name)) }
+
+// getUser 读取用户，不存在时返回 nil
+func getUser(st Store, name string) (*User, error) {
+	var u User
+	found, err := st.GetDoc(userDocKind, normalizeUsername(name), &u)
+	if err != nil || !found { return nil, err }
+	return &u, nil
+}
+
+func listUsers(st Store) ([]User, error) {
+	docs, err := st.ListDocs(userDocKind)
+	if err != nil { return nil, err }
+	users := make([]User, 0, len(docs))
+	for _, d := range docs {
+		var u User
+		if err := json.Unmarshal(d.Body, &u); err != nil { return nil, fmt.Errorf("用户 %s 数据损坏: %v", d.ID, err) }
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// activeAdmins 统计未停用的管理员数量，修改/删除用户前用于防止把最后一个管理员去掉
+func activeAdmins(st Store) (int, error) {
+	users, err := listUsers(st)
+	if err != nil { return 0, err }
+	n := 0
+	for _, u := range users {
+		if u.Role == RoleAdmin && !u.Disabled { n++ }
+	}
+	return n, nil
+}
+
+// UserChange 描述一次创建或修改，空字段表示不修改
+type UserChange struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+	Disabled *bool  `json:"disabled,omitempty"`
+	ResetTOTP bool  `json:"reset_totp,omitempty"` // 清除两步验证，用于丢失设备时重新绑定
+}
+
+// saveUser 创建或修改用户；create 为 true 时用户必须不存在，否则必须存在
+func saveUser(st Store, c UserChange, create bool) (*User, error) {
+	name := normalizeUsername(c.Username)
+	if !usernamePattern.MatchString(name) { return nil, fmt.Errorf("用户名只能包含小写字母、数字和 . _ @ -") }
+	u, err := getUser(st, name)
+	if err != nil { return nil, err }
+	now := time.Now().UTC().Format(time.RFC3339)
+	if create {
+		if u != nil { return nil, fmt.Errorf("用户 %s 已存在", name) }
+		if c.Password == "" { return nil, fmt.Errorf("新用户必须设置密码") }
+		if c.Role == "" { c.Role = RoleViewer }
+		u = &User{Username: name, CreatedAt: now}
+	} else {
+		if u == nil { return nil, fmt.Errorf("用户 %s 不存在", name) }
+		u.UpdatedAt = now
+	}
+	wasAdmin := u.Role == RoleAdmin && !u.Disabled && !create
+	if c.Role != "" {
+		if !validRole(c.Role) { return nil, fmt.Errorf("未知的角色: %s", c.Role) }
+		u.Role = c.Role
+	}
+	if c.Disabled != nil { u.Disabled = *c.Disabled }
+	if c.ResetTOTP { u.TOTPSecret, u.TOTPPending, u.TOTPLastStep, u.RecoveryCodes = "", "", 0, nil }
+	if c.Password != "" {
+		if len(c.Password) < minPasswordLen { return nil, fmt.Errorf("密码至少 %d 位", minPasswordLen) }
+		if u.PasswordHash, err = hashPassword(c.Password); err != nil { return nil, err }
+	}
+	if wasAdmin && (u.Role != RoleAdmin || u.Disabled) {
+		if n, err := activeAdmins(st); err != nil { return nil, err } else if n <= 1 { return nil, fmt.Errorf("不能停用或降级最后一个管理员") }
+	}
+	return u, st.PutDoc(userDocKind, name, u)
+}
+
+func deleteUser(st Store, name string) (bool, error) {
+	u, err := getUser(st, name)
+	if err != nil || u == nil { return false, err }
+	if u.Role == RoleAdmin && !u.Disabled {
+		if n, err := activeAdmins(st); err != nil { return false, err } else if n <= 1 { return false, fmt.Errorf("不能删除最后一个管理员") }
+	}
+	return st.DeleteDoc(userDocKind, u.Username)
+}
+
+func randomPassword() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ensureAdmin 在没有任何用户时创建初始管理员：密码取 ADMIN_PASSWORD，未设置时随机生成并打印到日志
+func ensureAdmin() {
+	users, err := listUsers(store)
+	if err != nil { log.Fatalf(">>> ❌ 读取用户失败: %v", err) }
+	if SecurityToken != "" { log.Println("⚠️ SECURITY_TOKEN 已废弃，仅为兼容旧 API 客户端保留 (具有管理员权限)，请改用用户账号") }
+	if len(users) > 0 { return }
+	password, generated := os.Getenv("ADMIN_PASSWORD"), false
+	if password == "" { password, generated = randomPassword(), true }
+	if _, err := saveUser(store, UserChange{Username: AdminUsername, Password: password, Role: RoleAdmin}, true); err != nil { log.Fatalf(">>> ❌ 创建初始管理员失败: %v", err) }
+	if generated {
+		log.Printf("✅ 已创建初始管理员 %s，密码: %s (只显示这一次，请登录后修改)", AdminUsername, password)
+	} else {
+		log.Printf("✅ 已创建初始管理员 %s (密码取自 ADMIN_PASSWORD)", AdminUsername)
+	}
+}
+
+// ================= 鉴权 =================
+
+// authenticate 识别调用方：API Key、HTTP Basic (用户名 + 密码)、登录会话，或兼容旧客户端的共享 Token (仅限请求体)
+func authenticate(r *http.Request, legacyToken string) *Principal {
+	if key := apiKeyFromRequest(r); key != "" { return authenticateAPIKey(key) }
+	if s := lookupSession(r); s != nil && !s.Pending {
+		u, err := getUser(store, s.Username)
+		if err != nil { log.Printf("❌ 读取用户失败: %v", err); return nil }
+		if u == nil || u.Disabled { return nil }
+		return &Principal{Name: u.Username, Role: u.Role, session: s, mustEnrollTOTP: u.TOTPSecret == "" && u.SSO == "" && totpRequired(u.Role)}
+	}
+	if name, password, ok := r.BasicAuth(); ok {
+		u, err := getUser(store, name)
+		if err != nil { log.Printf("❌ 读取用户失败: %v", err); return nil }
+		if u == nil || u.Disabled || !checkPasswordCached(u, password) { return nil }
+		// 启用或被要求两步验证的账号不能只凭密码调用接口
+		if u.TOTPSecret != "" || totpRequired(u.Role) { return nil }
+		return &Principal{Name: u.Username, Role: u.Role}
+	}
+	if SecurityToken != "" && legacyToken != "" && subtle.ConstantTimeCompare([]byte(legacyToken), []byte(SecurityToken)) == 1 {
+		return &Principal{Name: "token", Role: RoleAdmin}
+	}
+	return nil
+}
+
+// authorize 鉴权并检查权限，失败时写入 401/403 (浏览器页面跳转登录页) 并返回 nil。
+// legacyToken 为请求体中携带的旧版 Token，页面和 GET 接口传空。
+func authorize(w http.ResponseWriter, r *http.Request, perm, legacyToken string) *Principal {
+	ip, account := clientIP(r), presentedAccount(r, legacyToken)
+	if account != "" {
+		if wait := authLockedFor(ip, account); wait > 0 { tooManyAttempts(w, wait); return nil }
+	}
+	p := authenticate(r, legacyToken)
+	if p == nil {
+		if account != "" { authFailed(ip, account); audit(r, nil, "auth.failed", account, r.URL.Path) }
+		if !loginRedirect(w, r) { http.Error(w, "请登录", 401) }
+		return nil
+	}
+	authSucceeded(account)
+	if p.session != nil && !checkCSRF(r, p.session) { http.Error(w, "CSRF 校验失败", 403); return nil }
+	if p.mustEnrollTOTP && r.URL.Path != "/2fa" && !strings.HasPrefix(r.URL.Path, "/api/2fa/") && r.URL.Path != "/api/me" {
+		if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") { http.Redirect(w, r, "/2fa", http.StatusSeeOther); return nil }
+		http.Error(w, "请先启用两步验证", 403)
+		return nil
+	}
+	if !p.Can(perm) { audit(r, p, "auth.denied", perm, r.URL.Path); http.Error(w, "权限不足", 403); return nil }
+	return p
+}
+
+// ================= 用户管理接口 =================
+
+// handleMe 返回当前登录用户及其权限，供页面决定显示哪些入口
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	p := authorize(w, r, "", "")
+	if p == nil { return }
+	perms := []string{}
+	for _, perm := range []string{PermGenerate, PermVerify, PermRevoke, PermHistoryRead, PermHistoryDelete, PermHistoryPurge, PermMachinesRead, PermMachinesDelete, PermBackup, PermSetup, PermUsers, PermKeys, PermSecurity, PermAudit, PermApprove, PermQuotas} {
+		if p.Can(perm) { perms = append(perms, perm) }
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"username": p.Name, "role": p.Role, "permissions": perms})
+}
+
+// handleUsers 用户管理页面 (仅管理员)
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	if authorize(w, r, PermUsers, "") == nil { return }
+	users, err := listUsers(store)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+
+	roles := []string{RoleAdmin, RoleIssuer, RoleSupport, RoleViewer}
+	roleOptions := func(selected string) string {
+		s := ""
+		for _, role := range roles {
+			sel := ""
+			if role == selected { sel = " selected" }
+			s += fmt.Sprintf(`<option value="%s"%s>%s (%s)</option>`, role, sel, roleNames[role], role)
+		}
+		return s
+	}
+	rowsHtml := ""
+	for _, u := range users {
+		status, toggle := `<span style="color:green">正常</span>`, "停用"
+		if u.Disabled { status, toggle = `<span style="color:#c00">已停用</span>`, "启用" }
+		if u.SSO != "" { status += ` <span style="color:#888;font-size:12px">(单点登录)</span>` }
+		mfa := `<span style="color:#888">未启用</span>`
+		if u.TOTPSecret != "" { mfa = fmt.Sprintf(`<span style="color:green">已启用</span> <a href="#" onclick="reset2FA('%s');return false" style="font-size:12px;color:#0071e3">重置</a>`, u.Username) }
+		rowsHtml += fmt.Sprintf(`<tr><td style="font-family:monospace">%s</td><td><select onchange="userAction({username:'%s',role:this.value})">%s</select></td><td>%s</td><td>%s</td><td>%s</td><td style="text-align:center;white-space:nowrap"><button onclick="resetPwd('%s')" class="ok-btn">重置密码</button><button onclick="userAction({username:'%s',disabled:%t})" class="ok-btn">%s</button><button onclick="delUser('%s')" class="del-btn">删除</button></td></tr>`,
+			u.Username, u.Username, roleOptions(u.Role), status, mfa, u.CreatedAt, u.Username, u.Username, !u.Disabled, toggle, u.Username)
+	}
+
+	page := fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="UTF-8">`+pageScript+`<meta name="viewport" content="width=device-width,initial-scale=1.0"><title>用户管理</title>
+	<style>body{font-family:-apple-system,sans-serif;max-width:900px;margin:20px auto;padding:10px;background:#f5f5f7}.card{background:white;padding:20px;border-radius:12px;box-shadow:0 2px 10px rgba(0,0,0,0.1);margin-bottom:20px}table{width:100%%;border-collapse:collapse;margin-top:10px;font-size:14px}th{text-align:left;background:#fafafa;padding:10px;border-bottom:2px solid #eee}td{padding:12px 10px;border-bottom:1px solid #f5f5f5;color:#333}input,select{padding:6px 8px;border:1px solid #ccc;border-radius:4px}.del-btn{background:#fff;border:1px solid #ff3b30;color:#ff3b30;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px;margin-left:4px} .del-btn:hover{background:#ff3b30;color:white}.ok-btn{background:#fff;border:1px solid #0071e3;color:#0071e3;padding:4px 8px;border-radius:4px;cursor:pointer;font-size:12px;margin-left:4px} .ok-btn:hover{background:#0071e3;color:white}</style></head><body>
+	<div class="card"><h2 style="display:flex;justify-content:space-between">👥 用户管理 <a href="/" style="font-size:14px;color:#0071e3;text-decoration:none">返回首页</a></h2><table><thead><tr><th>用户名</th><th>角色</th><th>状态</th><th>两步验证</th><th>创建时间</th><th style="width:200px;text-align:center">操作</th></tr></thead><tbody>%s</tbody></table></div>
+	<div class="card"><h3>新建用户</h3><input id="nu" placeholder="用户名"> <input id="np" type="password" placeholder="密码 (至少 %d 位)"> <select id="nr">%s</select> <button onclick="createUser()" class="ok-btn">创建</button></div>
+	<script>async function post(url,body){try {let res = await fetch(url, {method: 'POST', headers: {'Content-Type': 'application/json'},body: JSON.stringify(body)});if(res.ok) location.reload(); else alert(await res.text());} catch(e){alert(e)}}
+	function userAction(body){post('/api/users/update',body)}
+	function resetPwd(u){var p=prompt('输入 '+u+' 的新密码');if(p)post('/api/users/update',{username:u,password:p})}
+	function reset2FA(u){if(confirm('确定清除 '+u+' 的两步验证吗？该用户下次登录需重新绑定'))post('/api/users/update',{username:u,reset_totp:true})}
+	function delUser(u){if(confirm('确定删除用户 '+u+' 吗？'))post('/api/users/delete',{username:u})}
+	function createUser(){post('/api/users/create',{username:document.getElementById('nu').value,password:document.getElementById('np').value,role:document.getElementById('nr').value})}</script></body></html>`,
+		rowsHtml, minPasswordLen, roleOptions(RoleViewer))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// UserRequest 是用户管理接口的请求体
+type UserRequest struct {
+	Token string `json:"token"`
+	UserChange
+}
+
+// handleUserSave 处理 /api/users/create 与 /api/users/update
+func handleUserSave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p := authorize(w, r, PermUsers, req.Token)
+	if p == nil { return }
+	u, err := saveUser(store, req.UserChange, r.URL.Path == "/api/users/create")
+	if err != nil { http.Error(w, err.Error(), 400); return }
+	if req.Password != "" || req.ResetTOTP { dropSessions(u.Username, nil) }
+	audit(r, p, "user.save", u.Username, fmt.Sprintf("role=%s disabled=%t password_reset=%t totp_reset=%t", u.Role, u.Disabled, req.Password != "", req.ResetTOTP))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"username": u.Username, "role": u.Role, "disabled": u.Disabled})
+}
+
+func handleUserDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	p := authorize(w, r, PermUsers, req.Token)
+	if p == nil { return }
+	found, err := deleteUser(store, req.Username)
+	if err != nil { http.Error(w, err.Error(), 400); return }
+	if !found { http.Error(w, "用户不存在", 404); return }
+	audit(r, p, "user.delete", normalizeUsername(req.Username), "")
+	w.Write([]byte("✅ 用户已删除"))
+}
+
+// handleChangePassword 登录用户修改自己的密码，需要提供旧密码
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "Method Not Allowed", 405); return }
+	p := authorize(w, r, "", "")
+	if p == nil { return }
+	if p.Key != nil { http.Error(w, "API Key 无法修改密码", 400); return }
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "JSON Error", 400); return }
+	u, err := getUser(store, p.Name)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if u == nil || !checkPassword(u.PasswordHash, req.OldPassword) { http.Error(w, "旧密码错误", 403); return }
+	if _, err := saveUser(store, UserChange{Username: u.Username, Password: req.NewPassword}, false); err != nil { http.Error(w, err.Error(), 400); return }
+	dropSessions(u.Username, p.session)
+	audit(r, p, "user.password", u.Username, "")
+	w.Write([]byte("✅ 密码已修改"))
+}
+
+// ================= 命令行 =================
+
+// cmdUser 离线管理用户，忘记管理员密码时使用。json 存储请在服务停止时执行。
+//
+//	license-server user list
+//	license-server user add -role issuer alice     (密码从标准输入读取)
+//	license-server user passwd alice
+//	license-server user role alice admin
+//	license-server user disable|enable|del alice
+//	license-server user reset-2fa alice
+func cmdUser(args []string) error {
+	fs := flag.NewFlagSet("user", flag.ExitOnError)
+	spec := fs.String("store", "", "存储 (类型:路径)，默认取环境变量")
+	role := fs.String("role", RoleViewer, "新用户的角色: admin / issuer / support / viewer")
+	if len(args) == 0 { return fmt.Errorf("用法: user list|add|passwd|role|disable|enable|del|reset-2fa [参数] 用户名") }
+	action := args[0]
+	fs.Parse(args[1:])
+	rest := fs.Args()
+
+	st, err := openStoreSpec(*spec)
+	if err != nil { return err }
+	defer st.Close()
+
+	if action == "list" {
+		users, err := listUsers(st)
+		if err != nil { return err }
+		sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+		for _, u := range users {
+			state := ""
+			if u.Disabled { state = " (已停用)" }
+			if u.TOTPSecret != "" { state += " [2FA]" }
+			fmt.Printf("%-24s %-8s %s%s\n", u.Username, u.Role, u.CreatedAt, state)
+		}
+		return nil
+	}
+	if len(rest) == 0 { return fmt.Errorf("缺少用户名") }
+	name := rest[0]
+	switch action {
+	case "add", "passwd":
+		var password string
+		if password, err = readPassword(); err != nil { return err }
+		c := UserChange{Username: name, Password: password}
+		if action == "add" { c.Role = *role }
+		_, err = saveUser(st, c, action == "add")
+	case "role":
+		if len(rest) < 2 { return fmt.Errorf("缺少角色") }
+		_, err = saveUser(st, UserChange{Username: name, Role: rest[1]}, false)
+	case "disable", "enable":
+		disabled := action == "disable"
+		_, err = saveUser(st, UserChange{Username: name, Disabled: &disabled}, false)
+	case "reset-2fa":
+		_, err = saveUser(st, UserChange{Username: name, ResetTOTP: true}, false)
+	case "del":
+		var found bool
+		if found, err = deleteUser(st, name); err == nil && !found { err = fmt.Errorf("用户 %s 不存在", name) }
+	default:
+		return fmt.Errorf("未知的操作: %s", action)
+	}
+	if err == nil { log.Printf(">>> ✅ 用户 %s: %s 完成", normalizeUsername(name), action) }
+	return err
+}
+
+// readPassword 从标准输入读取一行作为密码，便于脚本通过管道传入
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "请输入密码: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" { return "", fmt.Errorf("读取密码失败: %v", err) }
+	return strings.TrimRight(line, "\r\n"), nil
+}
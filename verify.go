@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"license-server/client"
+)
+
+// ================= 激活码校验 =================
+
+type VerifyRequest struct {
+	Code      string `json:"code"`
+	MachineID string `json:"machine_id,omitempty"`
+}
+
+type VerifyResponse struct {
+	Valid      bool   `json:"valid"`
+	LicenseID  string `json:"license_id,omitempty"`
+	Status     string `json:"status,omitempty"` // 服务端记录中的状态，找不到记录时为空
+	Format     string `json:"format,omitempty"`
+	MachineID  string `json:"machine_id,omitempty"`
+	ExpiryDate string `json:"expiry_date,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	Expired    bool   `json:"expired"`
+	Features   uint16 `json:"features"`
+	Product    string `json:"product,omitempty"`
+	KeyID      string `json:"key_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleVerify 公开的校验接口：自动识别标准激活码 / .lic 文件内容 / 短激活码 / JWT / PASETO，
+// 校验签名、机器码 (如提供) 和有效期。无需 Token。
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" { http.Error(w, "405", 405); return }
+	var req VerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, err.Error(), 400); return }
+	if VerifyRequireAuth && authorize(w, r, PermVerify, "") == nil { return }
+
+	resp := verifyLicenseCode(extractLicenseCode(req.Code), strings.TrimSpace(req.MachineID))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func verifyLicenseCode(code, machineID string) VerifyResponse {
+	if code == "" { return VerifyResponse{Error: "激活码为空"} }
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil { loc = time.FixedZone("CST", 8*3600) }
+
+	var resp VerifyResponse
+	var expiresAt time.Time
+	if client.LooksLikeJWT(code) || client.LooksLikePaseto(code) {
+		verify, format := client.VerifyJWT, "jwt"
+		if client.LooksLikePaseto(code) { verify, format = client.VerifyPaseto, "paseto" }
+		resp.Format = format
+		c, kid, err := verify(code, machineID, verificationKeys()...)
+		resp.KeyID = kid
+		if c != nil {
+			expiresAt = c.ExpiresTime()
+			resp.LicenseID, resp.MachineID, resp.Features, resp.Product, resp.ExpiryDate = c.ID, c.Subject, c.Features, c.Product, expiresAt.In(loc).Format("2006-01-02")
+		}
+		if err != nil { resp.Error = err.Error(); return resp }
+	} else if client.LooksLikeShortKey(code) {
+		resp.Format = "short"
+		priv, err := loadShortKeySigner()
+		if err != nil { resp.Error = err.Error(); return resp }
+		k, err := client.VerifyShortKey(code, machineID, priv.Public().(ed25519.PublicKey))
+		if k != nil {
+			resp.ExpiryDate, resp.Features, resp.KeyID = k.ExpiryDate(), k.Features, fmt.Sprintf("%02x", k.KeyID)
+			expiresAt = k.ExpiresAt()
+		}
+		if err != nil { resp.Error = err.Error(); return resp }
+		resp.MachineID = machineID
+		code = k.String() // 记录中保存的是规范写法，输入可能大小写不同或缺少分隔符
+	} else {
+		resp.Format = "classic"
+		privKey, err := loadPrivateKey()
+		if err != nil { resp.Error = err.Error(); return resp }
+		data, err := client.VerifyLicense(code, machineID, &privKey.PublicKey)
+		resp.KeyID = client.KeyID(&privKey.PublicKey)
+		if data != nil {
+			expiresAt = data.ExpiresAt()
+			resp.LicenseID, resp.MachineID, resp.Features, resp.ExpiryDate = data.LicenseID, data.MachineID, data.Features, expiresAt.In(loc).Format("2006-01-02")
+		}
+		if err != nil { resp.Error = err.Error(); return resp }
+	}
+
+	resp.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	resp.Expired = time.Now().After(expiresAt)
+	resp.Valid = !resp.Expired
+	if resp.Expired { resp.Error = "激活码已过期" }
+	if store != nil {
+		if rec := storedLicense(resp.LicenseID, code); rec != nil {
+			resp.Status = rec.Status
+			if rec.Status == LicenseRevoked { resp.Valid, resp.Error = false, "激活码已吊销" }
+		}
+	}
+	return resp
+}
+
+// storedLicense 查找激活码对应的服务端记录：带 ID 的按 ID 查找，短激活码和旧版标准激活码按原文查找。
+// 参数相同的短激活码原文相同，可能对应多条记录，其中任意一条被吊销即视为吊销
+func storedLicense(id, code string) *HistoryRecord {
+	if id != "" {
+		rec, err := store.GetLicense(id)
+		if err != nil { return nil }
+		return rec
+	}
+	recs, err := store.LicensesByCode(code)
+	if err != nil || len(recs) == 0 { return nil }
+	for i := range recs {
+		if recs[i].Status == LicenseRevoked { return &recs[i] }
+	}
+	return &recs[len(recs)-1]
+}
+
+// extractLicenseCode 兼容直接粘贴 .lic 文件全文：只取 BEGIN/END 之间的内容并去掉换行
+func extractLicenseCode(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, LicBegin); i >= 0 {
+		s = s[i+len(LicBegin):]
+		if j := strings.Index(s, LicEnd); j >= 0 { s = s[:j] }
+	}
+	return strings.Join(strings.Fields(s), "")
+}
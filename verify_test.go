@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"license-server/client"
+)
+
+// useShortKeySigner 生成临时 Ed25519 私钥，通过环境变量交给 loadShortKeySigner
+func useShortKeySigner(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil { t.Fatal(err) }
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil { t.Fatal(err) }
+	t.Setenv("ED25519_PRIVATE_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	return priv
+}
+
+// useStore 把全局 store 换成临时目录中的指定类型存储
+func useStore(t *testing.T, backend string) Store {
+	path := t.TempDir()
+	if backend != "json" { path = filepath.Join(path, "license."+backend) }
+	st, err := openStore(backend, path)
+	if err != nil { t.Fatal(err) }
+	old := store
+	store = st
+	t.Cleanup(func() { store = old; st.Close() })
+	return st
+}
+
+func TestVerifyRevokedShortKey(t *testing.T) {
+	priv := useShortKeySigner(t)
+	for _, backend := range []string{"json", "sqlite", "bolt"} {
+		t.Run(backend, func(t *testing.T) {
+			st := useStore(t, backend)
+			expiry := time.Now().AddDate(0, 0, 30).Format("2006-01-02")
+			code, err := client.SignShortKey("M-SHORT", expiry, 3, priv)
+			if err != nil { t.Fatal(err) }
+			rec := HistoryRecord{ID: newLicenseID(), MachineID: "M-SHORT", ExpiryDate: expiry, LicenseCode: code, Format: "short", Status: LicenseActive}
+			if err := st.AddLicenses([]HistoryRecord{rec}); err != nil { t.Fatal(err) }
+
+			// 输入时大小写、分隔符可能与保存的不同
+			input := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+			if resp := verifyLicenseCode(input, ""); !resp.Valid || resp.Status != LicenseActive { t.Fatalf("吊销前: %+v", resp) }
+
+			if found, err := st.UpdateLicense(rec.ID, func(r *HistoryRecord) { r.Status = LicenseRevoked }); !found || err != nil { t.Fatalf("吊销: %v %v", found, err) }
+			for _, machineID := range []string{"", "M-SHORT"} {
+				resp := verifyLicenseCode(input, machineID)
+				if resp.Valid || resp.Status != LicenseRevoked || resp.Error != "激活码已吊销" { t.Fatalf("吊销后 (机器码 %q): %+v", machineID, resp) }
+			}
+		})
+	}
+}